@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,6 +28,9 @@ type StreamPos struct {
 	marker  string
 }
 
+// trackerTimeout bounds every read and write of the tracker
+const trackerTimeout = 10 * time.Second
+
 //PreviousMarker ...
 type PreviousMarker struct {
 	LogFile LogFile
@@ -37,18 +42,59 @@ type CLI struct {
 	// Options is for command line options
 	Options *config.Options
 	// RDS is an initialized session connected to RDS
-	RDS *rds.RDS
+	RDS RDSAPI
 	// Abort carries a true message when we catch CTRL-C so we can clean up
 	Abort chan bool
+	// InstanceIdentifier is the RDS instance streamed or downloaded by this
+	// CLI. It is set on the per instance copies made by forInstance.
+	InstanceIdentifier string
+	// Throttle is the request budget shared by all instance streams
+	Throttle *Throttle
+	// Errors counts the failed RDS API calls of all instance streams
+	Errors *ErrorCounters
+	// Kafka is the writer shared by the kafka publishers of all streams
+	Kafka publisher.KafkaWriter
+	// HTTP configures the http publishers of all streams
+	HTTP publisher.HTTPConfig
+	// Honeycomb configures the honeycomb publishers of all streams
+	Honeycomb publisher.HoneycombConfig
+	// S3 is the uploader shared by the s3 publishers of all streams
+	S3 publisher.S3Uploader
+	// S3Config configures the s3 publishers of all streams
+	S3Config publisher.S3Config
+	// Redactor removes sensitive data from formatted events; the default
+	// rules are used when nil
+	Redactor *formatter.Redactor
 
 	// target to which to send output
 	output publisher.Publisher
 	// allow changing the time for tests
 	fakeNower Nower
+	// allow shortening the polling waits for tests
+	pollInterval time.Duration
+	// allow shortening the formatter flush timeout for tests
+	flushInterval time.Duration
+	// allow capturing the output in tests
+	fakePublisher publisher.Publisher
+	// allow shortening the lease ttl for tests
+	leaseTTL time.Duration
+	// allow shortening the retries of missing log files for tests
+	logRotationWindow time.Duration
 
 	PreviousMarker PreviousMarker `json:"PreviousMarker"`
 
 	Tracker tracker.Tracker
+	// committed is the marker last read from or committed to Tracker
+	committed string
+	// lease is held while streaming with --leader_election
+	lease *tracker.Lease
+
+	// fields added to every formatted event, e.g. the Aurora cluster role
+	fieldsMu    sync.RWMutex
+	eventFields map[string]string
+
+	// formatters keeps the formatter of every log file being read
+	formatters map[string]formatter.Formatter
 }
 
 // Stream polls the RDS log endpoint forever to effectively tail the logs and
@@ -57,9 +103,20 @@ func (c *CLI) Stream() error {
 	trackerEnabled := false
 	var logFilePath string
 
-	// Enabling Tracker
+	// incomplete entries of a previous run are read again from the tracker
+	// marker
+	c.formatters = nil
+
+	// Enabling Tracker. Without the committed marker the stream can't tell
+	// where to resume, so it doesn't start.
 	if c.Options.Tracker {
-		data := c.Tracker.ReadLatestMarker(c.Options.InstanceIdentifier)
+		ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+		data, err := c.Tracker.ReadMarker(ctx, c.trackerKey())
+		cancel()
+		if err != nil {
+			return fmt.Errorf("reading the marker of %s: %w", c.trackerKey(), err)
+		}
+		c.committed = data
 
 		if data != "" {
 			trackerEnabled = true
@@ -69,10 +126,10 @@ func (c *CLI) Stream() error {
 
 	// make sure we have a valid log file from which to stream
 	latestFile, err := c.GetLatestLogFile()
-	logFilePath = c.CreateFilePath(latestFile)
 	if err != nil {
 		return err
 	}
+	logFilePath = c.CreateFilePath(latestFile)
 
 	// forever, download the most recent entries
 	sPos := StreamPos{
@@ -80,15 +137,9 @@ func (c *CLI) Stream() error {
 	}
 
 	// create the chosen output publisher target
-	if c.Options.Output == constants.OutputStdOut {
-		c.output = &publisher.STDOUTPublisher{}
-	} else if c.Options.Output == constants.OutputFile {
-		c.output = &publisher.FILEPublisher{
-			FileName: latestFile.LogFileName,
-			Path:     &logFilePath,
-			Suffix:   &sPos.marker,
-		}
-	}
+	c.output = c.newPublisher(latestFile.LogFileName, &logFilePath, &sPos.marker)
+	defer c.output.Close()
+	lastData := time.Now()
 
 	for {
 		// check for signal triggered exit
@@ -101,13 +152,7 @@ func (c *CLI) Stream() error {
 		// get recent log entries
 		resp, err := c.getRecentEntries(sPos)
 		if err != nil {
-			if strings.HasPrefix(err.Error(), "Throttling: Rate exceeded") {
-				logrus.Warnf("AWS Rate limit hit; sleeping for %d seconds.\n", c.Options.BackoffTimer)
-				c.waitFor(time.Duration(c.Options.BackoffTimer) * time.Second)
-				continue
-			}
-
-			if strings.HasPrefix(err.Error(), "InvalidParameterValue: This file contains binary data") {
+			if classifyError(err) == errSkippable {
 				logrus.Warnf("binary data at marker %s, skipping 1000 in marker position\n", sPos.marker)
 				// skip over inaccessible data
 				newMarker, err := sPos.Add(1000)
@@ -119,17 +164,13 @@ func (c *CLI) Stream() error {
 					LogFile: sPos.logFile,
 					Marker:  sPos.marker,
 				}
-				c.updateTracker()
-				continue
-			}
-
-			if strings.HasPrefix(err.Error(), "DBLogFileNotFoundFault") {
-				logrus.WithError(err).
-					Warn("log does not appear to exist (rotation ongoing?) - waiting and retrying")
-				c.waitFor(time.Second * 5)
+				if err := c.updateTracker(); err != nil {
+					return err
+				}
 				continue
 			}
 
+			// retryable errors have been retried by getRecentEntries already
 			return err
 		}
 
@@ -143,21 +184,24 @@ func (c *CLI) Stream() error {
 				// will always be named
 				// slowquery/mysql-slowquery.log.
 				newestFile, err := c.GetLatestLogFile()
-				logFilePath = c.CreateFilePath(newestFile)
 				if err != nil {
 					return err
 				}
+				logFilePath = c.CreateFilePath(newestFile)
 				if newestFile.LogFileName != sPos.logFile.LogFileName {
 					logrus.WithFields(logrus.Fields{
 						"oldFile": sPos.logFile.LogFileName,
 						"newFile": newestFile.LogFileName}).Info("Found newer file")
+					if err := c.flushFormatter(c.output, sPos); err != nil {
+						return err
+					}
 					sPos.logFile = newestFile
 					continue
 				}
 			}
 
 			// Wait for a few seconds and try again.
-			c.waitFor(5 * time.Second)
+			c.waitFor(c.poll())
 		}
 
 		newMarker := c.getNextMarker(sPos, resp)
@@ -166,16 +210,17 @@ func (c *CLI) Stream() error {
 			logrus.WithFields(logrus.Fields{
 				"prevMarker": sPos.marker,
 				"newMarker":  newMarker,
-				"file":       sPos.logFile.LogFileName}).
+				"file":       sPos.logFile.LogFileName,
+				"instance":   c.InstanceIdentifier}).
 				Info("Got new marker")
 		}
 
 		if newMarker == "0" {
 			latestFile, err := c.GetLatestLogFile()
-			logFilePath = c.CreateFilePath(latestFile)
 			if err != nil {
 				return err
 			}
+			logFilePath = c.CreateFilePath(latestFile)
 			sPos.logFile = latestFile
 		}
 
@@ -186,46 +231,191 @@ func (c *CLI) Stream() error {
 			newMarkerInt, _ := strconv.Atoi(splitNewMarker[1])
 			newMarkerInt = newMarkerInt - len(*resp.LogFileData)
 
-			c1 := make(chan LogFile)
 			flag := false
 			if sPos.logFile.LastWritten-c.PreviousMarker.LogFile.LastWritten < 3600000 {
 				if splitMarker[0] == splitNewMarker[0] {
 					flag = true
-					suffix := "." + splitNewMarker[0] + "." + splitMarker[1] + "-" + strconv.Itoa(newMarkerInt)
-					sPos.logFile.Path = c.CreateFilePath(sPos.logFile, suffix)
-					go c.downloadFile(sPos.logFile, c1, c.PreviousMarker.Marker, splitMarker[1], strconv.Itoa(newMarkerInt))
+					// nothing was missed when the tail starts before the marker,
+					// e.g. when restarting without new data
+					if previousInt, _ := strconv.Atoi(splitMarker[1]); previousInt < newMarkerInt {
+						suffix := "." + splitNewMarker[0] + "." + splitMarker[1] + "-" + strconv.Itoa(newMarkerInt)
+						sPos.logFile.Path = c.CreateFilePath(sPos.logFile, suffix)
+						_, err = c.downloadFile(sPos.logFile, c.PreviousMarker.Marker, splitMarker[1], strconv.Itoa(newMarkerInt))
+					}
 				}
 			}
 			trackerEnabled = false
-			if flag {
-				<-c1
-			} else {
+			if !flag {
 				suffix := "." + splitNewMarker[0] + ".0-" + strconv.Itoa(newMarkerInt)
 				sPos.logFile.Path = c.CreateFilePath(sPos.logFile, suffix)
-				go c.downloadFile(sPos.logFile, c1, "0", "0", strconv.Itoa(newMarkerInt))
-				<-c1
+				_, err = c.downloadFile(sPos.logFile, "0", "0", strconv.Itoa(newMarkerInt))
+			}
+			if err != nil {
+				return err
 			}
 
 		}
 
+		// Writing data to Publisher. The marker only moves on once the data
+		// is published, so a failed publish is retried from the old marker
+		// when the stream restarts (at-least-once delivery).
+		// entries don't continue across hourly rotations of the log file, and
+		// an incomplete entry is given up on when no data arrived for a while
+		if rotated(sPos.marker, newMarker) {
+			if err := c.flushFormatter(c.output, sPos); err != nil {
+				return err
+			}
+		}
+		if aws.StringValue(resp.LogFileData) != "" {
+			lastData = time.Now()
+		} else if c.pendingBytes(sPos.logFile.LogFileName) > 0 && time.Since(lastData) >= c.flushTimeout() {
+			if err := c.flushFormatter(c.output, sPos); err != nil {
+				return err
+			}
+		}
+
+		src := publisher.Source{
+			Instance:    c.InstanceIdentifier,
+			LogFile:     sPos.logFile.LogFileName,
+			LastWritten: sPos.logFile.LastWritten,
+			From:        sPos.marker,
+			To:          newMarker,
+		}
+		if src.From == "" {
+			// the tail read without a marker ends at the new marker
+			end := StreamPos{marker: newMarker}
+			src.From, _ = end.Add(-len(aws.StringValue(resp.LogFileData)))
+		}
+		if err := c.publish(c.output, src, aws.StringValue(resp.LogFileData)); err != nil {
+			return err
+		}
+
 		sPos.marker = newMarker
 		c.PreviousMarker = PreviousMarker{
 			LogFile: sPos.logFile,
-			Marker:  sPos.marker,
+			Marker:  c.committedMarker(sPos),
+		}
+		// the stream doesn't move past a marker the tracker didn't commit;
+		// it is restarted from the last committed one
+		if err := c.updateTracker(); err != nil {
+			return err
+		}
+	}
+}
+
+// publish formats logFileData, read from src, writes it to output and
+// flushes output
+func (c *CLI) publish(output publisher.Publisher, src publisher.Source, logFileData string) error {
+	if logFileData == "" {
+		// buffered data is published once it got old enough, also when no
+		// new data arrives
+		if _, ok := output.(publisher.BufferingPublisher); ok {
+			if err := output.Flush(); err != nil {
+				return fmt.Errorf("failed to flush publisher: %w", err)
+			}
+		}
+		return nil
+	}
+	if sp, ok := output.(publisher.SourcePublisher); ok {
+		// the entry kept by the formatter is completed by logFileData and
+		// published with it
+		if pending := c.pendingBytes(src.LogFile); pending > 0 {
+			start := StreamPos{marker: src.From}
+			if from, err := start.Add(-pending); err == nil {
+				src.From = from
+			}
 		}
-		c.updateTracker()
+		sp.SetSource(src)
+	}
 
-		// Writing data to Publisher
-		if resp.LogFileData != nil && *resp.LogFileData != "" {
-			formattedData := c.formatLogFileData(*resp.LogFileData)
+	return c.write(output, c.formatLogFileData(src.LogFile, logFileData))
+}
 
-			for _, jsonData := range formattedData {
-				if jsonData != "" {
-					c.output.Write(jsonData + "\n")
-				}
+// write writes events to output and flushes output
+func (c *CLI) write(output publisher.Publisher, events []string) error {
+	for _, jsonData := range events {
+		if jsonData != "" {
+			if err := output.Write(jsonData + "\n"); err != nil {
+				return fmt.Errorf("failed to publish: %w", err)
 			}
 		}
 	}
+	if err := output.Flush(); err != nil {
+		return fmt.Errorf("failed to flush publisher: %w", err)
+	}
+	return nil
+}
+
+// flushFormatter publishes the incomplete entry kept by the formatter of the
+// log file of sPos, read up to sPos.marker, and drops the formatter
+func (c *CLI) flushFormatter(output publisher.Publisher, sPos StreamPos) error {
+	logFile := sPos.logFile.LogFileName
+	f, ok := c.formatters[logFile].(formatter.StreamFormatter)
+	if !ok {
+		return nil
+	}
+	if sp, ok := output.(publisher.SourcePublisher); ok && f.Pending() > 0 {
+		src := publisher.Source{
+			Instance:    c.InstanceIdentifier,
+			LogFile:     logFile,
+			LastWritten: sPos.logFile.LastWritten,
+			From:        sPos.marker,
+			To:          sPos.marker,
+		}
+		if start, err := sPos.Add(-f.Pending()); err == nil {
+			src.From = start
+		}
+		sp.SetSource(src)
+	}
+	events := f.Flush()
+	c.tagEvents(events)
+	delete(c.formatters, logFile)
+	return c.write(output, events)
+}
+
+// pendingBytes returns the size of the incomplete entry kept by the
+// formatter of logFile
+func (c *CLI) pendingBytes(logFile string) int {
+	if f, ok := c.formatters[logFile].(formatter.StreamFormatter); ok {
+		return f.Pending()
+	}
+	return 0
+}
+
+// committedMarker returns the marker to store in the tracker for sPos: the
+// start of the data the publisher kept back or of the incomplete entry kept
+// by the formatter, so a restart reads that data again.
+func (c *CLI) committedMarker(sPos StreamPos) string {
+	if bp, ok := c.output.(publisher.BufferingPublisher); ok {
+		if from, buffered := bp.Unpublished(); buffered && from != "" {
+			return from
+		}
+	}
+	pending := c.pendingBytes(sPos.logFile.LogFileName)
+	if pending == 0 {
+		return sPos.marker
+	}
+	marker, err := sPos.Add(-pending)
+	if err != nil {
+		return sPos.marker
+	}
+	return marker
+}
+
+// rotated reports whether the hour of the MySQL markers changed, i.e. the log
+// file has been rotated
+func rotated(oldMarker string, newMarker string) bool {
+	oldHour, _, oldOK := strings.Cut(oldMarker, ":")
+	newHour, _, newOK := strings.Cut(newMarker, ":")
+	return oldOK && newOK && oldHour != newHour
+}
+
+// flushTimeout returns how long an incomplete entry waits for more data
+func (c *CLI) flushTimeout() time.Duration {
+	if c.flushInterval > 0 {
+		return c.flushInterval
+	}
+	return time.Duration(c.Options.FlushTimeout) * time.Second
 }
 
 // getNextMarker takes in to account the current and next reported markers and
@@ -303,7 +493,7 @@ func (s *StreamPos) Add(dataLen int) (string, error) {
 // returns the downloaded data
 func (c *CLI) getRecentEntries(sPos StreamPos) (*rds.DownloadDBLogFilePortionOutput, error) {
 	params := &rds.DownloadDBLogFilePortionInput{
-		DBInstanceIdentifier: aws.String(c.Options.InstanceIdentifier),
+		DBInstanceIdentifier: aws.String(c.InstanceIdentifier),
 		LogFileName:          aws.String(sPos.logFile.LogFileName),
 		NumberOfLines:        aws.Int64(c.Options.NumLines),
 	}
@@ -313,11 +503,36 @@ func (c *CLI) getRecentEntries(sPos StreamPos) (*rds.DownloadDBLogFilePortionOut
 	} else {
 		params.NumberOfLines = aws.Int64(1)
 	}
-	return c.RDS.DownloadDBLogFilePortion(params)
+
+	var resp *rds.DownloadDBLogFilePortionOutput
+	err := c.withRetry("DownloadDBLogFilePortion", func() (err error) {
+		resp, err = c.RDS.DownloadDBLogFilePortion(params)
+		return err
+	})
+	return resp, err
 }
 
-// Download downloads RDS logs and reads them all in
+// Download downloads RDS logs of every configured, discovered or cluster member
+// instance and reads them
+// all in, one instance after the other.
 func (c *CLI) Download() error {
+	targets, err := c.streamTargets()
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		ic := c.forInstance(target.Identifier)
+		ic.setEventFields(target.Fields)
+		if err := ic.downloadInstance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadInstance downloads the RDS logs of c.InstanceIdentifier
+func (c *CLI) downloadInstance() error {
 	// get a list of RDS instances, return the one to use.
 	// if one's user supplied, verify it exists.
 	// if not user supplied and there's only one, use that
@@ -342,25 +557,25 @@ func (c *CLI) DownloadLogFiles(logFiles []LogFile) ([]LogFile, error) {
 	downloadedLogFiles := make([]LogFile, 0, len(logFiles))
 	for i := range logFiles {
 		// returned logFile has a modified Path
-		c1 := make(chan LogFile)
-		go c.downloadFile(logFiles[i], c1)
-		select {
-		case logFile := <-c1:
-			downloadedLogFiles = append(downloadedLogFiles, logFile)
+		logFile, err := c.downloadFile(logFiles[i])
+		if err != nil {
+			return downloadedLogFiles, err
 		}
+		downloadedLogFiles = append(downloadedLogFiles, logFile)
 	}
 	return downloadedLogFiles, nil
 }
 
 // downloadFile fetches an individual log file. Note that AWS's RDS
 // DownloadDBLogFilePortion only returns 1MB at a time, and we have to manually
-// paginate it ourselves.
-func (c *CLI) downloadFile(logFile LogFile, ch chan LogFile, customPathOptional ...string) (LogFile, error) {
+// paginate it ourselves. Failed calls are retried according to the retry
+// policy and binary data is skipped.
+func (c *CLI) downloadFile(logFile LogFile, customPathOptional ...string) (LogFile, error) {
 	logFileData := ""
 	var err error
 	var output publisher.Publisher
 	params := &rds.DownloadDBLogFilePortionInput{
-		DBInstanceIdentifier: aws.String(c.Options.InstanceIdentifier),
+		DBInstanceIdentifier: aws.String(c.InstanceIdentifier),
 		LogFileName:          aws.String(logFile.LogFileName),
 	}
 
@@ -371,21 +586,18 @@ func (c *CLI) downloadFile(logFile LogFile, ch chan LogFile, customPathOptional
 
 	if len(customPathOptional) < 1 {
 		logFile.Path = path.Join(c.Options.DownloadDir, path.Base(logFile.LogFileName))
+		if len(c.Options.InstanceIdentifiers) > 1 || c.Options.Discover || len(c.Options.Clusters) > 0 {
+			// keep same named log files of different instances apart
+			logFile.Path = path.Join(c.Options.DownloadDir, c.InstanceIdentifier, path.Base(logFile.LogFileName))
+		}
 	} else {
 		params.Marker = aws.String(customPathOptional[0])
 		resp.Marker = aws.String(customPathOptional[0])
 	}
 
-	if c.Options.Output == constants.OutputStdOut {
-		output = &publisher.STDOUTPublisher{}
-	} else if c.Options.Output == constants.OutputFile {
-		output = &publisher.FILEPublisher{
-			FileName: logFile.LogFileName,
-			Path:     &logFile.Path,
-		}
-	}
+	output = c.newPublisher(logFile.LogFileName, &logFile.Path, nil)
 
-	if c.Options.Download {
+	if c.Options.Download && c.Options.Output != constants.OutputS3 {
 		// open the out file for writing
 		logrus.Infof("Downloading %s to %s ... ", logFile.LogFileName, logFile.Path)
 		output = &publisher.FILEPublisher{
@@ -395,6 +607,13 @@ func (c *CLI) downloadFile(logFile LogFile, ch chan LogFile, customPathOptional
 	} else {
 		logrus.Infof("Downloading previous file %s in %s mode", logFile.LogFileName, c.Options.Output)
 	}
+	// a successful download is closed below, where the error of Close counts
+	closed := false
+	defer func() {
+		if !closed {
+			output.Close()
+		}
+	}()
 	defer logrus.Infof("done\n")
 
 	for aws.BoolValue(resp.AdditionalDataPending) {
@@ -406,9 +625,33 @@ func (c *CLI) downloadFile(logFile LogFile, ch chan LogFile, customPathOptional
 		}
 
 		params.Marker = resp.Marker // support pagination
-		resp, err = c.RDS.DownloadDBLogFilePortion(params)
+		var portion *rds.DownloadDBLogFilePortionOutput
+		err = c.withRetry("DownloadDBLogFilePortion", func() (err error) {
+			portion, err = c.RDS.DownloadDBLogFilePortion(params)
+			return err
+		})
 		if err != nil {
-			return logFile, err
+			if classifyError(err) != errSkippable {
+				return logFile, err
+			}
+			// skip over inaccessible data
+			pos := StreamPos{marker: aws.StringValue(params.Marker)}
+			newMarker, addErr := pos.Add(1000)
+			if addErr != nil {
+				return logFile, err
+			}
+			logrus.Warnf("binary data at marker %s in %s, skipping 1000 in marker position\n",
+				pos.marker, logFile.LogFileName)
+			resp.Marker = aws.String(newMarker)
+			continue
+		}
+		resp = portion
+		src := publisher.Source{
+			Instance:    c.InstanceIdentifier,
+			LogFile:     logFile.LogFileName,
+			LastWritten: logFile.LastWritten,
+			From:        aws.StringValue(params.Marker),
+			To:          aws.StringValue(resp.Marker),
 		}
 
 		if len(customPathOptional) > 2 {
@@ -417,27 +660,35 @@ func (c *CLI) downloadFile(logFile LogFile, ch chan LogFile, customPathOptional
 			logFileData = logFileData + aws.StringValue(resp.LogFileData)
 			end := endMarker - startMarker
 			if len(logFileData) >= end {
-				formattedData := c.formatLogFileData(logFileData[0:end])
-
-				for _, jsonData := range formattedData {
-					if jsonData != "" {
-						output.Write(jsonData + "\n")
-					}
+				hour, _, _ := strings.Cut(src.To, ":")
+				src.From = customPathOptional[0]
+				src.To = hour + ":" + customPathOptional[2]
+				if err := c.publish(output, src, logFileData[0:end]); err != nil {
+					return logFile, err
 				}
 				break
 			}
 		} else {
-			formattedData := c.formatLogFileData(aws.StringValue(resp.LogFileData))
-
-			for _, jsonData := range formattedData {
-				if jsonData != "" {
-					output.Write(jsonData + "\n")
-				}
+			if err := c.publish(output, src, aws.StringValue(resp.LogFileData)); err != nil {
+				return logFile, err
 			}
 		}
 	}
 
-	ch <- logFile
+	// the whole file has been read, unless only a range was asked for
+	if len(customPathOptional) < 3 {
+		end := StreamPos{logFile: logFile, marker: aws.StringValue(resp.Marker)}
+		if err := c.flushFormatter(output, end); err != nil {
+			return logFile, err
+		}
+	}
+
+	// the s3 publisher uploads whole files on Close
+	closed = true
+	if err := output.Close(); err != nil {
+		return logFile, err
+	}
+
 	logrus.Infof("file: %s is successfully downloaded", logFile.LogFileName)
 	return logFile, nil
 }
@@ -489,7 +740,9 @@ func (c *CLI) GetLatestLogFile() (LogFile, error) {
 
 	sort.SliceStable(logFiles, func(i, j int) bool { return logFiles[i].LastWritten < logFiles[j].LastWritten })
 	if c.PreviousMarker.LogFile.LastWritten > 0 && len(logFiles) > 1 {
-		c.DownloadPreviousFiles(logFiles[:len(logFiles)-1])
+		if err := c.DownloadPreviousFiles(logFiles[:len(logFiles)-1]); err != nil {
+			return LogFile{}, err
+		}
 	}
 	return logFiles[len(logFiles)-1], nil
 }
@@ -500,21 +753,19 @@ func (c *CLI) getListRDSLogFiles() ([]LogFile, error) {
 	var err error
 	var logFiles []LogFile
 	for {
-		if output == nil {
-			params := &rds.DescribeDBLogFilesInput{
-				DBInstanceIdentifier: &c.Options.InstanceIdentifier,
-			}
-			if c.PreviousMarker.LogFile.LastWritten > 0 {
-				params.FileLastWritten = aws.Int64(c.PreviousMarker.LogFile.LastWritten)
-			}
-			output, err = c.RDS.DescribeDBLogFiles(params)
-			logFiles = make([]LogFile, 0, len(output.DescribeDBLogFiles))
-		} else {
-			output, err = c.RDS.DescribeDBLogFiles(&rds.DescribeDBLogFilesInput{
-				DBInstanceIdentifier: &c.Options.InstanceIdentifier,
-				Marker:               output.Marker,
-			})
+		params := &rds.DescribeDBLogFilesInput{
+			DBInstanceIdentifier: &c.InstanceIdentifier,
+		}
+		if output != nil {
+			params.Marker = output.Marker
 		}
+		if c.PreviousMarker.LogFile.LastWritten > 0 {
+			params.FileLastWritten = aws.Int64(c.PreviousMarker.LogFile.LastWritten)
+		}
+		err = c.withRetry("DescribeDBLogFiles", func() (err error) {
+			output, err = c.RDS.DescribeDBLogFiles(params)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -535,10 +786,24 @@ func (c *CLI) getListRDSLogFiles() ([]LogFile, error) {
 	return logFiles, nil
 }
 
-// ValidateRDSInstance validates that you have a valid RDS instance to talk to.
+// ValidateRDSInstance validates that every requested RDS instance exists.
 // If an instance isn't specified and your credentials contain more than one RDS
 // instance, asks you to specify which instance you'd like to use.
+// In discovery mode only the discovery filters are checked and of --cluster
+// only the existence of the clusters, as their instances change over time.
 func (c *CLI) ValidateRDSInstance() error {
+	for _, clusterID := range c.Options.Clusters {
+		if _, err := c.describeDBClusters(clusterID); err != nil {
+			return err
+		}
+	}
+	if c.Options.Discover {
+		return c.validateDiscovery()
+	}
+	if len(c.Options.Clusters) > 0 && len(c.Options.InstanceIdentifiers) == 0 {
+		return nil
+	}
+
 	rdsInstances, err := c.getListRDSInstances()
 	if err != nil {
 		return err
@@ -549,17 +814,24 @@ func (c *CLI) ValidateRDSInstance() error {
 		return fmt.Errorf("The list of instances we got back from RDS is empty. Check the region and authentication?")
 	}
 
-	if c.Options.InstanceIdentifier != "" {
-		for _, instance := range rdsInstances {
-			if c.Options.InstanceIdentifier == instance {
-				// the user asked for an instance and we found it in the list. \o/
-				return nil
+	if len(c.Options.InstanceIdentifiers) > 0 {
+		for _, identifier := range c.Options.InstanceIdentifiers {
+			found := false
+			for _, instance := range rdsInstances {
+				if identifier == instance {
+					// the user asked for an instance and we found it in the list. \o/
+					found = true
+					break
+				}
+			}
+			if !found {
+				// the user asked for an instance but we didn't find it.
+				return fmt.Errorf("Instance identifier %s not found in list of instances:\n\t%s",
+					identifier,
+					strings.Join(rdsInstances, "\n\t"))
 			}
 		}
-		// the user asked for an instance but we didn't find it.
-		return fmt.Errorf("Instance identifier %s not found in list of instances:\n\t%s",
-			c.Options.InstanceIdentifier,
-			strings.Join(rdsInstances, "\n\t"))
+		return nil
 	}
 
 	// user didn't ask for an instance.
@@ -573,12 +845,12 @@ Please specify an instance identifier using the --identifier flag
 
 // gets a list of all avaialable RDS instances
 func (c *CLI) getListRDSInstances() ([]string, error) {
-	out, err := c.RDS.DescribeDBInstances(nil)
+	dbInstances, err := c.describeDBInstances()
 	if err != nil {
 		return nil, err
 	}
-	instances := make([]string, len(out.DBInstances))
-	for i, instance := range out.DBInstances {
+	instances := make([]string, len(dbInstances))
+	for i, instance := range dbInstances {
 		instances[i] = *instance.DBInstanceIdentifier
 	}
 	return instances, nil
@@ -593,24 +865,75 @@ func (c *CLI) waitFor(d time.Duration) {
 	}
 }
 
+// poll returns how long to wait before polling RDS again
+func (c *CLI) poll() time.Duration {
+	if c.pollInterval > 0 {
+		return c.pollInterval
+	}
+	return 5 * time.Second
+}
+
+// newPublisher creates the publisher chosen by --output for fileName. path
+// and suffix are read by the FILE publisher on every write.
+func (c *CLI) newPublisher(fileName string, path *string, suffix *string) publisher.Publisher {
+	if c.fakePublisher != nil {
+		return c.fakePublisher
+	}
+
+	if c.Options.Output == constants.OutputFile {
+		return &publisher.FILEPublisher{
+			FileName: fileName,
+			Path:     path,
+			Suffix:   suffix,
+		}
+	} else if c.Options.Output == constants.OutputKafka {
+		// the partition key choice has been validated at startup
+		key, _ := publisher.KafkaKey(c.Options.KafkaPartitionKey, c.InstanceIdentifier)
+		return &publisher.KafkaPublisher{
+			Writer:    c.Kafka,
+			BatchSize: c.Options.KafkaBatchSize,
+			Key:       key,
+		}
+	} else if c.Options.Output == constants.OutputHTTP {
+		// stopping the stream ends the retries of its publisher
+		httpConfig := c.HTTP
+		httpConfig.Abort = c.Abort
+		return &publisher.HTTPPublisher{Config: httpConfig}
+	} else if c.Options.Output == constants.OutputHoneycomb {
+		honeycombConfig := c.Honeycomb
+		honeycombConfig.Abort = c.Abort
+		return &publisher.HoneycombPublisher{Config: honeycombConfig}
+	} else if c.Options.Output == constants.OutputS3 {
+		return &publisher.S3Publisher{
+			Uploader: c.S3,
+			Config:   c.S3Config,
+			PerFile:  c.Options.Download,
+		}
+	}
+	return &publisher.STDOUTPublisher{}
+}
+
 // Nower interface abstracts time for testing
 type Nower interface {
 	Now() time.Time
 }
 
 //DownloadPreviousFiles ...
-func (c *CLI) DownloadPreviousFiles(logFiles []LogFile) {
+func (c *CLI) DownloadPreviousFiles(logFiles []LogFile) error {
 	for _, logFile := range logFiles {
-		c1 := make(chan LogFile)
+		var err error
 		logFile.Path = c.CreateFilePath(logFile)
 		if size, ok := logFile.MatchFileWithMarker(c.PreviousMarker.Marker); ok {
 			logFile.Path = logFile.Path + "." + size
-			go c.downloadFile(logFile, c1, c.PreviousMarker.Marker)
+			_, err = c.downloadFile(logFile, c.PreviousMarker.Marker)
 		} else {
-			go c.downloadFile(logFile, c1, "0")
+			_, err = c.downloadFile(logFile, "0")
+		}
+		if err != nil {
+			return err
 		}
-		<-c1
 	}
+	return nil
 }
 
 //CreateFilePath ....
@@ -624,32 +947,91 @@ func (c *CLI) CreateFilePath(logFiles LogFile, suffix ...string) string {
 	return strings.Join([]string{
 		c.Options.DownloadDir,
 		splitFile[0],
-		c.Options.InstanceIdentifier,
+		c.InstanceIdentifier,
 		TimeFormat,
 		splitFile[1],
 	}, "/")
 }
 
-func (c *CLI) updateTracker() {
-	if c.Options.Tracker {
-		e, _ := json.Marshal(c.PreviousMarker)
-		c.Tracker.WriteLatestMarker(c.Options.InstanceIdentifier, string(e))
+// updateTracker commits c.PreviousMarker to the tracker unless it is
+// committed already
+func (c *CLI) updateTracker() error {
+	if !c.Options.Tracker {
+		return nil
 	}
+	e, err := json.Marshal(c.PreviousMarker)
+	if err != nil {
+		return err
+	}
+	if string(e) == c.committed {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+	defer cancel()
+	if c.lease != nil {
+		// the marker isn't committed once a standby took over
+		err = c.Tracker.(tracker.Leaser).WriteFencedMarker(ctx, *c.lease, string(e))
+	} else {
+		err = c.Tracker.WriteMarker(ctx, c.trackerKey(), string(e))
+	}
+	if err != nil {
+		return fmt.Errorf("committing marker %s of %s: %w", c.PreviousMarker.Marker, c.trackerKey(), err)
+	}
+	c.committed = string(e)
+	return nil
 }
 
-func (c *CLI) formatLogFileData(logFileData string) []string {
-	var formattedData []string
+// trackerKey returns the tracker key of the log streamed by c
+func (c *CLI) trackerKey() tracker.Key {
+	return tracker.Key{
+		Account:  c.Options.TrackerAccount,
+		Region:   c.Options.Region,
+		Instance: c.InstanceIdentifier,
+		LogType:  c.Options.LogType,
+	}
+}
 
-	if c.Options.Formatter {
-		if c.Options.DBType == constants.DBTypeMySQL {
-			formatter := &formatter.MySQLFormatter{}
+// newFormatter returns the formatter of the --dbtype and --log_type logs
+func (c *CLI) newFormatter() formatter.Formatter {
+	if c.Options.DBType == constants.DBTypeMySQL {
+		switch c.Options.LogType {
+		case constants.LogTypeAudit:
+			return &formatter.MySQLAuditFormatter{Redactor: c.Redactor}
+		case constants.LogTypeError:
+			return &formatter.MySQLErrorFormatter{Redactor: c.Redactor}
+		case constants.LogTypeGeneral:
+			return &formatter.MySQLGeneralFormatter{Redactor: c.Redactor}
+		}
+		return &formatter.MySQLFormatter{Redactor: c.Redactor}
+	} else if c.Options.DBType == constants.DBTypePostgreSQL {
+		return &formatter.PostgresFormatter{
+			Prefix:      c.Options.LogLinePrefix,
+			SeqScanRows: c.Options.SeqScanRows,
+			Redactor:    c.Redactor,
+		}
+	}
+	return nil
+}
 
-			formattedData = formatter.Format(logFileData)
-		} else if c.Options.DBType == constants.DBTypePostgreSQL {
-			formatter := &formatter.PostgresFormatter{}
+// formatLogFileData formats logFileData read from logFile with the formatter
+// of logFile
+func (c *CLI) formatLogFileData(logFile string, logFileData string) []string {
+	var formattedData []string
 
-			formattedData = formatter.Format(logFileData)
+	if c.Options.Formatter {
+		f, ok := c.formatters[logFile]
+		if !ok {
+			f = c.newFormatter()
+			if c.formatters == nil {
+				c.formatters = map[string]formatter.Formatter{}
+			}
+			c.formatters[logFile] = f
 		}
+		if f != nil {
+			formattedData = f.Format(logFileData)
+		}
+
+		c.tagEvents(formattedData)
 	} else {
 		formattedData = []string{logFileData}
 	}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+const (
+	// RoleWriter is the role of the writer instance of an Aurora cluster
+	RoleWriter = "writer"
+	// RoleReader is the role of the reader instances of an Aurora cluster
+	RoleReader = "reader"
+)
+
+// clusterTargets returns a stream target for every member instance of the
+// clusters given by --cluster. Its events are tagged with the cluster id,
+// instance id and the current role of the instance.
+func (c *CLI) clusterTargets() ([]streamTarget, error) {
+	var targets []streamTarget
+	for _, clusterID := range c.Options.Clusters {
+		clusters, err := c.describeDBClusters(clusterID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cluster := range clusters {
+			for _, member := range cluster.DBClusterMembers {
+				role := RoleReader
+				if aws.BoolValue(member.IsClusterWriter) {
+					role = RoleWriter
+				}
+				targets = append(targets, streamTarget{
+					Identifier: aws.StringValue(member.DBInstanceIdentifier),
+					Fields: map[string]string{
+						"ClusterIdentifier":  aws.StringValue(cluster.DBClusterIdentifier),
+						"InstanceIdentifier": aws.StringValue(member.DBInstanceIdentifier),
+						"InstanceRole":       role,
+					},
+				})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// describeDBClusters returns the cluster clusterID, following the pagination
+// markers.
+func (c *CLI) describeDBClusters(clusterID string) ([]*rds.DBCluster, error) {
+	var clusters []*rds.DBCluster
+	params := &rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(clusterID),
+	}
+	for {
+		var output *rds.DescribeDBClustersOutput
+		err := c.withRetry("DescribeDBClusters", func() (err error) {
+			output, err = c.RDS.DescribeDBClusters(params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, output.DBClusters...)
+		if aws.StringValue(output.Marker) == "" {
+			break
+		}
+		params.Marker = output.Marker
+	}
+	return clusters, nil
+}
+
+// setEventFields replaces the fields added to every formatted event
+func (c *CLI) setEventFields(fields map[string]string) {
+	c.fieldsMu.Lock()
+	defer c.fieldsMu.Unlock()
+	c.eventFields = fields
+}
+
+// tagEvents adds the event fields of c to the formatted events in place.
+// Without fields the events are left alone rather than decoded.
+func (c *CLI) tagEvents(events []string) {
+	c.fieldsMu.RLock()
+	fields := c.eventFields
+	c.fieldsMu.RUnlock()
+	if len(fields) == 0 {
+		return
+	}
+	for i := range events {
+		events[i] = tagEvent(events[i], fields)
+	}
+}
+
+// tagEvent adds fields to a formatted JSON event. Events which aren't JSON
+// objects are returned unchanged.
+func tagEvent(event string, fields map[string]string) string {
+	if !strings.HasPrefix(event, "{") {
+		return event
+	}
+
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(event))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return event
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+
+	tagged, err := json.Marshal(data)
+	if err != nil {
+		return event
+	}
+	return string(tagged)
+}
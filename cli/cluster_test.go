@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/razorpay/rdslogs/rdstest"
+)
+
+func TestClusterTargets(t *testing.T) {
+	fake := rdstest.New()
+	fake.SetCluster("cluster", "writer", "reader1", "reader2")
+	fake.SetCluster("other", "other-writer")
+	c := newTestCLI(fake, nil)
+	c.Options.Clusters = []string{"cluster"}
+
+	targets, err := c.clusterTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []streamTarget{
+		{Identifier: "writer", Fields: map[string]string{"ClusterIdentifier": "cluster", "InstanceIdentifier": "writer", "InstanceRole": RoleWriter}},
+		{Identifier: "reader1", Fields: map[string]string{"ClusterIdentifier": "cluster", "InstanceIdentifier": "reader1", "InstanceRole": RoleReader}},
+		{Identifier: "reader2", Fields: map[string]string{"ClusterIdentifier": "cluster", "InstanceIdentifier": "reader2", "InstanceRole": RoleReader}},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("expected %v, got %v", want, targets)
+	}
+
+	c.Options.Clusters = []string{"missing"}
+	if _, err := c.clusterTargets(); err == nil {
+		t.Error("expected an error for an unknown cluster")
+	}
+}
+
+func TestReconcileUpdatesTheRolesAfterAFailover(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("writer", "aurora-mysql", nil)
+	fake.AddInstance("reader", "aurora-mysql", nil)
+	fake.SetCluster("cluster", "writer", "reader")
+	c := newTestCLI(fake, nil)
+	c.Options.InstanceIdentifiers = nil
+	c.Options.Clusters = []string{"cluster"}
+	set := &streamSet{parent: c, streams: make(map[string]*instanceStream)}
+	defer set.stopAll()
+
+	refresh := func() {
+		t.Helper()
+		targets, err := c.streamTargets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.reconcile(targets)
+	}
+	role := func(id string) string {
+		t.Helper()
+		stream, ok := set.streams[id]
+		if !ok {
+			t.Fatalf("no stream of %s", id)
+		}
+		stream.cli.fieldsMu.RLock()
+		defer stream.cli.fieldsMu.RUnlock()
+		return stream.cli.eventFields["InstanceRole"]
+	}
+
+	refresh()
+	if role("writer") != RoleWriter || role("reader") != RoleReader {
+		t.Errorf("unexpected roles before the failover: %s, %s", role("writer"), role("reader"))
+	}
+	stream := set.streams["reader"]
+
+	// the reader is promoted, its running stream is kept
+	fake.SetCluster("cluster", "reader", "writer")
+	refresh()
+	if role("writer") != RoleReader || role("reader") != RoleWriter {
+		t.Errorf("unexpected roles after the failover: %s, %s", role("writer"), role("reader"))
+	}
+	if set.streams["reader"] != stream {
+		t.Error("expected the stream of the promoted reader to keep running")
+	}
+}
+
+func TestTagEvents(t *testing.T) {
+	c := &CLI{}
+	events := []string{`{"Query":"select 1"}`}
+	c.tagEvents(events)
+	if events[0] != `{"Query":"select 1"}` {
+		t.Errorf("event without fields changed: %s", events[0])
+	}
+
+	c.setEventFields(map[string]string{"InstanceRole": RoleWriter})
+	events = []string{`{"Query":"select 1","Timestamp":1660000000123}`, "DATA: not json"}
+	c.tagEvents(events)
+	if events[0] != `{"InstanceRole":"writer","Query":"select 1","Timestamp":1660000000123}` {
+		t.Errorf("unexpected tagged event %s", events[0])
+	}
+	if events[1] != "DATA: not json" {
+		t.Errorf("non JSON event changed: %s", events[1])
+	}
+}
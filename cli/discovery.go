@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/razorpay/rdslogs/constants"
+)
+
+// DiscoverInstances returns the sorted identifiers of all instances matching
+// the --discover_* filters. Instances that are being deleted are left out so
+// their streams get stopped.
+func (c *CLI) DiscoverInstances() ([]string, error) {
+	instances, err := c.describeDBInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var identifiers []string
+	for _, instance := range instances {
+		if c.matchesDiscovery(instance) {
+			identifiers = append(identifiers, aws.StringValue(instance.DBInstanceIdentifier))
+		}
+	}
+	sort.Strings(identifiers)
+	return identifiers, nil
+}
+
+// validateDiscovery checks the discovery filters against each other and
+// against --dbtype.
+func (c *CLI) validateDiscovery() error {
+	if _, err := path.Match(c.Options.DiscoverPattern, ""); err != nil {
+		return fmt.Errorf("invalid --discover_pattern %q: %s", c.Options.DiscoverPattern, err)
+	}
+
+	supported := constants.DBTypeEngines[c.Options.DBType]
+	for _, engine := range c.Options.DiscoverEngines {
+		if !contains(supported, engine) {
+			return fmt.Errorf("engine %s can't be read as dbtype %s. Accepted engines: %s",
+				engine, c.Options.DBType, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+func (c *CLI) matchesDiscovery(instance *rds.DBInstance) bool {
+	if aws.StringValue(instance.DBInstanceStatus) == "deleting" {
+		return false
+	}
+
+	engines := c.Options.DiscoverEngines
+	if len(engines) == 0 {
+		engines = constants.DBTypeEngines[c.Options.DBType]
+	}
+	if !contains(engines, aws.StringValue(instance.Engine)) {
+		return false
+	}
+
+	if c.Options.DiscoverPattern != "" {
+		ok, _ := path.Match(c.Options.DiscoverPattern, aws.StringValue(instance.DBInstanceIdentifier))
+		if !ok {
+			return false
+		}
+	}
+
+	for _, filter := range c.Options.DiscoverTags {
+		if !hasTag(instance.TagList, filter) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTag reports whether tags contain filter, given as key or key=value
+func hasTag(tags []*rds.Tag, filter string) bool {
+	key, value, matchValue := strings.Cut(filter, "=")
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != key {
+			continue
+		}
+		if !matchValue || aws.StringValue(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// describeDBInstances returns all RDS instances, following the pagination
+// markers.
+func (c *CLI) describeDBInstances() ([]*rds.DBInstance, error) {
+	var instances []*rds.DBInstance
+	params := &rds.DescribeDBInstancesInput{}
+	for {
+		var output *rds.DescribeDBInstancesOutput
+		err := c.withRetry("DescribeDBInstances", func() (err error) {
+			output, err = c.RDS.DescribeDBInstances(params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, output.DBInstances...)
+		if aws.StringValue(output.Marker) == "" {
+			break
+		}
+		params.Marker = output.Marker
+	}
+	return instances, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/rdstest"
+)
+
+func newDiscoveryRDS() *rdstest.FakeRDS {
+	fake := rdstest.New()
+	fake.PageSize = 2
+	fake.AddInstance("prod-db1", "mysql", map[string]string{"env": "prod", "team": "core"})
+	fake.AddInstance("prod-db2", "mariadb", map[string]string{"env": "prod", "team": "web"})
+	fake.AddInstance("staging-db1", "mysql", map[string]string{"env": "staging", "team": "core"})
+	fake.AddInstance("prod-pg", "postgres", map[string]string{"env": "prod", "team": "core"})
+	fake.AddInstance("prod-aurora", "aurora-mysql", map[string]string{"env": "prod"})
+	return fake
+}
+
+func TestDiscoverInstances(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tags    []string
+		engines []string
+		pattern string
+		want    []string
+	}{
+		{name: "engines of the dbtype", want: []string{"prod-aurora", "prod-db1", "prod-db2", "staging-db1"}},
+		{name: "tag key", tags: []string{"team"}, want: []string{"prod-db1", "prod-db2", "staging-db1"}},
+		{name: "tag values", tags: []string{"env=prod", "team=core"}, want: []string{"prod-db1"}},
+		{name: "engine", engines: []string{"mariadb", "aurora-mysql"}, want: []string{"prod-aurora", "prod-db2"}},
+		{name: "pattern", pattern: "prod-db*", want: []string{"prod-db1", "prod-db2"}},
+		{name: "no match", tags: []string{"env=dev"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newDiscoveryRDS()
+			c := newTestCLI(fake, nil)
+			c.Options.DiscoverTags = tc.tags
+			c.Options.DiscoverEngines = tc.engines
+			c.Options.DiscoverPattern = tc.pattern
+
+			got, err := c.DiscoverInstances()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+			// 5 instances in pages of 2
+			if calls := fake.Calls(rdstest.OpDescribeDBInstances); calls != 3 {
+				t.Errorf("expected 3 paginated DescribeDBInstances calls, got %d", calls)
+			}
+		})
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	c := newTestCLI(rdstest.New(), nil)
+	c.Options.DiscoverPattern = "prod-*"
+	c.Options.DiscoverEngines = []string{"mysql", "aurora-mysql"}
+	if err := c.validateDiscovery(); err != nil {
+		t.Errorf("expected valid filters, got %v", err)
+	}
+
+	c.Options.DiscoverPattern = "prod-[db"
+	if err := c.validateDiscovery(); err == nil {
+		t.Error("expected the malformed pattern to be refused")
+	}
+
+	c.Options.DiscoverPattern = ""
+	c.Options.DiscoverEngines = []string{"postgres"}
+	if err := c.validateDiscovery(); err == nil {
+		t.Error("expected an engine of another dbtype to be refused")
+	}
+	c.Options.DBType = constants.DBTypePostgreSQL
+	if err := c.validateDiscovery(); err != nil {
+		t.Errorf("expected the postgres engine for dbtype postgresql, got %v", err)
+	}
+}
+
+func TestReconcileStopsTheStreamsOfRemovedInstances(t *testing.T) {
+	fake := newDiscoveryRDS()
+	fake.AppendLog("prod-db1", slowLog, "db1 start\n")
+	fake.AppendLog("prod-db2", slowLog, "db2 start\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Discover = true
+	c.Options.DiscoverPattern = "prod-db*"
+	set := &streamSet{parent: c, streams: make(map[string]*instanceStream)}
+	defer set.stopAll()
+
+	targets, err := c.streamTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.reconcile(targets)
+	waitForOutput(t, out, "db1 start\n")
+	waitForOutput(t, out, "db2 start\n")
+	removed := set.streams["prod-db2"]
+
+	// the next refresh doesn't find prod-db2 anymore
+	fake.RemoveInstance("prod-db2")
+	if targets, err = c.streamTargets(); err != nil {
+		t.Fatal(err)
+	}
+	set.reconcile(targets)
+	if _, ok := set.streams["prod-db2"]; ok || len(set.streams) != 1 {
+		t.Errorf("expected only the stream of prod-db1 to be left, got %v", set.streams)
+	}
+	select {
+	case <-removed.done:
+	default:
+		t.Error("expected the stream of prod-db2 to have returned")
+	}
+
+	fake.AppendLog("prod-db1", slowLog, "db1 more\n")
+	waitForOutput(t, out, "db1 more\n")
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sirupsen/logrus"
+)
+
+// errorClass says how a failed RDS API call is handled
+type errorClass int
+
+const (
+	// errFatal errors are returned to the caller right away
+	errFatal errorClass = iota
+	// errRetryable errors are retried with an exponential backoff
+	errRetryable
+	// errSkippable errors make the caller skip the affected data
+	errSkippable
+)
+
+func (e errorClass) String() string {
+	switch e {
+	case errRetryable:
+		return "retryable"
+	case errSkippable:
+		return "skippable"
+	default:
+		return "fatal"
+	}
+}
+
+// classifyError sorts an RDS API error in to an errorClass by its AWS error
+// code.
+func classifyError(err error) errorClass {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return errFatal
+	}
+
+	switch aerr.Code() {
+	case "InvalidParameterValue":
+		if strings.Contains(aerr.Message(), "binary data") {
+			return errSkippable
+		}
+		return errFatal
+	case rds.ErrCodeDBLogFileNotFoundFault:
+		// the log file is being rotated
+		return errRetryable
+	}
+
+	if request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+		return errRetryable
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+		return errRetryable
+	}
+	return errFatal
+}
+
+// ErrorCounters counts the failed RDS API calls of all instance streams per
+// errorClass. A nil *ErrorCounters counts nothing.
+type ErrorCounters struct {
+	Retryable int64
+	Skippable int64
+	Fatal     int64
+}
+
+func (e *ErrorCounters) count(class errorClass) {
+	if e == nil {
+		return
+	}
+
+	switch class {
+	case errRetryable:
+		atomic.AddInt64(&e.Retryable, 1)
+	case errSkippable:
+		atomic.AddInt64(&e.Skippable, 1)
+	default:
+		atomic.AddInt64(&e.Fatal, 1)
+	}
+}
+
+// Fields returns the current counts as log fields
+func (e *ErrorCounters) Fields() logrus.Fields {
+	if e == nil {
+		return logrus.Fields{}
+	}
+
+	return logrus.Fields{
+		"retryableErrors": atomic.LoadInt64(&e.Retryable),
+		"skippableErrors": atomic.LoadInt64(&e.Skippable),
+		"fatalErrors":     atomic.LoadInt64(&e.Fatal),
+	}
+}
+
+// logRotationWindow is how long a missing log file is retried for: long
+// enough for RDS to finish rotating it, short enough for a log file which is
+// really gone to stop the stream
+const logRotationWindow = 2 * time.Minute
+
+// withRetry makes an RDS API call named op, retrying retryable errors with an
+// exponential backoff with jitter until --max_retries is reached. Throttling
+// is retried until Abort fires, as it passes by itself, and holds back the
+// calls of every stream sharing the throttle. A missing log file is retried
+// for logRotationWindow whatever --max_retries, as it may be being rotated.
+// Skippable and fatal errors are returned right away for the caller to
+// handle.
+func (c *CLI) withRetry(op string, call func() error) error {
+	var missingSince time.Time
+	for attempt := 0; ; attempt++ {
+		c.Throttle.wait(c.Abort)
+		err := call()
+		if err == nil {
+			return nil
+		}
+
+		class := classifyError(err)
+		c.Errors.count(class)
+		log := logrus.WithError(err).WithFields(c.Errors.Fields()).WithFields(logrus.Fields{
+			"op":       op,
+			"class":    class.String(),
+			"attempt":  attempt + 1,
+			"instance": c.InstanceIdentifier,
+		})
+		if class != errRetryable {
+			return err
+		}
+		switch {
+		case request.IsErrorThrottle(err):
+		case isLogFileNotFound(err):
+			if missingSince.IsZero() {
+				missingSince = time.Now()
+			}
+			if time.Since(missingSince) >= c.rotationWindow() {
+				log.Error("log file still missing after the rotation window; giving up")
+				return err
+			}
+		case c.Options.MaxRetries > 0 && int64(attempt) >= c.Options.MaxRetries:
+			log.Error("RDS call failed; giving up")
+			return err
+		}
+
+		delay := c.backoffDelay(attempt)
+		if request.IsErrorThrottle(err) {
+			c.Throttle.backoff(delay)
+		}
+		log.Warnf("RDS call failed; retrying in %s", delay)
+		c.waitFor(delay)
+		if c.aborted() {
+			return err
+		}
+	}
+}
+
+// isLogFileNotFound reports whether err says the log file doesn't exist,
+// which it briefly doesn't while being rotated
+func isLogFileNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == rds.ErrCodeDBLogFileNotFoundFault
+}
+
+// rotationWindow returns how long a missing log file is retried for
+func (c *CLI) rotationWindow() time.Duration {
+	if c.logRotationWindow > 0 {
+		return c.logRotationWindow
+	}
+	return logRotationWindow
+}
+
+// backoffDelay returns the wait before retry number attempt: --backoff_timer
+// doubled per attempt, capped at --backoff_max, of which the upper half is
+// random jitter.
+func (c *CLI) backoffDelay(attempt int) time.Duration {
+	delay := time.Duration(c.Options.BackoffTimer) * time.Second
+	max := time.Duration(c.Options.BackoffMax) * time.Second
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// aborted reports whether Abort fired
+func (c *CLI) aborted() bool {
+	select {
+	case <-c.Abort:
+		return true
+	default:
+		return false
+	}
+}
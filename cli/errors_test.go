@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/rdstest"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want errorClass
+	}{
+		{rdstest.ThrottlingError(), errRetryable},
+		{awserr.New("RequestLimitExceeded", "slow down", nil), errRetryable},
+		{rdstest.LogFileNotFoundError("error/postgresql.log"), errRetryable},
+		{awserr.New("RequestError", "send request failed", errors.New("connection refused")), errRetryable},
+		{awserr.NewRequestFailure(awserr.New("InternalFailure", "oops", nil), 503, "id"), errRetryable},
+		{rdstest.BinaryDataError(), errSkippable},
+		{awserr.New("InvalidParameterValue", "Invalid marker", nil), errFatal},
+		{awserr.New("DBInstanceNotFound", "DBInstance db1 not found.", nil), errFatal},
+		{awserr.New("NoCredentialProviders", "no valid providers in chain", nil), errFatal},
+		{errors.New("not an AWS error"), errFatal},
+	} {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Errorf("%v: expected %s, got %s", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	c := &CLI{Options: &config.Options{BackoffTimer: 5, BackoffMax: 60}}
+	for attempt, max := range []time.Duration{5, 10, 20, 40, 60, 60} {
+		max *= time.Second
+		if d := c.backoffDelay(attempt); d < max/2 || d > max {
+			t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestWithRetryGivesUp(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	c := newTestCLI(fake, nil)
+	c.Options.MaxRetries = 2
+	c.Errors = &ErrorCounters{}
+	for i := 0; i < 5; i++ {
+		fake.QueueError(rdstest.OpDescribeDBInstances, awserr.NewRequestFailure(awserr.New("InternalFailure", "boom", nil), 500, "id"))
+	}
+
+	if _, err := c.describeDBInstances(); classifyError(err) != errRetryable {
+		t.Errorf("expected the server error, got %v", err)
+	}
+	if calls := fake.Calls(rdstest.OpDescribeDBInstances); calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	if c.Errors.Retryable != 3 {
+		t.Errorf("expected 3 retryable errors counted, got %d", c.Errors.Retryable)
+	}
+}
+
+func TestWithRetryKeepsRetryingThrottling(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	c := newTestCLI(fake, nil)
+	c.Options.MaxRetries = 2
+	for i := 0; i < 3; i++ {
+		fake.QueueError(rdstest.OpDescribeDBInstances, rdstest.ThrottlingError())
+	}
+	fake.QueueError(rdstest.OpDescribeDBInstances, rdstest.LogFileNotFoundError(slowLog))
+
+	if _, err := c.describeDBInstances(); err != nil {
+		t.Errorf("expected throttling and a rotating log file to be retried past --max_retries, got %v", err)
+	}
+	if calls := fake.Calls(rdstest.OpDescribeDBInstances); calls != 5 {
+		t.Errorf("expected 5 calls, got %d", calls)
+	}
+}
+
+func TestWithRetryGivesUpOnAMissingLogFile(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	c := newTestCLI(fake, nil)
+	c.Options.MaxRetries = 0
+	c.Options.BackoffTimer = 0
+	c.logRotationWindow = 20 * time.Millisecond
+
+	_, err := c.getRecentEntries(StreamPos{logFile: LogFile{LogFileName: "gone.log"}, marker: "0:0"})
+	if !isLogFileNotFound(err) {
+		t.Fatalf("expected the missing log file error after the rotation window, got %v", err)
+	}
+	if calls := fake.Calls(rdstest.OpDownloadDBLogFilePortion); calls < 2 {
+		t.Errorf("expected the missing log file to be retried, got %d calls", calls)
+	}
+}
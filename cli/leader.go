@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/razorpay/rdslogs/tracker"
+	"github.com/sirupsen/logrus"
+)
+
+// streamWhileLeader waits as a standby until c gets the lease of its tracker
+// key, then streams from the committed marker until stop closes or the lease
+// is lost. The lease is renewed every third of its ttl and released when the
+// stream ends; its markers are fenced, so a leader which lost the lease
+// can't commit any more.
+func (c *CLI) streamWhileLeader(stop chan bool) error {
+	leaser, ok := c.Tracker.(tracker.Leaser)
+	if !ok {
+		return fmt.Errorf("leader election needs the redis or file tracker")
+	}
+	lease, err := c.acquireLease(leaser, stop)
+	if err != nil {
+		return err
+	}
+	logger := logrus.WithFields(logrus.Fields{"instance": c.InstanceIdentifier, "lease": lease.String()})
+	logger.Info("Acquired the lease, streaming")
+
+	// term ends the stream like Abort, also when the lease is lost
+	term := make(chan bool)
+	done := make(chan struct{})
+	lost := make(chan error, 1)
+	go func() {
+		defer close(term)
+		lost <- c.keepLease(leaser, lease, stop, done)
+	}()
+
+	c.lease = &lease
+	c.Abort = term
+	err = c.Stream()
+	close(done)
+	lostErr := <-lost
+	c.Abort = stop
+	c.lease = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+	defer cancel()
+	if rerr := leaser.Release(ctx, lease); rerr != nil && rerr != tracker.ErrLeaseLost {
+		logger.WithError(rerr).Warn("Failed to release the lease")
+	}
+	if lostErr != nil {
+		return fmt.Errorf("lost the lease of %s: %w", c.trackerKey(), lostErr)
+	}
+	return err
+}
+
+// acquireLease tries to get the lease of the tracker key of c every third
+// of the lease ttl until it succeeds or stop closes
+func (c *CLI) acquireLease(leaser tracker.Leaser, stop chan bool) (tracker.Lease, error) {
+	ttl := c.leaseDuration()
+	standby := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+		lease, err := leaser.Acquire(ctx, c.trackerKey(), c.Options.LeaseOwner, ttl)
+		cancel()
+		if err == nil {
+			return lease, nil
+		}
+		if err != tracker.ErrLeaseHeld {
+			logrus.WithError(err).WithField("instance", c.InstanceIdentifier).Error("Failed to acquire the lease")
+		} else if !standby {
+			logrus.WithField("instance", c.InstanceIdentifier).Info("The lease is held by another process, standing by")
+			standby = true
+		}
+
+		select {
+		case <-stop:
+			return tracker.Lease{}, fmt.Errorf("signal triggered exit")
+		case <-time.After(ttl / 3):
+		}
+	}
+}
+
+// keepLease renews lease every third of the lease ttl until stop or done
+// closes. It returns an error once the lease is lost, or when it couldn't be
+// renewed for half the ttl, so the stream stops before a standby can take
+// over.
+func (c *CLI) keepLease(leaser tracker.Leaser, lease tracker.Lease, stop chan bool, done chan struct{}) error {
+	ttl := c.leaseDuration()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		err := leaser.Renew(ctx, lease, ttl)
+		cancel()
+		switch {
+		case err == nil:
+			renewed = time.Now()
+		case err == tracker.ErrLeaseLost || time.Since(renewed) >= ttl/2:
+			return err
+		default:
+			logrus.WithError(err).WithField("instance", c.InstanceIdentifier).Warn("Failed to renew the lease")
+		}
+	}
+}
+
+// leaseDuration returns the ttl of the leases
+func (c *CLI) leaseDuration() time.Duration {
+	if c.leaseTTL > 0 {
+		return c.leaseTTL
+	}
+	return time.Duration(c.Options.LeaseTTL) * time.Second
+}
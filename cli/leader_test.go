@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/rdstest"
+	"github.com/razorpay/rdslogs/redistest"
+	"github.com/razorpay/rdslogs/tracker"
+)
+
+// newTestReplica returns a CLI streaming db1 with --leader_election and the
+// redis tracker at addr
+func newTestReplica(t *testing.T, fake *rdstest.FakeRDS, addr string, owner string) (*CLI, *capturePublisher) {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr)
+	r, err := tracker.NewRedisTracker(config.RedisConfig{Host: host, Port: port, MaxIdle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Tracker = true
+	c.Options.LeaderElection = true
+	c.Options.LeaseOwner = owner
+	c.Tracker = r
+	c.leaseTTL = 300 * time.Millisecond
+	return c, out
+}
+
+// runLeader runs c.streamWhileLeader until the current c.Abort closes,
+// returning the channel of its error
+func runLeader(c *CLI) <-chan error {
+	errs := make(chan error, 1)
+	stop := c.Abort
+	go func() { errs <- c.streamWhileLeader(stop) }()
+	return errs
+}
+
+func waitForError(t *testing.T, errs <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't stop")
+		return nil
+	}
+}
+
+// waitForCommit waits until the marker of db1 committed in r is marker
+func waitForCommit(t *testing.T, r tracker.Tracker, marker string) {
+	t.Helper()
+	var stored PreviousMarker
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, err := r.ReadMarker(context.Background(), db1Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != "" {
+			if err := json.Unmarshal([]byte(data), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Marker == marker {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected the committed marker %s, got %s", marker, stored.Marker)
+}
+
+func TestStreamWhileLeader(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "first\n")
+
+	a, outA := newTestReplica(t, fake, s.Addr(), "a")
+	stopA := a.Abort
+	errsA := runLeader(a)
+	waitForOutput(t, outA, "first\n")
+
+	// the standby doesn't stream while the leader renews its lease
+	b, outB := newTestReplica(t, fake, s.Addr(), "b")
+	errsB := runLeader(b)
+	fake.AppendLog("db1", slowLog, "second\n")
+	waitForOutput(t, outA, "second\n")
+	waitForCommit(t, a.Tracker, "10:13")
+	time.Sleep(2 * a.leaseTTL)
+	if out := outB.String(); out != "" {
+		t.Fatalf("expected the standby to wait, got %q", out)
+	}
+
+	// the standby takes over from the marker committed by the leader
+	close(stopA)
+	if err := waitForError(t, errsA); err == nil || err.Error() != "signal triggered exit" {
+		t.Errorf("expected signal triggered exit, got %v", err)
+	}
+	fake.AppendLog("db1", slowLog, "third\n")
+	waitForOutput(t, outB, "third\n")
+	// only the last line may be published again, by the tail of the log
+	if out := outB.String(); strings.Contains(out, "first") {
+		t.Errorf("expected the new leader to resume from the committed marker, got %q", out)
+	}
+
+	// a leader whose lease expired, e.g. while it was paused, stops
+	waitForCommit(t, b.Tracker, "10:19")
+	s.FastForward(time.Minute)
+	a, outA = newTestReplica(t, fake, s.Addr(), "a")
+	stopA = a.Abort
+	errsA = runLeader(a)
+	if err := waitForError(t, errsB); err == nil || !strings.Contains(err.Error(), "lost the lease") {
+		t.Errorf("expected the lease to be lost, got %v", err)
+	}
+	fake.AppendLog("db1", slowLog, "fourth\n")
+	waitForOutput(t, outA, "fourth\n")
+	close(stopA)
+	waitForError(t, errsA)
+}
@@ -0,0 +1,16 @@
+package cli
+
+import (
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// RDSAPI is the part of the RDS API used by rdslogs. *rds.RDS implements it,
+// and rdstest.FakeRDS is an in memory implementation for tests.
+type RDSAPI interface {
+	DescribeDBInstances(*rds.DescribeDBInstancesInput) (*rds.DescribeDBInstancesOutput, error)
+	DescribeDBClusters(*rds.DescribeDBClustersInput) (*rds.DescribeDBClustersOutput, error)
+	DescribeDBLogFiles(*rds.DescribeDBLogFilesInput) (*rds.DescribeDBLogFilesOutput, error)
+	DownloadDBLogFilePortion(*rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error)
+}
+
+var _ RDSAPI = (*rds.RDS)(nil)
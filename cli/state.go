@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/jessevdk/go-flags"
+	"github.com/razorpay/rdslogs/tracker"
+)
+
+// StateUsage is the description of the state command for --help
+var StateUsage = `rdslogs state shows and rewinds the markers kept by --tracker_type=bolt. The database is locked by a running rdslogs, so stop it first.`
+
+// rewindUsage is the description of the rewind subcommand for --help
+var rewindUsage = `Resets the marker of an instance to an earlier one of its history, by --id or the last one committed at or before --time. The next stream of the instance replays the logs from there. --log_type, --region and --account pick the marker when the instance has several.`
+
+// StateOptions are the options of the state command
+type StateOptions struct {
+	TrackerDB string `long:"tracker_db" description:"database of the bolt tracker" default:"./rdslogs_state.db"`
+}
+
+// state is shared by the state subcommands
+type state struct {
+	options *StateOptions
+	out     io.Writer
+}
+
+type stateList struct {
+	*state
+}
+
+// keySelector selects the tracker key of an instance
+type keySelector struct {
+	Identifier string `short:"i" long:"identifier" description:"RDS instance identifier" required:"true"`
+	LogType    string `long:"log_type" description:"log type of the marker"`
+	Region     string `long:"region" description:"AWS region of the marker"`
+	Account    string `long:"account" description:"AWS account ID of the marker"`
+}
+
+type stateInspect struct {
+	*state
+	keySelector
+	LogFile string `short:"f" long:"log_file" description:"only show the history of this log file"`
+	Limit   int    `long:"limit" description:"number of history records to show, 0 for all" default:"20"`
+}
+
+type stateRewind struct {
+	*state
+	keySelector
+	ID   uint64 `long:"id" description:"ID of the history record to rewind to"`
+	Time string `long:"time" description:"rewind to the last marker committed at or before this RFC3339 time"`
+}
+
+// RunState runs the state command with the arguments following "state".
+// Errors are printed by the parser.
+func RunState(args []string, out io.Writer) error {
+	s := &state{options: &StateOptions{}, out: out}
+	parser := flag.NewNamedParser("rdslogs", flag.Default)
+	parser.Usage = "state [OPTIONS]"
+	parser.LongDescription = StateUsage
+	if _, err := parser.AddGroup("State Options", "", s.options); err != nil {
+		return err
+	}
+	commands := []struct {
+		name, description, long string
+		data                    interface{}
+	}{
+		{"list", "List the markers and their latest value", "", &stateList{state: s}},
+		{"inspect", "Show the marker history of an instance", "", &stateInspect{state: s}},
+		{"rewind", "Reset the marker of an instance to an earlier one", rewindUsage, &stateRewind{state: s}},
+	}
+	for _, command := range commands {
+		if _, err := parser.AddCommand(command.name, command.description, command.long, command.data); err != nil {
+			return err
+		}
+	}
+
+	_, err := parser.ParseArgs(args)
+	return err
+}
+
+// open opens the tracker database, which must exist
+func (s *state) open() (*tracker.BoltTracker, error) {
+	if _, err := os.Stat(s.options.TrackerDB); err != nil {
+		return nil, err
+	}
+	return tracker.OpenBoltTracker(s.options.TrackerDB, 0)
+}
+
+func (l *stateList) Execute(args []string) error {
+	t, err := l.open()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	keys, err := t.Keys()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(l.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tINSTANCE\tLOG TYPE\tLOG FILE\tMARKER\tCOMMITTED\tHISTORY")
+	for _, key := range keys {
+		records, err := t.History(key, "")
+		if err != nil {
+			return err
+		}
+		latest := tracker.MarkerRecord{}
+		if len(records) > 0 {
+			latest = records[len(records)-1]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", key.Account, key.Region, key.Instance, key.LogType, latest.LogFile, latest.Marker, formatCommitTime(latest.Time), len(records))
+	}
+	return w.Flush()
+}
+
+func (i *stateInspect) Execute(args []string) error {
+	t, err := i.open()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	key, err := i.find(t)
+	if err != nil {
+		return err
+	}
+	latest, err := t.ReadMarker(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	records, err := t.History(key, i.LogFile)
+	if err != nil {
+		return err
+	}
+	if i.Limit > 0 && len(records) > i.Limit {
+		records = records[len(records)-i.Limit:]
+	}
+
+	fmt.Fprintf(i.out, "Latest marker of %s: %s\n\n", key, latest)
+	w := tabwriter.NewWriter(i.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMMITTED\tLOG FILE\tMARKER\t")
+	for _, record := range records {
+		note := ""
+		if record.Rewind {
+			note = "rewind"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", record.ID, formatCommitTime(record.Time), record.LogFile, record.Marker, note)
+	}
+	return w.Flush()
+}
+
+func (r *stateRewind) Execute(args []string) error {
+	if (r.ID == 0) == (r.Time == "") {
+		return fmt.Errorf("rewind needs either --id or --time")
+	}
+	t, err := r.open()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	key, err := r.find(t)
+	if err != nil {
+		return err
+	}
+
+	id := r.ID
+	if r.Time != "" {
+		at, err := time.Parse(time.RFC3339, r.Time)
+		if err != nil {
+			return err
+		}
+		records, err := t.History(key, "")
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if !record.Time.After(at) {
+				id = record.ID
+			}
+		}
+		if id == 0 {
+			return fmt.Errorf("no marker of %s was committed at or before %s", key, r.Time)
+		}
+	}
+
+	record, err := t.Rewind(key, id)
+	if err == tracker.ErrNoMarker {
+		return fmt.Errorf("no history record %d for %s", id, key)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "Rewound %s to marker %s of %s, committed at %s\n",
+		key, record.Marker, record.LogFile, formatCommitTime(record.Time))
+	return nil
+}
+
+// find returns the only key of t matching the selector
+func (k *keySelector) find(t *tracker.BoltTracker) (tracker.Key, error) {
+	keys, err := t.Keys()
+	if err != nil {
+		return tracker.Key{}, err
+	}
+	var found []tracker.Key
+	for _, key := range keys {
+		if key.Instance == k.Identifier &&
+			(k.LogType == "" || key.LogType == k.LogType) &&
+			(k.Region == "" || key.Region == k.Region) &&
+			(k.Account == "" || key.Account == k.Account) {
+			found = append(found, key)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return tracker.Key{}, fmt.Errorf("no marker for instance %s", k.Identifier)
+	case 1:
+		return found[0], nil
+	}
+	return tracker.Key{}, fmt.Errorf("instance %s has %d markers, pick one with --log_type, --region or --account: %v", k.Identifier, len(found), found)
+}
+
+func formatCommitTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/tracker"
+)
+
+func TestStateCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	b, err := tracker.OpenBoltTracker(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range []string{"8:100", "8:200", "9:300"} {
+		data, _ := json.Marshal(PreviousMarker{LogFile: LogFile{LogFileName: "slowquery/mysql-slowquery.log"}, Marker: marker})
+		if err := b.WriteMarker(context.Background(), db1Key, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	auditKey := db1Key
+	auditKey.LogType = constants.LogTypeAudit
+	data, _ := json.Marshal(PreviousMarker{LogFile: LogFile{LogFileName: "audit/server_audit.log"}, Marker: "9:5"})
+	if err := b.WriteMarker(context.Background(), auditKey, string(data)); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := RunState(append([]string{"--tracker_db", path}, args...), &out); err != nil {
+			t.Fatalf("state %v: %s", args, err)
+		}
+		return out.String()
+	}
+
+	if out := run("list"); !strings.Contains(out, "db1") || !strings.Contains(out, "9:300") || !strings.Contains(out, "9:5") {
+		t.Errorf("expected the latest markers of db1 in the list, got\n%s", out)
+	}
+	var out bytes.Buffer
+	if err := RunState([]string{"--tracker_db", path, "inspect", "-i", "db1"}, &out); err == nil {
+		t.Error("expected inspect of an instance with several markers to fail")
+	}
+	if out := run("inspect", "-i", "db1", "--log_type", "query", "--limit", "2"); strings.Contains(out, "8:100") || !strings.Contains(out, "8:200") {
+		t.Errorf("expected the last 2 history records, got\n%s", out)
+	}
+
+	run("rewind", "-i", "db1", "--log_type", "query", "--id", "1")
+	b, err = tracker.OpenBoltTracker(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var previous PreviousMarker
+	latest, err := b.ReadMarker(context.Background(), db1Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(latest), &previous)
+	b.Close()
+	if previous.Marker != "8:100" {
+		t.Errorf("expected the marker of db1 to be rewound to 8:100, got %+v", previous)
+	}
+
+	if err := RunState([]string{"--tracker_db", path, "rewind", "-i", "db1", "--log_type", "query"}, &out); err == nil {
+		t.Error("expected rewind without --id or --time to fail")
+	}
+	if err := RunState([]string{"--tracker_db", path, "rewind", "-i", "db1", "--log_type", "query", "--time", "2000-01-01T00:00:00Z"}, &out); err == nil {
+		t.Error("expected rewind before the history to fail")
+	}
+}
@@ -0,0 +1,662 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/publisher"
+	"github.com/razorpay/rdslogs/rdstest"
+	"github.com/razorpay/rdslogs/tracker"
+)
+
+const slowLog = "slowquery/mysql-slowquery.log"
+
+// db1Key is the tracker key of the query log of db1 streamed by newTestCLI
+var db1Key = tracker.Key{Account: "123456789012", Region: "us-east-1", Instance: "db1", LogType: constants.LogTypeQuery}
+
+// capturePublisher collects everything flushed to it. Writes fail while
+// failWrites is set.
+type capturePublisher struct {
+	mu         sync.Mutex
+	buf        strings.Builder
+	pending    strings.Builder
+	failWrites bool
+}
+
+func (p *capturePublisher) Write(blob string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failWrites {
+		return errors.New("disk full")
+	}
+	p.pending.WriteString(blob)
+	return nil
+}
+
+func (p *capturePublisher) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf.WriteString(p.pending.String())
+	p.pending.Reset()
+	return nil
+}
+
+func (p *capturePublisher) Close() error {
+	return p.Flush()
+}
+
+func (p *capturePublisher) setFailWrites(fail bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failWrites = fail
+}
+
+func (p *capturePublisher) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.buf.String()
+}
+
+// mapTracker keeps markers in memory. Reads and writes fail with err while
+// it is set.
+type mapTracker struct {
+	mu      sync.Mutex
+	markers map[tracker.Key]string
+	err     error
+}
+
+func (t *mapTracker) ReadMarker(ctx context.Context, key tracker.Key) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.markers[key], t.err
+}
+
+func (t *mapTracker) WriteMarker(ctx context.Context, key tracker.Key, marker string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.markers[key] = marker
+	return nil
+}
+
+func (t *mapTracker) setErr(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+// marker returns the marker of db1
+func (t *mapTracker) marker() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.markers[db1Key]
+}
+
+func newTestCLI(fake *rdstest.FakeRDS, out *capturePublisher) *CLI {
+	c := &CLI{
+		Options: &config.Options{
+			InstanceIdentifiers: []string{"db1"},
+			Region:              "us-east-1",
+			DBType:              constants.DBTypeMySQL,
+			LogType:             constants.LogTypeQuery,
+			LogFile:             slowLog,
+			TrackerAccount:      "123456789012",
+			Output:              constants.OutputStdOut,
+			NumLines:            10000,
+		},
+		RDS:                fake,
+		Abort:              make(chan bool),
+		InstanceIdentifier: "db1",
+		fakeNower:          &FakeNower{t: time.Date(2022, 8, 9, 10, 30, 0, 0, time.UTC)},
+		pollInterval:       time.Millisecond,
+	}
+	if out != nil {
+		c.fakePublisher = out
+	}
+	return c
+}
+
+// runStream runs c.Stream until stop is called, which returns Stream's error
+func runStream(c *CLI) (stop func() error) {
+	errs := make(chan error, 1)
+	go func() { errs <- c.Stream() }()
+	return func() error {
+		close(c.Abort)
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+}
+
+func waitForOutput(t *testing.T, out *capturePublisher, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if strings.Contains(out.String(), want) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("output %q doesn't contain %q", out.String(), want)
+}
+
+func TestStreamFollowsGrowingAndRotatingFile(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "old line 1\nold line 2\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+
+	stop := runStream(c)
+	// without a marker only the last line is fetched
+	waitForOutput(t, out, "old line 2\n")
+
+	fake.AppendLog("db1", slowLog, "new line 1\nnew line 2\n")
+	waitForOutput(t, out, "new line 2\n")
+
+	// lines written right before the rotation are fetched from the rotated
+	// file, lines after it from the new file
+	fake.AppendLog("db1", slowLog, "last line of the hour\n")
+	fake.Rotate("db1", slowLog)
+	fake.AppendLog("db1", slowLog, "first line of the next hour\n")
+	waitForOutput(t, out, "first line of the next hour\n")
+	waitForOutput(t, out, "last line of the hour\n")
+
+	if err := stop(); err == nil || err.Error() != "signal triggered exit" {
+		t.Errorf("expected signal triggered exit, got %v", err)
+	}
+	if strings.Contains(out.String(), "old line 1") {
+		t.Errorf("data before the start of the stream was published: %q", out.String())
+	}
+}
+
+func TestStreamSurvivesThrottlingAndBinaryData(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "first\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+
+	stop := runStream(c)
+	waitForOutput(t, out, "first\n")
+
+	fake.QueueError(rdstest.OpDownloadDBLogFilePortion, rdstest.ThrottlingError())
+	fake.QueueError(rdstest.OpDownloadDBLogFilePortion, rdstest.LogFileNotFoundError(slowLog))
+	fake.AppendLog("db1", slowLog, "after throttling\n")
+	waitForOutput(t, out, "after throttling\n")
+
+	// 1000 bytes of binary data are skipped
+	start := len("first\nafter throttling\n")
+	fake.SetBinary("db1", slowLog, start, start+1000)
+	fake.AppendLog("db1", slowLog, strings.Repeat("x", 999)+"\nafter binary\n")
+	waitForOutput(t, out, "after binary\n")
+	stop()
+
+	if strings.Contains(out.String(), "xxx") {
+		t.Errorf("binary data was published: %q", out.String())
+	}
+}
+
+func TestStreamBackfillsFromTracker(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "seen\nmissed 1\nmissed 2\nlatest\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Tracker = true
+
+	files, err := c.GetLatestLogFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, _ := json.Marshal(PreviousMarker{
+		LogFile: files,
+		Marker:  "10:5",
+	})
+	tracker := &mapTracker{markers: map[tracker.Key]string{db1Key: string(previous)}}
+	c.Tracker = tracker
+
+	stop := runStream(c)
+	waitForOutput(t, out, "latest\n")
+	stop()
+
+	// every published chunk gets a newline appended
+	if got := out.String(); got != "missed 1\nmissed 2\n\nlatest\n\n" {
+		t.Errorf("unexpected backfill output %q", got)
+	}
+	var stored PreviousMarker
+	if err := json.Unmarshal([]byte(tracker.marker()), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Marker != "10:30" {
+		t.Errorf("expected tracker marker 10:30, got %s", stored.Marker)
+	}
+}
+
+func TestDownloadPaginatesAndWritesEveryFile(t *testing.T) {
+	fake := rdstest.New()
+	fake.PageSize = 1
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "hour 10\n")
+	fake.Rotate("db1", slowLog)
+	fake.AppendLog("db1", slowLog, "hour 11\n")
+	fake.Rotate("db1", slowLog)
+	fake.AppendLog("db1", slowLog, "current\n")
+
+	c := newTestCLI(fake, nil)
+	c.Options.Download = true
+	c.Options.DownloadDir = t.TempDir()
+	if err := c.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"mysql-slowquery.log":    "current\n\n",
+		"mysql-slowquery.log.10": "hour 10\n\n",
+		"mysql-slowquery.log.11": "hour 11\n\n",
+	} {
+		got, err := os.ReadFile(path.Join(c.Options.DownloadDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+	if calls := fake.Calls(rdstest.OpDescribeDBLogFiles); calls != 3 {
+		t.Errorf("expected 3 paginated DescribeDBLogFiles calls, got %d", calls)
+	}
+}
+
+func TestStreamInstancesTailsEveryClusterMember(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("writer", "aurora-mysql", nil)
+	fake.AddInstance("reader", "aurora-mysql", nil)
+	fake.SetCluster("cluster", "writer", "reader")
+	fake.AppendLog("writer", slowLog, "writer start\n")
+	fake.AppendLog("reader", slowLog, "reader start\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.InstanceIdentifiers = nil
+	c.Options.Clusters = []string{"cluster"}
+	c.Options.DiscoverInterval = 60
+
+	errs := make(chan error, 1)
+	go func() { errs <- c.StreamInstances() }()
+	waitForOutput(t, out, "writer start\n")
+	waitForOutput(t, out, "reader start\n")
+
+	fake.AppendLog("reader", slowLog, "reader more\n")
+	waitForOutput(t, out, "reader more\n")
+	close(c.Abort)
+	if err := <-errs; err == nil || err.Error() != "signal triggered exit" {
+		t.Errorf("expected signal triggered exit, got %v", err)
+	}
+}
+
+func TestStreamKeepsMarkerWhenPublishingFails(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "first\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Tracker = true
+	tracker := &mapTracker{markers: map[tracker.Key]string{}}
+	c.Tracker = tracker
+
+	stop := runStream(c)
+	waitForOutput(t, out, "first\n")
+	stop()
+
+	out.setFailWrites(true)
+	fake.AppendLog("db1", slowLog, "lost?\n")
+	c.Abort = make(chan bool)
+	if err := c.Stream(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected the publish error, got %v", err)
+	}
+	var stored PreviousMarker
+	if err := json.Unmarshal([]byte(tracker.marker()), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Marker != "10:6" {
+		t.Errorf("marker advanced past unpublished data: %s", stored.Marker)
+	}
+
+	// the restarted stream publishes the data once writes work again
+	out.setFailWrites(false)
+	c.Abort = make(chan bool)
+	stop = runStream(c)
+	waitForOutput(t, out, "lost?\n")
+	stop()
+}
+
+func TestStreamStopsWhenTheMarkerIsNotCommitted(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "first\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Tracker = true
+	tracker := &mapTracker{markers: map[tracker.Key]string{}}
+	c.Tracker = tracker
+
+	stop := runStream(c)
+	waitForMarker(t, tracker, "10:6")
+
+	// the data is published, but the stream doesn't go on without its
+	// marker being committed
+	tracker.setErr(errors.New("connection refused"))
+	fake.AppendLog("db1", slowLog, "second\n")
+	waitForOutput(t, out, "second\n")
+	if err := stop(); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected the tracker error, got %v", err)
+	}
+
+	// without a marker to resume from the stream doesn't start
+	c.Abort = make(chan bool)
+	if err := c.Stream(); err == nil || !strings.Contains(err.Error(), "reading the marker") {
+		t.Fatalf("expected the tracker read error, got %v", err)
+	}
+
+	// once the tracker is back, the data since the committed marker is
+	// published again
+	tracker.setErr(nil)
+	c.Abort = make(chan bool)
+	stop = runStream(c)
+	waitForMarker(t, tracker, "10:13")
+	stop()
+	if got := strings.Count(out.String(), "second\n"); got != 2 {
+		t.Errorf("expected the uncommitted data to be published again, got %q", out.String())
+	}
+}
+
+// waitForMarker waits until the tracker stores marker for db1
+func waitForMarker(t *testing.T, tracker *mapTracker, marker string) {
+	t.Helper()
+	var stored PreviousMarker
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data := tracker.marker(); data != "" {
+			if err := json.Unmarshal([]byte(data), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Marker == marker {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected tracker marker %s, got %s", marker, stored.Marker)
+}
+
+func TestStreamHoldsIncompleteEntries(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "-- start\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.Formatter = true
+	c.Options.Tracker = true
+	tracker := &mapTracker{markers: map[tracker.Key]string{}}
+	c.Tracker = tracker
+	c.flushInterval = 300 * time.Millisecond
+
+	stop := runStream(c)
+	defer stop()
+	waitForMarker(t, tracker, "10:9")
+
+	first := "# Time: 2022-09-01T08:00:01.000000Z\n# User@Host: app[app] @  [10.0.1.7]  Id: 12\n"
+	fake.AppendLog("db1", slowLog, first)
+	fake.AppendLog("db1", slowLog, "SELECT 1;\n")
+	second := "# Time: 2022-09-01T08:00:02.000000Z\n# User@Host: app[app] @  [10.0.1.7]  Id: 13\nSELECT 2;\n"
+	fake.AppendLog("db1", slowLog, second)
+
+	// the first entry is complete once the second starts, which stays
+	// pending and keeps the tracker marker at its start
+	waitForOutput(t, out, `"Query":"SELECT 1;"`)
+	if strings.Contains(out.String(), "SELECT 2;") {
+		t.Fatalf("incomplete entry published early: %s", out.String())
+	}
+	secondStart := 9 + len(first) + len("SELECT 1;\n")
+	waitForMarker(t, tracker, fmt.Sprintf("10:%d", secondStart))
+
+	// no more data arrives, so the second entry is flushed after the timeout
+	waitForOutput(t, out, `"Query":"SELECT 2;"`)
+	waitForMarker(t, tracker, fmt.Sprintf("10:%d", secondStart+len(second)))
+}
+
+func TestPublishHoldsIncompletePostgresEntries(t *testing.T) {
+	out := &capturePublisher{}
+	c := newTestCLI(rdstest.New(), out)
+	c.Options.DBType = constants.DBTypePostgreSQL
+	c.Options.LogFile = "error/postgresql.log.2022-09-01-08"
+	c.Options.Formatter = true
+	logFile := LogFile{LogFileName: c.Options.LogFile}
+
+	first := "2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:ERROR:  relation \"missing\" does not exist\n"
+	rest := "2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:STATEMENT:  SELECT *\n\tFROM missing\n"
+	second := "2022-09-01 08:00:02 UTC::@:[812]:LOG:  checkpoint starting: time\n"
+	publish := func(from int, data string) StreamPos {
+		t.Helper()
+		src := publisher.Source{Instance: "db1", LogFile: logFile.LogFileName, From: fmt.Sprintf("8:%d", from), To: fmt.Sprintf("8:%d", from+len(data))}
+		if err := c.publish(out, src, data); err != nil {
+			t.Fatal(err)
+		}
+		return StreamPos{logFile: logFile, marker: src.To}
+	}
+
+	// the statement of the first entry arrives with the next chunk
+	sPos := publish(0, first)
+	if out.String() != "" {
+		t.Fatalf("incomplete entry published early: %s", out.String())
+	}
+	if marker := c.committedMarker(sPos); marker != "8:0" {
+		t.Errorf("expected the marker held at the open entry, got %s", marker)
+	}
+	sPos = publish(len(first), rest+second)
+	if !strings.Contains(out.String(), `"Statement":"SELECT *\nFROM missing"`) || strings.Contains(out.String(), "checkpoint") {
+		t.Fatalf("expected only the first entry with its statement, got %s", out.String())
+	}
+	if marker, expected := c.committedMarker(sPos), fmt.Sprintf("8:%d", len(first)+len(rest)); marker != expected {
+		t.Errorf("expected the marker held at %s, got %s", expected, marker)
+	}
+
+	// the last entry is published when the formatter is flushed
+	if err := c.flushFormatter(out, sPos); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "checkpoint starting: time") || c.committedMarker(sPos) != sPos.marker {
+		t.Errorf("expected the last entry flushed, got %s", out.String())
+	}
+}
+
+// captureUploader keeps the bodies and metadata of the uploaded objects
+type captureUploader struct {
+	mu       sync.Mutex
+	bodies   []string
+	metadata []map[string]*string
+}
+
+func (u *captureUploader) Upload(in *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bodies = append(u.bodies, string(data))
+	u.metadata = append(u.metadata, in.Metadata)
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (u *captureUploader) uploads() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.bodies...)
+}
+
+func TestStreamCommitsBufferedS3DataOnceUploaded(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "first\n")
+	c := newTestCLI(fake, nil)
+	c.Options.Output = constants.OutputS3
+	uploader := &captureUploader{}
+	c.S3 = uploader
+	c.S3Config = publisher.S3Config{Bucket: "archive", BufferSize: 20, BufferAge: time.Hour}
+	c.Options.Tracker = true
+	tracker := &mapTracker{markers: map[tracker.Key]string{}}
+	c.Tracker = tracker
+
+	stop := runStream(c)
+	// the tail is buffered, the marker stays at its start
+	waitForMarker(t, tracker, "10:0")
+	if uploads := uploader.uploads(); len(uploads) != 0 {
+		t.Fatalf("expected the data to be buffered, got %q", uploads)
+	}
+
+	fake.AppendLog("db1", slowLog, "second line fills the buffer\n")
+	waitForMarker(t, tracker, "10:35")
+	stop()
+	uploads := uploader.uploads()
+	if len(uploads) != 1 || uploads[0] != "first\n\nsecond line fills the buffer\n\n" {
+		t.Fatalf("expected one object of both chunks, got %q", uploads)
+	}
+	metadata := uploader.metadata[0]
+	if aws.StringValue(metadata["Rds-Marker-From"]) != "10:0" || aws.StringValue(metadata["Rds-Last-Written"]) == "" {
+		t.Errorf("unexpected metadata %v", aws.StringValueMap(metadata))
+	}
+}
+
+func TestPublishLingersHTTPBatchesUntilSent(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(data))
+	}))
+	defer ts.Close()
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), bodies...)
+	}
+
+	c := newTestCLI(rdstest.New(), nil)
+	c.Options.Output = constants.OutputHTTP
+	c.HTTP = publisher.HTTPConfig{URL: ts.URL, BatchSize: 3, Linger: 100 * time.Millisecond}
+	c.output = c.newPublisher(slowLog, nil, nil)
+	sPos := StreamPos{logFile: LogFile{LogFileName: slowLog}}
+	publish := func(from string, to string, data string) {
+		t.Helper()
+		src := publisher.Source{Instance: "db1", LogFile: slowLog, From: from, To: to}
+		if err := c.publish(c.output, src, data); err != nil {
+			t.Fatal(err)
+		}
+		sPos.marker = to
+	}
+
+	// every poll flushes, the partial batch waits for more events
+	publish("10:0", "10:6", "first\n")
+	publish("10:6", "10:13", "second\n")
+	if sent := received(); len(sent) != 0 {
+		t.Fatalf("expected the partial batch to linger, got %q", sent)
+	}
+	if marker := c.committedMarker(sPos); marker != "10:0" {
+		t.Errorf("expected the marker held at the unsent data, got %s", marker)
+	}
+
+	publish("10:13", "10:19", "third\n")
+	publish("10:19", "10:26", "fourth\n")
+	if sent := received(); len(sent) != 1 || strings.Count(sent[0], "\n") != 3 {
+		t.Fatalf("expected one full batch, got %q", sent)
+	}
+	if marker := c.committedMarker(sPos); marker != "10:19" {
+		t.Errorf("expected the marker held at the fourth chunk, got %s", marker)
+	}
+
+	// an idle poll sends the partial batch once it lingered
+	time.Sleep(c.HTTP.Linger)
+	publish("10:26", "10:26", "")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sent := received(); len(sent) != 2 || sent[1] != "\"fourth\\n\"\n" {
+		t.Fatalf("expected the lingering chunk sent, got %q", sent)
+	}
+	if marker := c.committedMarker(sPos); marker != "10:26" {
+		t.Errorf("expected the marker to move on once everything is sent, got %s", marker)
+	}
+}
+
+func TestPublishHoldsTheMarkerAtEntriesCompletedLater(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+	}))
+	defer ts.Close()
+
+	c := newTestCLI(rdstest.New(), nil)
+	c.Options.Formatter = true
+	c.Options.Output = constants.OutputHTTP
+	c.HTTP = publisher.HTTPConfig{URL: ts.URL, BatchSize: 10, Linger: 200 * time.Millisecond}
+	c.output = c.newPublisher(slowLog, nil, nil)
+
+	// the first chunk completes one entry and starts the next
+	complete := "# Time: 2022-09-01T08:00:00.000000Z\n# User@Host: app[app] @  [10.0.1.7]  Id: 11\nSELECT 0;\n"
+	started := "# Time: 2022-09-01T08:00:01.000000Z\n# User@Host: app[app] @  [10.0.1.7]  Id: 12\n"
+	rest := "SELECT 1;\n# Time: 2022-09-01T08:00:02.000000Z\n"
+	firstEnd := fmt.Sprintf("10:%d", len(complete)+len(started))
+	end := fmt.Sprintf("10:%d", len(complete)+len(started)+len(rest))
+	if err := c.publish(c.output, publisher.Source{Instance: "db1", LogFile: slowLog, From: "10:0", To: firstEnd}, complete+started); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		sent := requests
+		mu.Unlock()
+		if sent == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the complete entry to be sent after the linger")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := c.publish(c.output, publisher.Source{Instance: "db1", LogFile: slowLog, From: firstEnd, To: end}, rest); err != nil {
+		t.Fatal(err)
+	}
+	// the buffered entry started in the first chunk
+	sPos := StreamPos{logFile: LogFile{LogFileName: slowLog}, marker: end}
+	if marker, expected := c.committedMarker(sPos), fmt.Sprintf("10:%d", len(complete)); marker != expected {
+		t.Errorf("expected the marker held at %s, the start of the buffered entry, got %s", expected, marker)
+	}
+}
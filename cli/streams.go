@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// instanceStream is a running Stream of one instance
+type instanceStream struct {
+	cli *CLI
+	// stop is the Abort channel of cli, closed to end the stream
+	stop chan bool
+	// done is closed once the stream goroutine returned
+	done chan struct{}
+}
+
+// streamTarget is an instance to stream and the fields added to its events
+type streamTarget struct {
+	Identifier string
+	Fields     map[string]string
+}
+
+// streamSet keeps one running instanceStream per instance identifier
+type streamSet struct {
+	parent  *CLI
+	mu      sync.Mutex
+	streams map[string]*instanceStream
+}
+
+// StreamInstances runs an independent Stream for every configured instance.
+// With --discover or --cluster the set of instances is refreshed every
+// Options.DiscoverInterval seconds; streams are started for new instances and
+// stopped for instances which went away. The streams share the RDS client,
+// the tracker and the request budget but each keeps its own marker and
+// publisher. A stream that fails is logged and restarted after
+// Options.RestartTimer seconds without disturbing the others.
+// StreamInstances returns once Abort fires and every stream stopped.
+func (c *CLI) StreamInstances() error {
+	set := &streamSet{
+		parent:  c,
+		streams: make(map[string]*instanceStream),
+	}
+
+	// without --discover and --cluster the instances never change, and the
+	// nil channel leaves only Abort to wait for
+	var refresh <-chan time.Time
+	if c.Options.Discover || len(c.Options.Clusters) > 0 {
+		ticker := time.NewTicker(time.Duration(c.Options.DiscoverInterval) * time.Second)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	for {
+		targets, err := c.streamTargets()
+		if err != nil {
+			// keep the current streams until the refresh works again
+			logrus.WithError(err).Error("Failed to refresh the instances to stream")
+		} else {
+			set.reconcile(targets)
+		}
+
+		select {
+		case <-c.Abort:
+			set.stopAll()
+			return fmt.Errorf("signal triggered exit")
+		case <-refresh:
+		}
+	}
+}
+
+// streamTargets returns the instances given by --identifier, found by
+// --discover and the members of the --cluster clusters. Cluster members keep
+// their cluster fields when they are also listed otherwise.
+func (c *CLI) streamTargets() ([]streamTarget, error) {
+	identifiers := c.Options.InstanceIdentifiers
+	if c.Options.Discover {
+		var err error
+		if identifiers, err = c.DiscoverInstances(); err != nil {
+			return nil, err
+		}
+	}
+
+	targets, err := c.clusterTargets()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range identifiers {
+		found := false
+		for _, target := range targets {
+			if target.Identifier == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			targets = append(targets, streamTarget{Identifier: id})
+		}
+	}
+	return targets, nil
+}
+
+// forInstance returns a copy of c which streams or downloads id. The copy
+// shares the RDS client, tracker and throttle of c.
+func (c *CLI) forInstance(id string) *CLI {
+	return &CLI{
+		Options:            c.Options,
+		RDS:                c.RDS,
+		Abort:              c.Abort,
+		InstanceIdentifier: id,
+		Throttle:           c.Throttle,
+		Errors:             c.Errors,
+		Kafka:              c.Kafka,
+		HTTP:               c.HTTP,
+		Honeycomb:          c.Honeycomb,
+		S3:                 c.S3,
+		S3Config:           c.S3Config,
+		Redactor:           c.Redactor,
+		Tracker:            c.Tracker,
+		fakeNower:          c.fakeNower,
+		pollInterval:       c.pollInterval,
+		flushInterval:      c.flushInterval,
+		fakePublisher:      c.fakePublisher,
+		leaseTTL:           c.leaseTTL,
+	}
+}
+
+// reconcile starts streams for targets which are not running yet, updates
+// the event fields of running ones and stops the running streams whose
+// instance is not a target anymore.
+func (s *streamSet) reconcile(targets []streamTarget) {
+	wanted := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		wanted[target.Identifier] = true
+		s.start(target)
+	}
+
+	s.mu.Lock()
+	var stale []string
+	for id := range s.streams {
+		if !wanted[id] {
+			stale = append(stale, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range stale {
+		s.stop(id)
+	}
+}
+
+// start runs a stream for target unless one is running already, in which
+// case only its event fields are updated (e.g. after an Aurora failover).
+func (s *streamSet) start(target streamTarget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := target.Identifier
+	if stream, ok := s.streams[id]; ok {
+		stream.cli.setEventFields(target.Fields)
+		return
+	}
+
+	ic := s.parent.forInstance(id)
+	ic.setEventFields(target.Fields)
+	ic.Abort = make(chan bool)
+	stream := &instanceStream{
+		cli:  ic,
+		stop: ic.Abort,
+		done: make(chan struct{}),
+	}
+	s.streams[id] = stream
+
+	logrus.WithField("instance", id).Info("Starting instance stream")
+	go stream.run(time.Duration(s.parent.Options.RestartTimer) * time.Second)
+}
+
+// stop ends the stream of id and waits for it to return
+func (s *streamSet) stop(id string) {
+	s.mu.Lock()
+	stream, ok := s.streams[id]
+	delete(s.streams, id)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	logrus.WithField("instance", id).Info("Stopping instance stream")
+	close(stream.stop)
+	<-stream.done
+}
+
+func (s *streamSet) stopAll() {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.streams))
+	for id := range s.streams {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.stop(id)
+	}
+}
+
+// run calls Stream until the stream gets stopped, restarting it after
+// restartDelay whenever it fails. With --leader_election it only streams
+// while holding the lease of the instance.
+func (st *instanceStream) run(restartDelay time.Duration) {
+	defer close(st.done)
+	for {
+		var err error
+		if st.cli.Options.LeaderElection {
+			err = st.cli.streamWhileLeader(st.stop)
+		} else {
+			err = st.cli.Stream()
+		}
+		if st.stopped() {
+			return
+		}
+
+		logrus.WithError(err).
+			WithFields(st.cli.Errors.Fields()).
+			WithField("instance", st.cli.InstanceIdentifier).
+			Errorf("Instance stream failed; restarting in %s", restartDelay)
+		st.cli.waitFor(restartDelay)
+		if st.stopped() {
+			return
+		}
+		// start over from the tracker (if any) instead of stale state
+		st.cli.PreviousMarker = PreviousMarker{}
+	}
+}
+
+func (st *instanceStream) stopped() bool {
+	select {
+	case <-st.stop:
+		return true
+	default:
+		return false
+	}
+}
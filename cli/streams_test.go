@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/razorpay/rdslogs/rdstest"
+)
+
+// runStreamInstances runs c.StreamInstances until stop is called, which
+// returns its error
+func runStreamInstances(t *testing.T, c *CLI) (stop func() error) {
+	errs := make(chan error, 1)
+	go func() { errs <- c.StreamInstances() }()
+	return func() error {
+		close(c.Abort)
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the streams didn't stop")
+			return nil
+		}
+	}
+}
+
+func TestStreamInstancesRestartsAFailedStream(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AddInstance("db2", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "db1 start\n")
+	fake.AppendLog("db2", slowLog, "db2 start\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.InstanceIdentifiers = []string{"db1", "db2"}
+	c.Options.RestartTimer = 1
+
+	stop := runStreamInstances(t, c)
+	waitForOutput(t, out, "db1 start\n")
+	waitForOutput(t, out, "db2 start\n")
+
+	// the stream of db2 fails while db1 keeps streaming
+	fake.RemoveInstance("db2")
+	failed := time.Now()
+	fake.AppendLog("db1", slowLog, "db1 during the outage\n")
+	waitForOutput(t, out, "db1 during the outage\n")
+
+	fake.AddInstance("db2", "mysql", nil)
+	fake.AppendLog("db2", slowLog, "db2 back\n")
+	waitForOutput(t, out, "db2 back\n")
+	if elapsed := time.Since(failed); elapsed < time.Second {
+		t.Errorf("expected the stream to be restarted after the restart timer, took %s", elapsed)
+	}
+
+	fake.AppendLog("db1", slowLog, "db1 after the outage\n")
+	waitForOutput(t, out, "db1 after the outage\n")
+	if err := stop(); err == nil || err.Error() != "signal triggered exit" {
+		t.Errorf("expected signal triggered exit, got %v", err)
+	}
+}
+
+func TestStreamInstancesShareTheThrottle(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AddInstance("db2", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "db1 start\n")
+	fake.AppendLog("db2", slowLog, "db2 start\n")
+	out := &capturePublisher{}
+	c := newTestCLI(fake, out)
+	c.Options.InstanceIdentifiers = []string{"db1", "db2"}
+	c.Throttle = NewThrottle(20)
+
+	stop := runStreamInstances(t, c)
+	time.Sleep(500 * time.Millisecond)
+	stop()
+
+	// without the throttle both streams poll every millisecond
+	calls := fake.Calls(rdstest.OpDescribeDBLogFiles) + fake.Calls(rdstest.OpDownloadDBLogFilePortion)
+	if calls > 13 {
+		t.Errorf("expected at most 20 requests per second of both streams, got %d in half a second", calls)
+	}
+	if !strings.Contains(out.String(), "db1 start\n") || !strings.Contains(out.String(), "db2 start\n") {
+		t.Errorf("expected both instances to be streamed, got %q", out.String())
+	}
+}
+
+func TestDownloadKeepsTheFilesOfEveryInstanceApart(t *testing.T) {
+	fake := rdstest.New()
+	fake.AddInstance("db1", "mysql", nil)
+	fake.AddInstance("db2", "mysql", nil)
+	fake.AppendLog("db1", slowLog, "from db1\n")
+	fake.AppendLog("db2", slowLog, "from db2\n")
+
+	c := newTestCLI(fake, nil)
+	c.Options.InstanceIdentifiers = []string{"db1", "db2"}
+	c.Options.Download = true
+	c.Options.DownloadDir = t.TempDir()
+	if err := c.Download(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"db1", "db2"} {
+		got, err := os.ReadFile(path.Join(c.Options.DownloadDir, id, "mysql-slowquery.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "from "+id+"\n\n" {
+			t.Errorf("%s: unexpected download %q", id, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle is the RDS API request budget shared by all instance streams of
+// one process. It spaces requests out to stay under a requests per second
+// limit, and when any stream gets rate limited by AWS every stream pauses.
+// A nil *Throttle never blocks.
+type Throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewThrottle returns a Throttle allowing perSecond requests per second.
+// perSecond <= 0 disables the request spacing but still shares backoffs.
+func NewThrottle(perSecond int64) *Throttle {
+	t := &Throttle{}
+	if perSecond > 0 {
+		t.interval = time.Second / time.Duration(perSecond)
+	}
+	return t
+}
+
+// wait blocks until the caller may issue its next request or abort fires.
+func (t *Throttle) wait(abort chan bool) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.next
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next = slot.Add(t.interval)
+	t.mu.Unlock()
+
+	if d := slot.Sub(now); d > 0 {
+		select {
+		case <-abort:
+		case <-time.After(d):
+		}
+	}
+}
+
+// backoff holds back every caller of wait for at least d.
+func (t *Throttle) backoff(d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.next) {
+		t.next = until
+	}
+}
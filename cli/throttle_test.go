@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottleSpacesRequestsOfAllCallers(t *testing.T) {
+	throttle := NewThrottle(20)
+	abort := make(chan bool)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				throttle.wait(abort)
+			}
+		}()
+	}
+	wg.Wait()
+	// 6 requests 50ms apart, the first one right away
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("expected the requests to be spaced out, took %s", elapsed)
+	}
+}
+
+func TestThrottleBackoffHoldsBackEveryCaller(t *testing.T) {
+	throttle := NewThrottle(0)
+	abort := make(chan bool)
+	throttle.wait(abort)
+
+	throttle.backoff(100 * time.Millisecond)
+	start := time.Now()
+	throttle.wait(abort)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected to wait for the backoff, took %s", elapsed)
+	}
+
+	// a shorter backoff doesn't shorten a pending one
+	throttle.backoff(time.Hour)
+	throttle.backoff(time.Millisecond)
+	close(abort)
+	start = time.Now()
+	throttle.wait(abort)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected abort to end the wait, took %s", elapsed)
+	}
+
+	var none *Throttle
+	none.backoff(time.Hour)
+	none.wait(make(chan bool))
+}
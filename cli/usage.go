@@ -3,7 +3,8 @@ package cli
 // Usage info for --help
 var Usage = `rdslogs --identifier my-rds-instance
 
-rdslogs streams a log file from Amazon RDS and prints it to STDOUT or File
+rdslogs streams a log file from Amazon RDS and prints it to STDOUT or File, or
+sends it to Kafka, an HTTP endpoint, Honeycomb.io or an S3 bucket
 
 AWS credentials are required and can be provided via IAM roles, AWS shared
 config (~/.aws/config), AWS shared credentials (~/.aws/credentials), or
@@ -15,10 +16,134 @@ via the --log_file flag, which names an active log file as well as the past 24
 hours of rotated logs. (For example, specifying --log_file=foo.log will download
 foo.log as well as foo.log.0, foo.log.2, ... foo.log.23.)
 
+Several instances can be streamed by one process by repeating --identifier
+(or the identifier key of the config file) or by passing a comma separated
+list. Every instance is tailed by its own stream with its own marker, tracker
+entry and output; a failing stream is restarted after --restart_timer seconds
+without affecting the others. --rate_limit caps the RDS API requests per
+second made by all streams together.
+
+Passing --discover selects the instances to tail by --discover_tag,
+--discover_engine and --discover_pattern instead of --identifier. Discovery is
+repeated every --discover_interval seconds; new matching instances are picked
+up and streams of deleted (or no longer matching) instances are stopped.
+
+Passing --cluster (repeatable) tails every member instance of an Aurora DB
+cluster. Membership is refreshed every --discover_interval seconds, so added
+readers are picked up and removed ones stopped without a restart. --cluster
+turns on --formatter, and the events of cluster members carry
+ClusterIdentifier, InstanceIdentifier and InstanceRole (writer or reader)
+fields, updated after a failover.
+
+Failed RDS calls are retried when AWS reports them as retryable (throttling,
+network errors, log rotation in progress) with an exponential backoff starting
+at --backoff_timer seconds, capped at --backoff_max. Throttling is retried
+until rdslogs is stopped and a missing log file for two minutes, as it may be
+being rotated. Other retryable errors are retried up to --max_retries times.
+After that the stream stops and is restarted after --restart_timer seconds. Binary data in a log file is skipped; other errors
+stop the stream.
+
+Passing --formatter turns every log entry into a JSON event. PostgreSQL logs
+are parsed according to --log_line_prefix, which must match the
+log_line_prefix parameter of the instance (the RDS default is
+%t:%r:%u@%d:[%p]:). DETAIL, HINT, CONTEXT and STATEMENT lines are attached to
+the event of their entry. Plans logged by auto_explain (text or JSON format) are
+summarized in the Plan field of their duration event, listing the node types,
+cost, rows and the sequential scans of at least --seq_scan_rows rows.
+Every PostgreSQL event has an EventType: query for statements and durations,
+audit for pgaudit entries (split into AuditClass, Command, ObjectType,
+ObjectName and Query) and log for everything else, so audit events can be
+routed to a separate sink downstream.
+
+MySQL slow query and PostgreSQL logs are parsed across the chunks downloaded
+from RDS: an entry is published once the next one starts, the log file
+rotates, or no data arrived for --flush_timeout seconds. The tracker marker stays at the start of
+an entry until it has been published.
+
+Formatted events have sensitive data removed from their queries and messages:
+e-mail and IP addresses, and the values compared against, assigned or inserted
+into phone, card and name columns. --redaction_rules replaces these rules by the
+rules of a JSON file, e.g.
+
+  {"salt": "s3cret", "rules": [
+    {"name": "email", "match": "literal", "columns": ["email"], "action": "hash"},
+    {"name": "pan", "match": "regex", "pattern": "[A-Z]{5}[0-9]{4}[A-Z]", "action": "mask"}
+  ]}
+
+Literal rules match the string and number literals of queries, optionally only
+those of the given columns and matching the pattern; regex rules match anywhere.
+Matches are masked (with "mask", "?" by default), replaced by their salted hash
+or dropped. Query events also carry a Fingerprint of the query, with literals
+replaced and IN lists collapsed, and its Digest, to group queries by shape.
+
+--log_type picks the log read by default and its format: query (the MySQL slow
+query log or the PostgreSQL log) or, for mysql, audit (audit/server_audit.log of
+the MariaDB audit plugin), error (error/mysql-error.log) or general
+(general/mysql-general.log). Each is formatted into its own kind of event.
+
 When --output is set to "file", will download the specified logs to the directory
 specified by --download_dir instead of being printed to STDOUT.
 
+When --output is set to "kafka", every log chunk (or formatted event) is
+produced to --kafka_topic on --kafka_brokers, keyed by --kafka_partition_key.
+Markers are only committed after the brokers acknowledged the messages with
+--kafka_acks (all by default).
+
+When --output is set to "http", events are POSTed in batches of
+--http_batch_size to --http_url as NDJSON or JSON arrays (--http_format), with
+optional --http_header and --http_gzip. A partial batch waits up to
+--http_linger_ms for more events, and the marker is only committed once the
+events before it are sent. Batches failing with a network error, 429 or 5xx
+are retried; batches that fail for good are appended to
+--http_dead_letter_file, or stop the stream when none is given.
+
+When --output is set to "honeycomb", the --writekey and --dataset flags are
+required and the logs are always formatted. Every parsed event is sent to the
+Honeycomb events API at --api_host; --sample_rate only sends 1 in N events.
+Batches are retried like http ones (--http_max_retries, --http_backoff_ms),
+and so are the single events Honeycomb rejects with 429 or 5xx. Events it
+rejects otherwise, or still rejects after the retries, are appended to
+--http_dead_letter_file, or logged and dropped, so the accepted events of the
+batch aren't sent again.
+
+When --output is set to "s3", the logs are archived in --s3_bucket under keys
+built from --s3_key_template, optionally gzipped (--s3_gzip). In download mode
+every log file becomes one object (uploaded in parts of --s3_part_size MB);
+while streaming the data of an instance is collected for --s3_buffer_seconds
+or up to --s3_buffer_size MB per object, and the marker is only committed once
+it is uploaded. The object metadata carries the instance, log file, RDS marker
+range and LastWritten of the data, which also gives {date} and {hour}.
+--s3_endpoint points rdslogs at an S3 compatible server such as MinIO.
+
 When --tracker is enabled, it will store the marker by default to redis or we can
 set the tracker type by passing value to --tracker_type. Tracker backfills the data
-in stream mode only according to marker stored in tracker.
+in stream mode only according to marker stored in tracker. The marker is only
+stored once the data before it has been published, so after a failure logs are
+delivered at least once. Markers are kept per AWS account (--tracker_account,
+looked up with sts:GetCallerIdentity when not set, rdslogs exits when that
+fails), region, instance and --log_type. A stream
+whose marker can't be read or committed stops and is restarted from the last
+committed marker. --tracker_type=file keeps the markers in files under
+--tracker_dir, one directory per marker, for hosts without redis; the files
+are replaced atomically. --tracker_type=bolt keeps the markers in the embedded
+database --tracker_db along with their history of --tracker_history_hours hours.
+"rdslogs state" lists and inspects these markers and rewinds an instance to an
+earlier marker to replay the logs since; run "rdslogs state --help" for details.
+
+The redis tracker is configured by the TRACKER_* environment variables:
+TRACKER_MODE is standalone (TRACKER_HOST and TRACKER_PORT), sentinel
+(TRACKER_ADDRS lists the sentinels, TRACKER_SENTINEL_MASTER names the master)
+or cluster (TRACKER_ADDRS lists nodes to discover the cluster from).
+TRACKER_TLS=true connects with TLS, verified with TRACKER_TLS_CA and
+authenticated with TRACKER_TLS_CERT and TRACKER_TLS_KEY. TRACKER_KEY_PREFIX is
+prepended to the keys, and markers not written for TRACKER_TTL seconds expire,
+so keep it well above the longest quiet period of a log. Pooled connections
+idle for TRACKER_HEALTH_CHECK seconds are checked with PING before use.
+
+--leader_election lets several replicas run active/passive: an instance is
+only streamed by the replica holding its lease in the redis or file tracker,
+renewed every third of --lease_ttl seconds. The others stand by and take over
+from the last committed marker once the lease is released or expires; markers
+are fenced with the lease, so a leader which lost it can't commit any more.
+The file tracker locks files, so its replicas must share a host.
 `
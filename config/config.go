@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/razorpay/rdslogs/constants"
@@ -25,13 +27,37 @@ func InitilizeRedisConfig() {
 	database, _ := strconv.Atoi(getenv(constants.TrackerDatabase, "0"))
 	maxIdle, _ := strconv.Atoi(getenv(constants.TrackerMaxIdle, "10"))
 	maxActive, _ := strconv.Atoi(getenv(constants.TrackerMaxActive, "100"))
+	ttl, _ := strconv.Atoi(getenv(constants.TrackerTTL, "0"))
+	idleTimeout, _ := strconv.Atoi(getenv(constants.TrackerIdleTimeout, "240"))
+	healthCheck, _ := strconv.Atoi(getenv(constants.TrackerHealthCheck, "10"))
+	tls, _ := strconv.ParseBool(getenv(constants.TrackerTLS, "false"))
+	tlsSkipVerify, _ := strconv.ParseBool(getenv(constants.TrackerTLSSkipVerify, "false"))
+	var addrs []string
+	for _, addr := range strings.Split(os.Getenv(constants.TrackerAddrs), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
 	RedisDBConfig = RedisConfig{
-		Host:      os.Getenv(constants.TrackerHost),
-		Database:  database,
-		Password:  os.Getenv(constants.TrackerPassword),
-		Port:      os.Getenv(constants.TrackerPort),
-		MaxIdle:   maxIdle,
-		MaxActive: maxActive,
+		Host:             os.Getenv(constants.TrackerHost),
+		Database:         database,
+		Password:         os.Getenv(constants.TrackerPassword),
+		Port:             os.Getenv(constants.TrackerPort),
+		MaxIdle:          maxIdle,
+		MaxActive:        maxActive,
+		Mode:             getenv(constants.TrackerMode, constants.RedisModeStandalone),
+		Addrs:            addrs,
+		SentinelMaster:   os.Getenv(constants.TrackerSentinelMaster),
+		SentinelPassword: os.Getenv(constants.TrackerSentinelPassword),
+		TLS:              tls,
+		TLSCAFile:        os.Getenv(constants.TrackerTLSCA),
+		TLSCertFile:      os.Getenv(constants.TrackerTLSCert),
+		TLSKeyFile:       os.Getenv(constants.TrackerTLSKey),
+		TLSSkipVerify:    tlsSkipVerify,
+		KeyPrefix:        os.Getenv(constants.TrackerKeyPrefix),
+		TTL:              time.Duration(ttl) * time.Second,
+		IdleTimeout:      time.Duration(idleTimeout) * time.Second,
+		HealthCheck:      time.Duration(healthCheck) * time.Second,
 	}
 }
 
@@ -2,20 +2,79 @@ package config
 
 // Options contains all the CLI flags
 type Options struct {
-	Region             string `long:"region" description:"AWS region to use" default:"us-east-1"`
-	InstanceIdentifier string `short:"i" long:"identifier" description:"RDS instance identifier"`
-	DBType             string `long:"dbtype" description:"RDS database type. Accepted values are mysql and postgresql." default:"mysql"`
-	LogFile            string `short:"f" long:"log_file" description:"RDS log file to retrieve"`
-	Download           bool   `short:"d" long:"download" description:"Download old logs instead of tailing the current log"`
-	DownloadDir        string `long:"download_dir" description:"directory in to which log files are downloaded" default:"./"`
-	NumLines           int64  `long:"num_lines" description:"number of lines to request at a time from AWS. Larger number will be more efficient, smaller number will allow for longer lines" default:"10000"`
-	BackoffTimer       int64  `long:"backoff_timer" description:"how many seconds to pause when rate limited by AWS." default:"5"`
-	Output             string `short:"o" long:"output" description:"output for the logs: stdout or file" default:"stdout"`
-	Formatter          bool   `long:"formatter" description:"To format the logs in json"`
-	Tracker            bool   `long:"tracker" description:"To store the marker information"`
-	TrackerType        string `long:"tracker_type" description:"To store the marker information to some database" default:"redis"`
-	Version            bool   `short:"v" long:"version" description:"Output the current version and exit"`
-	ConfigFile         string `short:"c" long:"config" description:"config file" no-ini:"true"`
-	WriteDefaultConfig bool   `long:"write_default_config" description:"Write a default config file to STDOUT" no-ini:"true"`
-	Debug              bool   `long:"debug" description:"turn on debugging output"`
+	Region              string   `long:"region" description:"AWS region to use" default:"us-east-1"`
+	InstanceIdentifiers []string `short:"i" long:"identifier" description:"RDS instance identifier. Repeat the flag or pass a comma separated list to stream several instances"`
+	Clusters            []string `long:"cluster" description:"Aurora DB cluster identifier. Tails every member instance of the cluster and follows membership changes. Implies --formatter. Repeatable"`
+	Discover            bool     `long:"discover" description:"Tail every instance matching the --discover_* filters instead of the --identifier list"`
+	DiscoverTags        []string `long:"discover_tag" description:"Only discover instances carrying this tag, given as key or key=value. Repeat to require several tags"`
+	DiscoverEngines     []string `long:"discover_engine" description:"Only discover instances of this engine (mysql, mariadb, aurora-mysql, postgres, aurora-postgresql). Repeatable. Defaults to the engines of --dbtype"`
+	DiscoverPattern     string   `long:"discover_pattern" description:"Only discover instances whose identifier matches this glob pattern"`
+	DiscoverInterval    int64    `long:"discover_interval" description:"how many seconds to wait between instance discovery and cluster membership refreshes" default:"300"`
+	DBType              string   `long:"dbtype" description:"RDS database type. Accepted values are mysql and postgresql." default:"mysql"`
+	LogType             string   `long:"log_type" description:"Log file type. Accepted values are query, audit, error and general. Audit, error and general are only supported for mysql" default:"query"`
+	LogFile             string   `short:"f" long:"log_file" description:"RDS log file to retrieve"`
+	LogLinePrefix       string   `long:"log_line_prefix" description:"log_line_prefix of the PostgreSQL instance, used by --formatter to parse its log" default:"%t:%r:%u@%d:[%p]:"`
+	SeqScanRows         int64    `long:"seq_scan_rows" description:"report sequential scans of at least this many rows in the auto_explain plans of PostgreSQL logs" default:"10000"`
+	RedactionRules      string   `long:"redaction_rules" description:"JSON file with the rules removing sensitive data from formatted events. Replaces the default rules"`
+	FlushTimeout        int64    `long:"flush_timeout" description:"how many seconds the formatter waits for the rest of an incomplete log entry before publishing it" default:"10"`
+	Download            bool     `short:"d" long:"download" description:"Download old logs instead of tailing the current log"`
+	DownloadDir         string   `long:"download_dir" description:"directory in to which log files are downloaded" default:"./"`
+	NumLines            int64    `long:"num_lines" description:"number of lines to request at a time from AWS. Larger number will be more efficient, smaller number will allow for longer lines" default:"10000"`
+	BackoffTimer        int64    `long:"backoff_timer" description:"how many seconds to pause before retrying a throttled or otherwise retryable RDS call. Doubles with every attempt" default:"5"`
+	BackoffMax          int64    `long:"backoff_max" description:"maximum number of seconds to pause between retries of an RDS call" default:"300"`
+	MaxRetries          int64    `long:"max_retries" description:"how many times to retry an RDS call failing with a network or server error before giving up. Throttling is always retried, a missing log file for two minutes. 0 retries forever" default:"10"`
+	RateLimit           int64    `long:"rate_limit" description:"maximum number of RDS API requests per second shared by all instance streams. 0 means unlimited" default:"0"`
+	RestartTimer        int64    `long:"restart_timer" description:"how many seconds to wait before restarting an instance stream that failed" default:"30"`
+	Output              string   `short:"o" long:"output" description:"output for the logs: stdout, file, kafka, http, honeycomb or s3" default:"stdout"`
+	WriteKey            string   `long:"writekey" description:"Team write key, when output is honeycomb"`
+	Dataset             string   `long:"dataset" description:"Name of the dataset, when output is honeycomb"`
+	APIHost             string   `long:"api_host" description:"Hostname for the Honeycomb API server" default:"https://api.honeycomb.io/"`
+	SampleRate          uint     `long:"sample_rate" description:"Only send 1 / N log lines, when output is honeycomb" default:"1"`
+	HTTPURL             string   `long:"http_url" description:"endpoint to POST batches of events to, when output is http"`
+	HTTPFormat          string   `long:"http_format" description:"http batch format: ndjson or json (an array per batch)" default:"ndjson"`
+	HTTPHeaders         []string `long:"http_header" description:"extra http request header, as \"Name: value\". Repeatable"`
+	HTTPGzip            bool     `long:"http_gzip" description:"gzip the http request bodies"`
+	HTTPBatchSize       int      `long:"http_batch_size" description:"maximum number of events per http or honeycomb request" default:"500"`
+	HTTPLinger          int64    `long:"http_linger_ms" description:"how many milliseconds a partial http batch waits for more events before it is sent" default:"1000"`
+	HTTPMaxRetries      int      `long:"http_max_retries" description:"how many times to retry an http or honeycomb batch failing with a network error, 429 or 5xx" default:"5"`
+	HTTPBackoff         int64    `long:"http_backoff_ms" description:"milliseconds to wait before the first http or honeycomb retry. Doubles with every attempt" default:"500"`
+	HTTPTimeout         int64    `long:"http_timeout" description:"http and honeycomb request timeout in seconds" default:"30"`
+	HTTPDeadLetterFile  string   `long:"http_dead_letter_file" description:"file to which http batches and honeycomb events that failed for good are appended as NDJSON. Without it a failed batch stops the stream and rejected honeycomb events are logged and dropped"`
+	S3Bucket            string   `long:"s3_bucket" description:"bucket to archive the logs in, when output is s3"`
+	S3KeyTemplate       string   `long:"s3_key_template" description:"object key template with the placeholders {instance}, {logtype}, {file}, {date}, {hour}, {from} and {to}" default:"{instance}/{logtype}/{date}/{hour}/{file}.{from}-{to}"`
+	S3Gzip              bool     `long:"s3_gzip" description:"gzip the archived objects"`
+	S3Region            string   `long:"s3_region" description:"region of the s3 bucket. Defaults to --region"`
+	S3Endpoint          string   `long:"s3_endpoint" description:"S3 compatible endpoint to use instead of AWS, e.g. http://localhost:9000 for MinIO. Addressed path style"`
+	S3PartSize          int64    `long:"s3_part_size" description:"size in MB of the parts of multipart uploads" default:"5"`
+	S3BufferSize        int64    `long:"s3_buffer_size" description:"size in MB after which the data streamed from an instance is uploaded as one object" default:"16"`
+	S3BufferAge         int64    `long:"s3_buffer_seconds" description:"seconds after which the data streamed from an instance is uploaded as one object, if --s3_buffer_size isn't reached first" default:"60"`
+	KafkaBrokers        []string `long:"kafka_brokers" description:"Kafka broker addresses (host:port), when output is kafka. Repeatable or comma separated"`
+	KafkaTopic          string   `long:"kafka_topic" description:"Kafka topic to produce to, when output is kafka"`
+	KafkaPartitionKey   string   `long:"kafka_partition_key" description:"Kafka message key: instance, database or user. database and user need --formatter" default:"instance"`
+	KafkaBatchSize      int      `long:"kafka_batch_size" description:"maximum number of messages sent to Kafka in one batch" default:"100"`
+	KafkaLinger         int64    `long:"kafka_linger_ms" description:"how many milliseconds to wait for a Kafka batch to fill up" default:"10"`
+	KafkaCompression    string   `long:"kafka_compression" description:"Kafka compression codec: none, gzip, snappy, lz4 or zstd" default:"none"`
+	KafkaAcks           string   `long:"kafka_acks" description:"Kafka acknowledgements to wait for before committing markers: all, one or none" default:"all"`
+	KafkaTLS            bool     `long:"kafka_tls" description:"Connect to the Kafka brokers with TLS"`
+	KafkaTLSCA          string   `long:"kafka_tls_ca" description:"PEM file with the CA certificates to verify the Kafka brokers with"`
+	KafkaTLSCert        string   `long:"kafka_tls_cert" description:"PEM client certificate file for Kafka"`
+	KafkaTLSKey         string   `long:"kafka_tls_key" description:"PEM client key file for Kafka"`
+	KafkaTLSSkipVerify  bool     `long:"kafka_tls_skip_verify" description:"Don't verify the certificates of the Kafka brokers"`
+	KafkaSASLMechanism  string   `long:"kafka_sasl_mechanism" description:"Kafka SASL mechanism: plain, scram-sha-256 or scram-sha-512"`
+	KafkaSASLUser       string   `long:"kafka_sasl_user" description:"Kafka SASL user name"`
+	KafkaSASLPassword   string   `long:"kafka_sasl_password" description:"Kafka SASL password"`
+	Formatter           bool     `long:"formatter" description:"To format the logs in json"`
+	Tracker             bool     `long:"tracker" description:"To store the marker information"`
+	TrackerType         string   `long:"tracker_type" description:"Where to store the marker information: redis, file or bolt" default:"redis"`
+	TrackerAccount      string   `long:"tracker_account" description:"AWS account ID in the tracker keys. Looked up with sts:GetCallerIdentity when empty"`
+	TrackerDir          string   `long:"tracker_dir" description:"state directory of the file tracker, holding a marker file per account, region, instance and log type" default:"./rdslogs_state"`
+	TrackerDB           string   `long:"tracker_db" description:"database of the bolt tracker, which also keeps the history of the markers" default:"./rdslogs_state.db"`
+	TrackerHistory      int64    `long:"tracker_history_hours" description:"how many hours the bolt tracker keeps the marker history. 0 keeps it forever" default:"24"`
+	LeaderElection      bool     `long:"leader_election" description:"Only stream an instance while holding its lease in the redis or file tracker, so that replicas can run active/passive"`
+	LeaseTTL            int64    `long:"lease_ttl" description:"seconds after which the lease of a leader which stopped renewing it is taken over by a standby" default:"15"`
+	LeaseOwner          string   `long:"lease_owner" description:"name of this process in the leases. Defaults to the host name and process ID"`
+	Version             bool     `short:"v" long:"version" description:"Output the current version and exit"`
+	ConfigFile          string   `short:"c" long:"config" description:"config file" no-ini:"true"`
+	WriteDefaultConfig  bool     `long:"write_default_config" description:"Write a default config file to STDOUT" no-ini:"true"`
+	Debug               bool     `long:"debug" description:"turn on debugging output"`
 }
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // RedisConfig ....
 type RedisConfig struct {
 	Host      string
@@ -8,4 +10,26 @@ type RedisConfig struct {
 	Database  int
 	MaxIdle   int
 	MaxActive int
+	// Mode is standalone, sentinel or cluster
+	Mode string
+	// Addrs are the host:port of the sentinels or of the cluster nodes to
+	// discover the cluster from. Host:Port is used when empty.
+	Addrs []string
+	// SentinelMaster is the name of the master monitored by the sentinels
+	SentinelMaster   string
+	SentinelPassword string
+	TLS              bool
+	TLSCAFile        string
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSSkipVerify    bool
+	// KeyPrefix is prepended to every key
+	KeyPrefix string
+	// TTL expires markers which weren't written for that long, never when 0
+	TTL time.Duration
+	// IdleTimeout closes connections idle for that long
+	IdleTimeout time.Duration
+	// HealthCheck is how long a connection may be idle before it is checked
+	// with PING when taken from the pool
+	HealthCheck time.Duration
 }
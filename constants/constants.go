@@ -5,7 +5,60 @@ const (
 
 	OutputFile = "file"
 
+	OutputKafka = "kafka"
+
+	OutputHTTP = "http"
+
+	OutputHoneycomb = "honeycomb"
+
+	OutputS3 = "s3"
+
 	DBTypePostgreSQL = "postgresql"
 
 	DBTypeMySQL = "mysql"
+
+	// RDS engine names as reported by DescribeDBInstances
+	EngineMySQL = "mysql"
+
+	EngineMariaDB = "mariadb"
+
+	EngineAurora = "aurora"
+
+	EngineAuroraMySQL = "aurora-mysql"
+
+	EnginePostgres = "postgres"
+
+	EngineAuroraPostgreSQL = "aurora-postgresql"
+
+	// LogTypeQuery is the slow query log of MySQL or the log of PostgreSQL
+	LogTypeQuery = "query"
+
+	// LogTypeAudit is the log of the MariaDB audit plugin
+	LogTypeAudit = "audit"
+
+	// LogTypeError is the MySQL error log
+	LogTypeError = "error"
+
+	// LogTypeGeneral is the MySQL general query log
+	LogTypeGeneral = "general"
 )
+
+// DefaultLogFiles is the log file read for each DBType and log type when no
+// --log_file is given
+var DefaultLogFiles = map[string]map[string]string{
+	DBTypeMySQL: {
+		LogTypeQuery:   "slowquery/mysql-slowquery.log",
+		LogTypeAudit:   "audit/server_audit.log",
+		LogTypeError:   "error/mysql-error.log",
+		LogTypeGeneral: "general/mysql-general.log",
+	},
+	DBTypePostgreSQL: {
+		LogTypeQuery: "error/postgresql.log",
+	},
+}
+
+// DBTypeEngines lists the RDS engines whose logs each DBType can read
+var DBTypeEngines = map[string][]string{
+	DBTypeMySQL:      {EngineMySQL, EngineMariaDB, EngineAurora, EngineAuroraMySQL},
+	DBTypePostgreSQL: {EnginePostgres, EngineAuroraPostgreSQL},
+}
@@ -3,6 +3,10 @@ package constants
 const(
 	TrackerRedis = "redis"
 
+	TrackerFile = "file"
+
+	TrackerBolt = "bolt"
+
 	TrackerDatabase = "TRACKER_DATABASE"
 
 	TrackerMaxIdle = "TRACKER_MAXIDLE"
@@ -14,4 +18,36 @@ const(
 	TrackerPassword = "TRACKER_PASSWORD"
 
 	TrackerPort = "TRACKER_PORT"
+
+	TrackerMode = "TRACKER_MODE"
+
+	TrackerAddrs = "TRACKER_ADDRS"
+
+	TrackerSentinelMaster = "TRACKER_SENTINEL_MASTER"
+
+	TrackerSentinelPassword = "TRACKER_SENTINEL_PASSWORD"
+
+	TrackerTLS = "TRACKER_TLS"
+
+	TrackerTLSCA = "TRACKER_TLS_CA"
+
+	TrackerTLSCert = "TRACKER_TLS_CERT"
+
+	TrackerTLSKey = "TRACKER_TLS_KEY"
+
+	TrackerTLSSkipVerify = "TRACKER_TLS_SKIP_VERIFY"
+
+	TrackerKeyPrefix = "TRACKER_KEY_PREFIX"
+
+	TrackerTTL = "TRACKER_TTL"
+
+	TrackerIdleTimeout = "TRACKER_IDLE_TIMEOUT"
+
+	TrackerHealthCheck = "TRACKER_HEALTH_CHECK"
+
+	RedisModeStandalone = "standalone"
+
+	RedisModeSentinel = "sentinel"
+
+	RedisModeCluster = "cluster"
 )
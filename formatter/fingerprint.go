@@ -0,0 +1,116 @@
+package formatter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// queryDigest returns the fingerprint of query and its digest, or empty
+// strings when query is empty
+func queryDigest(query string, d dialect) (string, string) {
+	fp := fingerprint(query, d)
+	if fp == "" {
+		return "", ""
+	}
+	return fp, digest(fp)
+}
+
+// digest returns a stable hash of a fingerprint, identifying the queries of
+// the same shape
+func digest(fp string) string {
+	sum := sha256.Sum256([]byte(fp))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprint normalizes query to its shape: comments are removed, string,
+// number and parameter literals are replaced by "?", lists of literals in
+// IN (...) and VALUES (...), (...) collapse to "(?+)", keywords and
+// identifiers are lowercased and whitespace is collapsed. It works on the
+// tokens of query rather than on the pattern matches of the Redactor rules,
+// so no literal is left behind whatever it contains.
+func fingerprint(query string, d dialect) string {
+	var texts []string
+	for _, token := range tokenize(query, d) {
+		texts = append(texts, token.text)
+	}
+	tokens := collapseLists(texts)
+	for len(tokens) > 0 && tokens[len(tokens)-1] == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var b strings.Builder
+	for i, token := range tokens {
+		if i > 0 && spaceBetween(tokens[i-1], token) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(token)
+	}
+	return b.String()
+}
+
+// collapseLists replaces the lists of literals of IN (...) and of the rows
+// of VALUES (...), (...) by "(?+)", so that their length doesn't change the
+// fingerprint
+func collapseLists(tokens []string) []string {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i] != "in" && tokens[i] != "values" {
+			continue
+		}
+
+		end, ok := literalList(tokens, i+1)
+		if !ok {
+			continue
+		}
+		if tokens[i] == "values" {
+			// further rows of literals
+			for end+1 < len(tokens) && tokens[end] == "," {
+				next, ok := literalList(tokens, end+1)
+				if !ok {
+					break
+				}
+				end = next
+			}
+		}
+		out = append(out, "(", literal+"+", ")")
+		i = end - 1
+	}
+	return out
+}
+
+// literalList returns the index after the parenthesized list of literals
+// starting at i
+func literalList(tokens []string, i int) (int, bool) {
+	if i >= len(tokens) || tokens[i] != "(" {
+		return 0, false
+	}
+	for j := i + 1; j < len(tokens); j += 2 {
+		if tokens[j] != literal {
+			return 0, false
+		}
+		if j+1 < len(tokens) && tokens[j+1] == ")" {
+			return j + 2, true
+		}
+		if j+1 >= len(tokens) || tokens[j+1] != "," {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// spaceBetween reports whether the rendered fingerprint separates the tokens
+// prev and next by a space
+func spaceBetween(prev, next string) bool {
+	switch {
+	case prev == "(" || prev == "." || prev == "::":
+		return false
+	case next == ")" || next == "," || next == "." || next == ";" || next == "::":
+		return false
+	case next == "(" && (isWordByte(prev[0]) || prev[0] == '"'):
+		// function calls and the lists of IN and VALUES
+		return false
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package formatter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		dialect       dialect
+		query, expect string
+	}{
+		{dialectMySQL, "SELECT * FROM orders WHERE id = 7", "select * from orders where id = ?"},
+		{dialectMySQL, "select *\n  from `orders`\twhere id=42;", "select * from orders where id = ?"},
+		{dialectMySQL, "SELECT a FROM t WHERE x = 'it''s' AND y = \"a\\\"b\" AND z = -1.5e3", "select a from t where x = ? and y = ? and z = ?"},
+		{dialectMySQL, "SELECT a - 1, b+2 FROM t", "select a - ?, b + ? from t"},
+		{dialectMySQL, "SELECT 1 /* comment 'x' */ FROM t -- trailing 2\n# hash 3\nWHERE id IN (1, 2, 3)", "select ? from t where id in(?+)"},
+		{dialectMySQL, "SELECT id FROM t WHERE id IN (4)", "select id from t where id in(?+)"},
+		{dialectMySQL, "SELECT id FROM t WHERE id IN (SELECT id FROM u)", "select id from t where id in(select id from u)"},
+		{dialectMySQL, "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'),(3,'z')", "insert into t(a, b) values(?+)"},
+		{dialectMySQL, "INSERT INTO t VALUES (1, NOW())", "insert into t values(?, now())"},
+		{dialectMySQL, "SELECT * FROM t WHERE b = X'0F' AND c = _utf8mb4'secret' AND d = 0xFF", "select * from t where b = ? and c = ? and d = ?"},
+		{dialectMySQL, "SELECT * FROM t WHERE email = 'jane@example.com'", "select * from t where email = ?"},
+		{dialectMySQL, "SELECT * FROM t WHERE id = ?", "select * from t where id = ?"},
+		{dialectPostgres, `SELECT "Name" FROM "Users" WHERE id = $1 AND note = 'a\'`, `select "Name" from "Users" where id = ? and note = ?`},
+		{dialectPostgres, "SELECT E'a\\'b', $$it's$$, $fn$x$fn$::text", "select ?, ?, ?::text"},
+		{dialectPostgres, "UPDATE t SET a = 1 WHERE id IN ($1, $2);", "update t set a = ? where id in(?+)"},
+		{dialectPostgres, "  ", ""},
+	}
+	for _, test := range tests {
+		if got := fingerprint(test.query, test.dialect); got != test.expect {
+			t.Errorf("fingerprint(%q): expected %q, got %q", test.query, test.expect, got)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	fp, d := queryDigest("SELECT * FROM orders WHERE id = 7", dialectMySQL)
+	other, otherDigest := queryDigest("select * from orders\n where id = 12345;", dialectMySQL)
+	if fp != other || d != otherDigest {
+		t.Errorf("expected queries of the same shape to share the digest, got %q %q and %q %q", fp, d, other, otherDigest)
+	}
+	// the digest must not change between releases
+	if d != "fbde135c64f4f643" {
+		t.Errorf("unexpected digest %q", d)
+	}
+	if _, d := queryDigest("SELECT * FROM orders WHERE user_id = 7", dialectMySQL); d == otherDigest {
+		t.Error("expected another digest for another query shape")
+	}
+	if fp, d := queryDigest("", dialectMySQL); fp != "" || d != "" {
+		t.Errorf("expected no digest of an empty query, got %q %q", fp, d)
+	}
+}
+
+func TestPostgresFormatterFingerprint(t *testing.T) {
+	log := "2022-09-01 08:00:01 UTC:10.0.1.7(5432):app@orders:[812]:LOG:  duration: 1.5 ms  execute S_1: SELECT * FROM orders WHERE id = $1\n" +
+		"2022-09-01 08:00:01 UTC:10.0.1.7(5432):app@orders:[812]:DETAIL:  parameters: $1 = '42'\n"
+	f := &PostgresFormatter{}
+	events := append(f.Format(log), f.Flush()...)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %v", events)
+	}
+	var data PostgresData
+	if err := json.Unmarshal([]byte(events[0]), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Fingerprint != "select * from orders where id = ?" || data.Digest != digest(data.Fingerprint) {
+		t.Errorf("unexpected fingerprint %q and digest %q", data.Fingerprint, data.Digest)
+	}
+}
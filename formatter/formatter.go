@@ -1,16 +1,28 @@
 package formatter
 
-import (
-	"regexp"
-	"strings"
-
-	"github.com/razorpay/rdslogs/constants"
-)
-
 type Formatter interface {
 	Format(string) []string
 }
 
+// StreamFormatter is a Formatter which keeps incomplete entries between
+// calls of Format. Each log file needs its own.
+type StreamFormatter interface {
+	Formatter
+	// Flush returns the events of the incomplete entry and resets the state
+	Flush() []string
+	// Pending returns the number of bytes of the log kept for the next call
+	Pending() int
+}
+
+// Event types of the formatted events
+const (
+	EventTypeLog     = "log"
+	EventTypeQuery   = "query"
+	EventTypeAudit   = "audit"
+	EventTypeError   = "error"
+	EventTypeGeneral = "general"
+)
+
 type JsonData struct {
 	Time         string
 	User         string
@@ -23,21 +35,22 @@ type JsonData struct {
 	DatabaseName string
 	Timestamp    int64
 	Query        string
-}
-
-func removeSensitiveData(data string) string {
-	regexps := map[string]string{
-		constants.RegexEmail:     constants.RegexDefaultReplace,
-		constants.RegexIpAddress: constants.RegexDefaultReplace,
-		constants.RegexMobile:    constants.RegexDefaultReplace,
-		constants.RegexName:      constants.RegexNameReplace,
-	}
-
-	data = strings.Replace(data, "`", "", -1)
-
-	for reg, repl := range regexps {
-		data = regexp.MustCompile(reg).ReplaceAllString(data, repl)
-	}
-
-	return data
+	// Fingerprint is the shape of Query, Digest its hash
+	Fingerprint string
+	Digest      string
+
+	// fields of log_slow_extra and log_slow_verbosity
+	ThreadId       int64 `json:",omitempty"`
+	Errno          int64 `json:",omitempty"`
+	Killed         int64 `json:",omitempty"`
+	RowsAffected   int64 `json:",omitempty"`
+	BytesSent      int64 `json:",omitempty"`
+	BytesReceived  int64 `json:",omitempty"`
+	TmpTables      int64 `json:",omitempty"`
+	TmpDiskTables  int64 `json:",omitempty"`
+	MergePasses    int64 `json:",omitempty"`
+	FullScan       bool  `json:",omitempty"`
+	FullJoin       bool  `json:",omitempty"`
+	Filesort       bool  `json:",omitempty"`
+	FilesortOnDisk bool  `json:",omitempty"`
 }
@@ -2,80 +2,214 @@ package formatter
 
 import (
 	"encoding/json"
-	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
-type MySQLFormatter struct {}
+// MySQLFormatter parses the slow query log. It keeps the entry being read
+// and an unterminated last line between calls of Format, so entries split
+// across the chunks of a log file are formatted once complete. An entry is
+// complete when the next one starts; Flush formats the last one.
+type MySQLFormatter struct {
+	// partial is the unterminated last line of the previous chunk
+	partial string
+	// entry holds the lines of the entry being read, entrySize their size
+	// in the log
+	entry     []string
+	entrySize int
+	// dbName is the database of the last use statement
+	dbName string
+	// lastTime is the time of the last entry, which later entries inherit
+	// until it changes
+	lastTime string
+	// Redactor removes sensitive data, the default rules when nil
+	Redactor *Redactor
+}
 
 func (f *MySQLFormatter) Format(log string) []string {
-	logSlice := strings.Split(log, "\n")
+	var QueryStrings []string
 
-	dbName := ""
-	counter := 0
-	data := JsonData{}
+	log = f.partial + log
+	f.partial = ""
+	logSlice := strings.SplitAfter(log, "\n")
+	if last := logSlice[len(logSlice)-1]; !strings.HasSuffix(last, "\n") {
+		f.partial = last
+		logSlice = logSlice[:len(logSlice)-1]
+	}
+
+	for _, raw := range logSlice {
+		line := strings.TrimRight(raw, "\r\n")
+
+		if f.startsEntry(strings.Trim(line, " ")) {
+			if jsonData, ok := f.formatEntry(); ok {
+				QueryStrings = append(QueryStrings, jsonData)
+			}
+			f.entry = nil
+			f.entrySize = 0
+		}
+		f.entry = append(f.entry, line)
+		f.entrySize += len(raw)
+	}
+
+	return QueryStrings
+}
+
+// Flush formats the entry being read, e.g. when the log file rotated or no
+// new data arrived for a while, and resets the state
+func (f *MySQLFormatter) Flush() []string {
 	var QueryStrings []string
+	if f.partial != "" {
+		f.entry = append(f.entry, strings.TrimRight(f.partial, "\r"))
+	}
+	if jsonData, ok := f.formatEntry(); ok {
+		QueryStrings = append(QueryStrings, jsonData)
+	}
+	f.partial = ""
+	f.entry = nil
+	f.entrySize = 0
+	return QueryStrings
+}
+
+// Pending returns the number of bytes of the log kept for the next call
+func (f *MySQLFormatter) Pending() int {
+	return f.entrySize + len(f.partial)
+}
 
-	for _, line := range logSlice {
-		if counter == 0 {
-			data = JsonData{}
+// startsEntry reports whether line starts a new entry after the lines read
+// so far. Entries start with "# Time", or "# User@Host" when the time didn't
+// change since the previous entry.
+func (f *MySQLFormatter) startsEntry(line string) bool {
+	if !strings.HasPrefix(line, "# Time") && !strings.HasPrefix(line, "# User@Host") {
+		return false
+	}
+	for _, l := range f.entry {
+		l = strings.Trim(l, " ")
+		if l == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# Time") || !strings.HasPrefix(l, "# Time") {
+			return true
 		}
+	}
+	return false
+}
 
-		counter++
+// formatEntry returns the event of the entry being read. The query is
+// everything after the header lines and the use and SET timestamp
+// statements, up to the next entry.
+func (f *MySQLFormatter) formatEntry() (string, bool) {
+	data := JsonData{}
+	var queryLines []string
 
-		line = strings.Trim(line, " ")
+	for _, raw := range f.entry {
+		line := strings.Trim(raw, " ")
 
+		if len(queryLines) > 0 {
+			queryLines = append(queryLines, raw)
+			continue
+		}
 		if line == "" {
 			continue
 		}
 
-		if strings.Contains(line, "# Time") {
+		if strings.HasPrefix(line, "# Time") {
 			data.Time = getQueryTime(line)
-		} else if strings.Contains(line, "# User@Host") {
+			f.lastTime = data.Time
+		} else if strings.HasPrefix(line, "# User@Host") {
 			data.User = getUser(line)
 
 			data.Host = getHost(line)
 
 			data.ConnectionId = getConnectionId(line)
-		} else if strings.Contains(line, "# Query_time") {
-			data.QueryTime = getQueryTimes(line, "Query_time")
-
-			data.LockTime = getQueryTimes(line, "Lock_time")
-
-			data.RowsSent = getRowsCount(line, "Rows_sent")
-
-			data.RowsExamined = getRowsCount(line, "Rows_examined")
+		} else if strings.HasPrefix(line, "# administrator command: ") {
+			queryLines = append(queryLines, strings.TrimPrefix(line, "# "))
+		} else if strings.HasPrefix(line, "#") {
+			setHeaderFields(&data, line)
 		} else if strings.Index(strings.ToLower(line), "use ") == 0 {
-			dbName = getDatabaseName(line)
-			data.DatabaseName = dbName
+			f.dbName = getDatabaseName(line)
+			data.DatabaseName = f.dbName
 		} else if strings.Index(strings.ToLower(line), "set timestamp") == 0 {
 			data.Timestamp = getTimestamp(line)
 		} else {
-			data.Query = removeSensitiveData(line)
-
-			if data.Time == "" {
-				continue
-			}
+			queryLines = append(queryLines, raw)
+		}
+	}
 
-			if data.DatabaseName == "" && dbName != "" {
-				data.DatabaseName = dbName
-			}
+	query := strings.TrimSpace(strings.Join(queryLines, "\n"))
+	data.Fingerprint, data.Digest = queryDigest(query, dialectMySQL)
+	data.Query = f.Redactor.redact(query, dialectMySQL)
 
-			jsonData, err := json.Marshal(data)
+	if data.Time == "" {
+		data.Time = f.lastTime
+	}
+	if data.Time == "" || data.Query == "" {
+		return "", false
+	}
 
-			if err != nil {
-				continue
-			}
+	if data.DatabaseName == "" && f.dbName != "" {
+		data.DatabaseName = f.dbName
+	}
 
-			QueryStrings = append(QueryStrings, string(jsonData))
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", false
+	}
+	return string(jsonData), true
+}
 
-			counter = 0
+// headerFieldRegex matches the "Name: value" pairs of the header lines, e.g.
+// "# Query_time: 1.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 5000"
+var headerFieldRegex = regexp.MustCompile(`([A-Za-z_]+): +(\S+)`)
+
+// setHeaderFields sets the fields of a header line on data. Besides the
+// standard fields, these are the fields of log_slow_extra (MySQL 8) and of
+// the Percona and MariaDB log_slow_verbosity.
+func setHeaderFields(data *JsonData, line string) {
+	for _, match := range headerFieldRegex.FindAllStringSubmatch(line, -1) {
+		value := match[2]
+		number, _ := strconv.ParseInt(value, 10, 64)
+		yes := value == "Yes"
+
+		switch match[1] {
+		case "Query_time":
+			data.QueryTime, _ = strconv.ParseFloat(value, 64)
+		case "Lock_time":
+			data.LockTime, _ = strconv.ParseFloat(value, 64)
+		case "Rows_sent":
+			data.RowsSent = number
+		case "Rows_examined":
+			data.RowsExamined = number
+		case "Rows_affected":
+			data.RowsAffected = number
+		case "Bytes_sent":
+			data.BytesSent = number
+		case "Bytes_received":
+			data.BytesReceived = number
+		case "Thread_id":
+			data.ThreadId = number
+		case "Errno":
+			data.Errno = number
+		case "Killed":
+			data.Killed = number
+		case "Tmp_tables", "Created_tmp_tables":
+			data.TmpTables = number
+		case "Tmp_disk_tables", "Created_tmp_disk_tables":
+			data.TmpDiskTables = number
+		case "Merge_passes", "Sort_merge_passes":
+			data.MergePasses = number
+		case "Full_scan":
+			data.FullScan = yes
+		case "Full_join":
+			data.FullJoin = yes
+		case "Filesort":
+			data.Filesort = yes
+		case "Filesort_on_disk":
+			data.FilesortOnDisk = yes
+		case "Schema":
+			data.DatabaseName = value
 		}
 	}
-
-	return QueryStrings
 }
 
 func getQueryTime(str string) string {
@@ -88,9 +222,11 @@ func getQueryTime(str string) string {
 	return ""
 }
 
+// userHostRegex matches "# User@Host: app[app] @ host [10.0.1.7]  Id:    12"
+var userHostRegex = regexp.MustCompile(`User@Host: ([^\[\s]*)\[[^\]]*\] @ *(\S*) *\[([^\]]*)\](?:\s+Id:\s*(\d+))?`)
+
 func getUser(str string) string {
-	regex := "Host: ([a-z0-9_-]*)"
-	match := regexp.MustCompile(regex).FindStringSubmatch(str)
+	match := userHostRegex.FindStringSubmatch(str)
 
 	if len(match) > 1 {
 		return match[1]
@@ -98,22 +234,24 @@ func getUser(str string) string {
 	return ""
 }
 
+// getHost returns the IP of the client, or its host name without one
 func getHost(str string) string {
-	regex := `(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}`
-	match := regexp.MustCompile(regex).FindStringSubmatch(str)
+	match := userHostRegex.FindStringSubmatch(str)
 
-	if len(match) > 0 {
-		return match[0]
+	if len(match) > 3 {
+		if match[3] != "" {
+			return match[3]
+		}
+		return match[2]
 	}
 	return ""
 }
 
 func getConnectionId(str string) int64 {
-	regex := "Id: ([0-9]*)"
-	match := regexp.MustCompile(regex).FindStringSubmatch(str)
+	match := userHostRegex.FindStringSubmatch(str)
 
-	if len(match) > 1 {
-		connectionId, err := strconv.ParseInt(match[1], 10, 64)
+	if len(match) > 4 {
+		connectionId, err := strconv.ParseInt(match[4], 10, 64)
 		if err != nil {
 			return 0
 		}
@@ -122,36 +260,6 @@ func getConnectionId(str string) int64 {
 	return 0
 }
 
-func getQueryTimes(str string, queryType string) float64 {
-	regex := fmt.Sprintf("%s: (([0-9]*).([0-9]*))", queryType)
-
-	match := regexp.MustCompile(regex).FindStringSubmatch(str)
-	if len(match) > 1 {
-		queryTime, err := strconv.ParseFloat(match[1], 64)
-
-		if err != nil {
-			return 0.0
-		}
-		return queryTime
-	}
-	return 0.0
-}
-
-func getRowsCount(str string, queryType string) int64 {
-	regex := fmt.Sprintf("%s: (([0-9]*).([0-9]*))", queryType)
-
-	match := regexp.MustCompile(regex).FindStringSubmatch(str)
-	if len(match) > 1 {
-		count, err := strconv.ParseInt(match[1], 10, 64)
-
-		if err != nil {
-			return 0
-		}
-		return count
-	}
-	return 0
-}
-
 func getDatabaseName(str string) string {
 	str = strings.Replace(str, "use ", "", -1)
 	str = strings.Replace(str, "USE ", "", -1)
@@ -160,11 +268,11 @@ func getDatabaseName(str string) string {
 }
 
 func getTimestamp(str string) int64 {
-	regex := "([0-9]*)"
+	regex := "(?i)timestamp=([0-9]+)"
 	match := regexp.MustCompile(regex).FindStringSubmatch(str)
 
-	if len(match) > 0 {
-		queryTime, err := strconv.ParseInt(match[0], 10, 64)
+	if len(match) > 1 {
+		queryTime, err := strconv.ParseInt(match[1], 10, 64)
 
 		if err != nil {
 			return 0
@@ -0,0 +1,129 @@
+package formatter
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditData is one record of the MariaDB audit plugin log, as written to
+// audit/server_audit.log by RDS MySQL and MariaDB
+type AuditData struct {
+	EventType    string
+	Time         string
+	Timestamp    int64
+	ServerHost   string
+	User         string
+	Host         string
+	ConnectionId int64
+	QueryId      int64
+	Operation    string
+	DatabaseName string
+	Object       string
+	// Query is the statement of QUERY records
+	Query string `json:",omitempty"`
+	// Fingerprint is the shape of Query, Digest its hash
+	Fingerprint string `json:",omitempty"`
+	Digest      string `json:",omitempty"`
+	RetCode     int64
+}
+
+// auditRecordRegex matches the start of an audit record: the timestamp as
+// microseconds since the epoch (RDS) or as "20220901 08:00:01" (MariaDB)
+var auditRecordRegex = regexp.MustCompile(`^(\d{16}|\d{8} \d{2}:\d{2}:\d{2}),`)
+
+// MySQLAuditFormatter parses the records of the MariaDB audit plugin log:
+// timestamp,serverhost,username,host,connectionid,queryid,operation,database,object,retcode
+type MySQLAuditFormatter struct {
+	// Redactor removes sensitive data, the default rules when nil
+	Redactor *Redactor
+}
+
+func (f *MySQLAuditFormatter) Format(log string) []string {
+	var events []string
+	var record string
+
+	emit := func() {
+		if record == "" {
+			return
+		}
+		if data, ok := parseAuditRecord(record, f.Redactor); ok {
+			if jsonData, err := json.Marshal(data); err == nil {
+				events = append(events, string(jsonData))
+			}
+		}
+		record = ""
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if auditRecordRegex.MatchString(line) {
+			emit()
+			record = line
+		} else if record != "" && line != "" {
+			// the object of the record spans several lines
+			record += "\n" + line
+		}
+	}
+	emit()
+
+	return events
+}
+
+// parseAuditRecord splits an audit record. The object may contain commas,
+// so the fields before it are split from the front and the retcode from
+// the back. The object is redacted by r.
+func parseAuditRecord(record string, r *Redactor) (AuditData, bool) {
+	fields := strings.SplitN(record, ",", 9)
+	if len(fields) < 9 {
+		return AuditData{}, false
+	}
+	i := strings.LastIndex(fields[8], ",")
+	if i < 0 {
+		return AuditData{}, false
+	}
+	object, retCode := fields[8][:i], fields[8][i+1:]
+
+	data := AuditData{
+		EventType:    EventTypeAudit,
+		ServerHost:   fields[1],
+		User:         fields[2],
+		Host:         fields[3],
+		Operation:    fields[6],
+		DatabaseName: fields[7],
+		Object:       unquoteAuditObject(object),
+	}
+	data.ConnectionId, _ = strconv.ParseInt(fields[4], 10, 64)
+	data.QueryId, _ = strconv.ParseInt(fields[5], 10, 64)
+	data.RetCode, _ = strconv.ParseInt(strings.TrimSpace(retCode), 10, 64)
+	if data.Operation == "QUERY" {
+		data.Fingerprint, data.Digest = queryDigest(data.Object, dialectMySQL)
+	}
+	data.Object = r.redact(data.Object, dialectMySQL)
+	if data.Operation == "QUERY" {
+		data.Query = data.Object
+	}
+
+	if micros, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+		t := time.UnixMicro(micros).UTC()
+		data.Time = t.Format(time.RFC3339Nano)
+		data.Timestamp = t.Unix()
+	} else if t, err := time.Parse("20060102 15:04:05", fields[0]); err == nil {
+		data.Time = t.Format(time.RFC3339Nano)
+		data.Timestamp = t.Unix()
+	}
+	return data, true
+}
+
+// unquoteAuditObject strips the quotes the audit plugin puts around the
+// object and unescapes the quotes within it
+func unquoteAuditObject(object string) string {
+	if len(object) >= 2 && object[0] == '\'' && object[len(object)-1] == '\'' {
+		object = object[1 : len(object)-1]
+		object = strings.ReplaceAll(object, `\'`, `'`)
+		object = strings.ReplaceAll(object, `\\`, `\`)
+	}
+	return object
+}
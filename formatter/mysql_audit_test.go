@@ -0,0 +1,45 @@
+package formatter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMySQLAuditFormatter(t *testing.T) {
+	log := `1662019201123456,ip-10-0-0-1,app,10.0.1.7,812,9001,QUERY,orders,'SELECT * FROM users WHERE name = \'jane\', id = 7',0
+20220901 08:00:02,ip-10-0-0-1,app,10.0.1.7,812,0,CONNECT,orders,,0
+1662019203000000,ip-10-0-0-1,app,10.0.1.7,812,9002,QUERY,orders,'INSERT INTO notes VALUES (\'a,b\',
+\'c\')',1146
+`
+	var records []AuditData
+	for _, event := range (&MySQLAuditFormatter{}).Format(log) {
+		var data AuditData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatalf("invalid event %s: %s", event, err)
+		}
+		records = append(records, data)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d: %+v", len(records), records)
+	}
+
+	query := records[0]
+	if query.EventType != EventTypeAudit || query.Time != "2022-09-01T08:00:01.123456Z" || query.Timestamp != 1662019201 ||
+		query.ServerHost != "ip-10-0-0-1" || query.User != "app" || query.Host != "10.0.1.7" ||
+		query.ConnectionId != 812 || query.QueryId != 9001 || query.Operation != "QUERY" ||
+		query.DatabaseName != "orders" || query.RetCode != 0 {
+		t.Errorf("unexpected query record: %+v", query)
+	}
+	if query.Query != "SELECT * FROM users WHERE name = '?', id = 7" {
+		t.Errorf("unexpected query: %q", query.Query)
+	}
+
+	connect := records[1]
+	if connect.Operation != "CONNECT" || connect.Time != "2022-09-01T08:00:02Z" || connect.Object != "" || connect.Query != "" {
+		t.Errorf("unexpected connect record: %+v", connect)
+	}
+
+	if records[2].RetCode != 1146 || records[2].Query != "INSERT INTO notes VALUES ('a,b',\n'c')" {
+		t.Errorf("unexpected multi-line record: %+v", records[2])
+	}
+}
@@ -0,0 +1,102 @@
+package formatter
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorLogData is one entry of error/mysql-error.log
+type ErrorLogData struct {
+	EventType string
+	Time      string
+	Timestamp int64
+	Thread    int64
+	Severity  string
+	ErrorCode string `json:",omitempty"`
+	Subsystem string `json:",omitempty"`
+	Message   string
+	// Trace holds the lines following the entry, e.g. a stack trace
+	Trace string `json:",omitempty"`
+}
+
+// errorLogRegex matches the first line of an error log entry of MySQL 8
+// ("2022-09-01T08:00:01.123456Z 12 [Warning] [MY-010055] [Server] ..."),
+// MySQL 5.7 ("2022-09-01T08:00:01.123456Z 0 [Note] InnoDB: ...") and MariaDB
+// or MySQL 5.6 ("2022-09-01  8:00:01 140123 [Note] ...", "220901  8:00:01 [ERROR] ...")
+var errorLogRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})?|\d{4}-\d{2}-\d{2}\s+\d{1,2}:\d{2}:\d{2}|\d{6}\s+\d{1,2}:\d{2}:\d{2})\s+(?:(\d+)\s+)?\[(\w+)\](?:\s+\[(MY-\d+)\])?(?:\s+\[(\w+)\])?\s*(.*)$`)
+
+// subsystemRegex matches the subsystem prefix of MySQL 5.7 messages
+var subsystemRegex = regexp.MustCompile(`^(InnoDB|Plugin|Replication|Repl|Server|Slave|NDB|X Plugin|mysqld): (.*)$`)
+
+// MySQLErrorFormatter parses the MySQL error log. Lines which don't start an
+// entry, like the frames of a crash stack trace, are grouped into the Trace
+// of the previous entry.
+type MySQLErrorFormatter struct {
+	// Redactor removes sensitive data, the default rules when nil
+	Redactor *Redactor
+}
+
+func (f *MySQLErrorFormatter) Format(log string) []string {
+	var events []string
+	var entry *ErrorLogData
+
+	emit := func() {
+		if entry == nil {
+			return
+		}
+		entry.Message = f.Redactor.redact(entry.Message, dialectMySQL)
+		if jsonData, err := json.Marshal(entry); err == nil {
+			events = append(events, string(jsonData))
+		}
+		entry = nil
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		match := errorLogRegex.FindStringSubmatch(line)
+		if match == nil {
+			if entry != nil && strings.TrimSpace(line) != "" {
+				if entry.Trace != "" {
+					entry.Trace += "\n"
+				}
+				entry.Trace += line
+			}
+			continue
+		}
+
+		emit()
+		entry = &ErrorLogData{
+			EventType: EventTypeError,
+			Severity:  match[3],
+			ErrorCode: match[4],
+			Subsystem: match[5],
+			Message:   match[6],
+		}
+		entry.Time, entry.Timestamp = mysqlTime(match[1])
+		entry.Thread, _ = strconv.ParseInt(match[2], 10, 64)
+		if entry.Subsystem == "" {
+			if sub := subsystemRegex.FindStringSubmatch(entry.Message); sub != nil {
+				entry.Subsystem, entry.Message = sub[1], sub[2]
+			}
+		}
+	}
+	emit()
+
+	return events
+}
+
+// mysqlTime converts the timestamps of the MySQL error and general logs to
+// RFC3339 and seconds since the epoch. Timestamps without a zone are UTC, as
+// on RDS.
+func mysqlTime(value string) (string, int64) {
+	value = strings.Join(strings.Fields(value), " ")
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "060102 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Format(time.RFC3339Nano), t.Unix()
+		}
+	}
+	return value, 0
+}
@@ -0,0 +1,104 @@
+package formatter
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GeneralLogData is one entry of general/mysql-general.log
+type GeneralLogData struct {
+	EventType    string
+	Time         string
+	Timestamp    int64
+	ConnectionId int64
+	Command      string
+	Argument     string
+	// User, Host and DatabaseName of Connect entries
+	User         string `json:",omitempty"`
+	Host         string `json:",omitempty"`
+	DatabaseName string `json:",omitempty"`
+	// Query is the statement of Query and Execute entries
+	Query string `json:",omitempty"`
+	// Fingerprint is the shape of Query, Digest its hash
+	Fingerprint string `json:",omitempty"`
+	Digest      string `json:",omitempty"`
+}
+
+// generalLogRegex matches the first line of a general log entry, e.g.
+// "2022-09-01T08:00:01.123456Z\t   12 Query\tSELECT 1". MySQL 5.6 only writes
+// the time when it changed ("220901  8:00:01\t   12 Query\t...").
+var generalLogRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\S+|\d{6}\s+\d{1,2}:\d{2}:\d{2})?\s+(\d+) ([A-Z][a-z]*(?: [A-Za-z]+)?)\t(.*)$`)
+
+// connectRegex matches the argument of Connect entries, e.g.
+// "app@10.0.1.7 on orders using TCP/IP"
+var connectRegex = regexp.MustCompile(`^(\S*)@(\S*) on (\S*)`)
+
+// MySQLGeneralFormatter parses the MySQL general query log. Continuation
+// lines of multi-line statements are added to the argument of their entry.
+type MySQLGeneralFormatter struct {
+	// Redactor removes sensitive data, the default rules when nil
+	Redactor *Redactor
+}
+
+func (f *MySQLGeneralFormatter) Format(log string) []string {
+	var events []string
+	var entry *GeneralLogData
+	var lastTime string
+	var lastTimestamp int64
+
+	emit := func() {
+		if entry == nil {
+			return
+		}
+		if entry.Command == "Query" || entry.Command == "Execute" {
+			entry.Fingerprint, entry.Digest = queryDigest(entry.Argument, dialectMySQL)
+			entry.Query = f.Redactor.redact(entry.Argument, dialectMySQL)
+		}
+		entry.Argument = f.Redactor.redact(entry.Argument, dialectMySQL)
+		if jsonData, err := json.Marshal(entry); err == nil {
+			events = append(events, string(jsonData))
+		}
+		entry = nil
+	}
+
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		match := generalLogRegex.FindStringSubmatch(line)
+		if match == nil {
+			// skip the header lines written on startup
+			if entry != nil && line != "" && !isGeneralLogHeader(line) {
+				entry.Argument += "\n" + line
+			}
+			continue
+		}
+
+		emit()
+		if match[1] != "" {
+			lastTime, lastTimestamp = mysqlTime(match[1])
+		}
+		entry = &GeneralLogData{
+			EventType: EventTypeGeneral,
+			Time:      lastTime,
+			Timestamp: lastTimestamp,
+			Command:   match[3],
+			Argument:  match[4],
+		}
+		entry.ConnectionId, _ = strconv.ParseInt(match[2], 10, 64)
+		if entry.Command == "Connect" {
+			if conn := connectRegex.FindStringSubmatch(entry.Argument); conn != nil {
+				entry.User, entry.Host, entry.DatabaseName = conn[1], conn[2], conn[3]
+			}
+		}
+	}
+	emit()
+
+	return events
+}
+
+func isGeneralLogHeader(line string) bool {
+	return strings.Contains(line, ", Version: ") ||
+		strings.HasPrefix(line, "Tcp port: ") ||
+		strings.HasPrefix(line, "Time ")
+}
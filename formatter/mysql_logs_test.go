@@ -0,0 +1,87 @@
+package formatter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMySQLErrorFormatter(t *testing.T) {
+	log := `2022-09-01T08:00:01.123456Z 12 [Warning] [MY-010055] [Server] IP address '10.0.1.7' could not be resolved: Name or service not known
+2022-09-01T08:00:02.000000Z 0 [Note] InnoDB: Buffer pool(s) load completed at 220901  8:00:02
+2022-09-01  8:00:03 140123 [ERROR] mysqld got signal 11 ;
+stack_bottom = 0x7f0 thread_stack 0x49000
+/rdsdbbin/mysql/bin/mysqld(my_print_stacktrace+0x2c)[0x5600]
+220901  8:00:04 [Note] Server socket created on IP: '::'.
+`
+	var entries []ErrorLogData
+	for _, event := range (&MySQLErrorFormatter{}).Format(log) {
+		var data ErrorLogData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatalf("invalid event %s: %s", event, err)
+		}
+		entries = append(entries, data)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d: %+v", len(entries), entries)
+	}
+
+	mysql8 := entries[0]
+	if mysql8.EventType != EventTypeError || mysql8.Time != "2022-09-01T08:00:01.123456Z" || mysql8.Thread != 12 ||
+		mysql8.Severity != "Warning" || mysql8.ErrorCode != "MY-010055" || mysql8.Subsystem != "Server" ||
+		!strings.HasPrefix(mysql8.Message, "IP address '?' could not be resolved") {
+		t.Errorf("unexpected MySQL 8 entry: %+v", mysql8)
+	}
+	if entries[1].Subsystem != "InnoDB" || entries[1].Message != "Buffer pool(s) load completed at 220901  8:00:02" {
+		t.Errorf("unexpected MySQL 5.7 entry: %+v", entries[1])
+	}
+
+	crash := entries[2]
+	if crash.Severity != "ERROR" || crash.Thread != 140123 || crash.Timestamp != 1662019203 ||
+		crash.Trace != "stack_bottom = 0x7f0 thread_stack 0x49000\n/rdsdbbin/mysql/bin/mysqld(my_print_stacktrace+0x2c)[0x5600]" {
+		t.Errorf("unexpected crash entry: %+v", crash)
+	}
+	if entries[3].Time != "2022-09-01T08:00:04Z" || entries[3].Thread != 0 {
+		t.Errorf("unexpected MariaDB entry: %+v", entries[3])
+	}
+}
+
+func TestMySQLGeneralFormatter(t *testing.T) {
+	log := `/rdsdbbin/mysql/bin/mysqld, Version: 8.0.28 (Source distribution). started with:
+Tcp port: 3306  Unix socket: /tmp/mysql.sock
+Time                 Id Command    Argument
+2022-09-01T08:00:01.123456Z	   12 Connect	app@10.0.1.7 on orders using TCP/IP
+2022-09-01T08:00:01.223456Z	   12 Query	SELECT *
+FROM users
+WHERE phone = '9876543210'
+2022-09-01T08:00:02.000000Z	   12 Init DB	orders
+		   12 Quit	
+`
+	var entries []GeneralLogData
+	for _, event := range (&MySQLGeneralFormatter{}).Format(log) {
+		var data GeneralLogData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatalf("invalid event %s: %s", event, err)
+		}
+		entries = append(entries, data)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d: %+v", len(entries), entries)
+	}
+
+	connect := entries[0]
+	if connect.EventType != EventTypeGeneral || connect.ConnectionId != 12 || connect.Command != "Connect" ||
+		connect.User != "app" || connect.DatabaseName != "orders" {
+		t.Errorf("unexpected connect entry: %+v", connect)
+	}
+	if entries[1].Query != "SELECT *\nFROM users\nWHERE phone = '?'" {
+		t.Errorf("unexpected query: %q", entries[1].Query)
+	}
+	if entries[2].Command != "Init DB" || entries[2].Argument != "orders" {
+		t.Errorf("unexpected init db entry: %+v", entries[2])
+	}
+	// the time is only written when it changed
+	if entries[3].Command != "Quit" || entries[3].Time != "2022-09-01T08:00:02Z" {
+		t.Errorf("unexpected quit entry: %+v", entries[3])
+	}
+}
@@ -0,0 +1,197 @@
+package formatter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const slowQueryLog = `# Time: 2022-09-01T08:00:01.123456Z
+# User@Host: app[app] @  [10.0.1.7]  Id:    12
+# Query_time: 1.500000  Lock_time: 0.000100 Rows_sent: 1  Rows_examined: 5000
+use orders;
+SET timestamp=1662019201;
+SELECT * FROM orders WHERE id = 7;
+# Time: 2022-09-01T08:00:02.000000Z
+# User@Host: app[app] @  [10.0.1.7]  Id:    13
+# Query_time: 2.000000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 9000
+SET timestamp=1662019202;
+DELETE FROM sessions WHERE expired = 1;
+# User@Host: batch[batch] @  [10.0.1.8]  Id:    14
+# Query_time: 3.250000  Lock_time: 0.000000 Rows_sent: 10  Rows_examined: 10
+SET timestamp=1662019202;
+SELECT id FROM jobs;
+`
+
+func formatAll(f *MySQLFormatter, chunks ...string) []string {
+	var events []string
+	for _, chunk := range chunks {
+		events = append(events, f.Format(chunk)...)
+	}
+	return append(events, f.Flush()...)
+}
+
+func TestMySQLFormatterEntries(t *testing.T) {
+	events := formatAll(&MySQLFormatter{}, slowQueryLog)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(events), events)
+	}
+
+	expected := []JsonData{
+		{
+			Time: "2022-09-01T08:00:01.123456Z", User: "app", Host: "10.0.1.7", ConnectionId: 12,
+			QueryTime: 1.5, LockTime: 0.0001, RowsSent: 1, RowsExamined: 5000,
+			DatabaseName: "orders", Timestamp: 1662019201, Query: "SELECT * FROM orders WHERE id = 7;",
+			Fingerprint: "select * from orders where id = ?",
+		},
+		{
+			Time: "2022-09-01T08:00:02.000000Z", User: "app", Host: "10.0.1.7", ConnectionId: 13,
+			QueryTime: 2, RowsExamined: 9000,
+			DatabaseName: "orders", Timestamp: 1662019202, Query: "DELETE FROM sessions WHERE expired = 1;",
+			Fingerprint: "delete from sessions where expired = ?",
+		},
+		{
+			// the time is only logged when it changed
+			Time: "2022-09-01T08:00:02.000000Z", User: "batch", Host: "10.0.1.8", ConnectionId: 14,
+			QueryTime: 3.25, RowsSent: 10, RowsExamined: 10,
+			DatabaseName: "orders", Timestamp: 1662019202, Query: "SELECT id FROM jobs;",
+			Fingerprint: "select id from jobs",
+		},
+	}
+	for i, event := range events {
+		var data JsonData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Digest != digest(expected[i].Fingerprint) {
+			t.Errorf("event %d: expected the digest of the fingerprint, got %q", i, data.Digest)
+		}
+		data.Digest = ""
+		if data != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], data)
+		}
+	}
+}
+
+const slowQueryLogExtra = `# Time: 2022-09-01T08:00:01.123456Z
+# User@Host: app[app] @ app-1.internal [10.0.1.7]  Id:    12
+# Query_time: 1.500000  Lock_time: 0.000100 Rows_sent: 0  Rows_examined: 5000 Thread_id: 12 Errno: 1213 Killed: 0 Bytes_received: 120 Bytes_sent: 65 Read_first: 0 Rows_affected: 3
+# Created_tmp_disk_tables: 1 Created_tmp_tables: 2 Start: 2022-09-01T08:00:00.623456Z End: 2022-09-01T08:00:02.123456Z
+use orders;
+SET timestamp=1662019201;
+UPDATE orders
+  SET state = 'done'
+  WHERE id IN (
+    SELECT id FROM jobs
+  );
+# Time: 2022-09-01T08:00:03.000000Z
+# User@Host: batch[batch] @  [10.0.1.8]
+# Schema: reports  Last_errno: 0  Killed: 0
+# Query_time: 0.200000  Lock_time: 0.000000  Rows_sent: 5  Rows_examined: 80000  Rows_affected: 0
+# Full_scan: Yes  Full_join: No  Tmp_table: Yes  Tmp_table_on_disk: No
+# Filesort: Yes  Filesort_on_disk: No  Merge_passes: 2
+SET timestamp=1662019203;
+SELECT name
+FROM reports
+ORDER BY name;
+# Time: 2022-09-01T08:00:04.000000Z
+# User@Host: admin[admin] @ localhost []  Id:    15
+# Query_time: 0.100000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1662019204;
+# administrator command: Quit;
+`
+
+func TestMySQLFormatterMultiLineEntries(t *testing.T) {
+	events := formatAll(&MySQLFormatter{}, slowQueryLogExtra)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %v", len(events), events)
+	}
+
+	expected := []JsonData{
+		{
+			Time: "2022-09-01T08:00:01.123456Z", User: "app", Host: "10.0.1.7", ConnectionId: 12,
+			QueryTime: 1.5, LockTime: 0.0001, RowsExamined: 5000,
+			DatabaseName: "orders", Timestamp: 1662019201,
+			Query:       "UPDATE orders\n  SET state = 'done'\n  WHERE id IN (\n    SELECT id FROM jobs\n  );",
+			Fingerprint: "update orders set state = ? where id in(select id from jobs)",
+			ThreadId:    12, Errno: 1213, RowsAffected: 3, BytesReceived: 120, BytesSent: 65,
+			TmpTables: 2, TmpDiskTables: 1,
+		},
+		{
+			Time: "2022-09-01T08:00:03.000000Z", User: "batch", Host: "10.0.1.8",
+			QueryTime: 0.2, RowsSent: 5, RowsExamined: 80000,
+			DatabaseName: "reports", Timestamp: 1662019203, Query: "SELECT name\nFROM reports\nORDER BY name;",
+			Fingerprint: "select name from reports order by name",
+			FullScan:    true, Filesort: true, MergePasses: 2,
+		},
+		{
+			Time: "2022-09-01T08:00:04.000000Z", User: "admin", Host: "localhost", ConnectionId: 15,
+			QueryTime: 0.1, DatabaseName: "orders", Timestamp: 1662019204, Query: "administrator command: Quit;",
+			Fingerprint: "administrator command : quit",
+		},
+	}
+	for i, event := range events {
+		var data JsonData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.Digest != digest(expected[i].Fingerprint) {
+			t.Errorf("event %d: expected the digest of the fingerprint, got %q", i, data.Digest)
+		}
+		data.Digest = ""
+		if data != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], data)
+		}
+	}
+
+	// the extra fields are left out when not logged
+	if strings.Contains(formatAll(&MySQLFormatter{}, slowQueryLog)[0], "ThreadId") {
+		t.Error("expected no extra fields without log_slow_extra")
+	}
+}
+
+// TestMySQLFormatterSplitEntries feeds the log in two chunks split at every
+// byte, and in one chunk per line, expecting the events of the whole log.
+func TestMySQLFormatterSplitEntries(t *testing.T) {
+	expected := formatAll(&MySQLFormatter{}, slowQueryLog)
+
+	for i := 0; i <= len(slowQueryLog); i++ {
+		got := formatAll(&MySQLFormatter{}, slowQueryLog[:i], slowQueryLog[i:])
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("split at %d (%q): expected %v, got %v", i, slowQueryLog[:i], expected, got)
+		}
+	}
+
+	lines := strings.SplitAfter(slowQueryLog, "\n")
+	if got := formatAll(&MySQLFormatter{}, lines...); !reflect.DeepEqual(got, expected) {
+		t.Errorf("line by line: expected %v, got %v", expected, got)
+	}
+}
+
+func TestMySQLFormatterKeepsIncompleteEntry(t *testing.T) {
+	f := &MySQLFormatter{}
+	second := strings.Index(slowQueryLog, "# Time: 2022-09-01T08:00:02")
+	split := strings.Index(slowQueryLog, "# User@Host: app[app] @  [10.0.1.7]  Id:    13") + 10
+
+	events := f.Format(slowQueryLog[:split])
+	if len(events) != 1 {
+		t.Fatalf("expected only the complete entry, got %v", events)
+	}
+	if f.Pending() != split-second {
+		t.Errorf("expected the %d bytes of the incomplete entry to be pending, got %d", split-second, f.Pending())
+	}
+
+	events = f.Format(slowQueryLog[split:])
+	if len(events) != 1 || !strings.Contains(events[0], "DELETE FROM sessions") {
+		t.Errorf("expected the second entry once the third started, got %v", events)
+	}
+	if pending := len(slowQueryLog) - strings.Index(slowQueryLog, "# User@Host: batch"); f.Pending() != pending {
+		t.Errorf("expected %d pending bytes, got %d", pending, f.Pending())
+	}
+
+	events = f.Flush()
+	if len(events) != 1 || !strings.Contains(events[0], "SELECT id FROM jobs") || f.Pending() != 0 {
+		t.Errorf("expected Flush to return the last entry, got %v", events)
+	}
+}
@@ -0,0 +1,68 @@
+package formatter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const pgauditPrefix = "AUDIT: "
+
+// parseAudit fills the audit fields of p from a pgaudit message like
+// "AUDIT: SESSION,1,1,READ,SELECT,TABLE,public.account,select * from account,<not logged>"
+// and reports whether p is an audit event.
+func (p *PostgresData) parseAudit() bool {
+	if p.Severity != "LOG" || !strings.HasPrefix(p.Message, pgauditPrefix) {
+		return false
+	}
+
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(p.Message, pgauditPrefix)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	fields, err := reader.Read()
+	if err != nil || len(fields) < 8 || (fields[0] != "SESSION" && fields[0] != "OBJECT") {
+		return false
+	}
+
+	p.EventType = EventTypeAudit
+	p.AuditType = fields[0]
+	p.StatementID, _ = strconv.ParseInt(fields[1], 10, 64)
+	p.SubstatementID, _ = strconv.ParseInt(fields[2], 10, 64)
+	p.AuditClass = fields[3]
+	p.Command = fields[4]
+	p.ObjectType = fields[5]
+	p.ObjectName = fields[6]
+	p.Query = fields[7]
+	if len(fields) > 8 && fields[8] != "<not logged>" {
+		p.AuditParameters = strings.Join(fields[8:], ",")
+	}
+	p.ConnectionId = p.Pid
+	p.auditFields = fields
+	return true
+}
+
+// redactAudit redacts the statement and parameters of the audit event p and
+// rebuilds its message from them. The parameters are redacted by the
+// columns their placeholders are compared against in query.
+func (p *PostgresData) redactAudit(r *Redactor, query string) {
+	fields := append([]string(nil), p.auditFields...)
+	fields[7] = p.Query
+	if p.AuditParameters != "" {
+		parameters := make(map[string]string, len(fields)-8)
+		for i, value := range fields[8:] {
+			parameters[fmt.Sprintf("$%d", i+1)] = value
+		}
+		parameters = r.redactParameters(query, parameters, dialectPostgres)
+		for i := range fields[8:] {
+			fields[8+i] = parameters[fmt.Sprintf("$%d", i+1)]
+		}
+		p.AuditParameters = strings.Join(fields[8:], ",")
+	}
+
+	var message strings.Builder
+	writer := csv.NewWriter(&message)
+	writer.Write(fields)
+	writer.Flush()
+	p.Message = pgauditPrefix + strings.TrimSuffix(message.String(), "\n")
+}
@@ -1,14 +1,433 @@
 package formatter
 
 import (
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type PostgresFormatter struct {}
+// DefaultLogLinePrefix is the log_line_prefix of RDS PostgreSQL
+const DefaultLogLinePrefix = "%t:%r:%u@%d:[%p]:"
+
+// PostgresData is one entry of a PostgreSQL log. Fields of log_line_prefix
+// escapes which aren't in the prefix are left out. Statements and durations
+// are query events, which also carry the JsonData fields of MySQL slow query
+// events, and pgaudit entries are audit events.
+type PostgresData struct {
+	EventType     string
+	Time          string
+	Host          string `json:",omitempty"`
+	Port          int64  `json:",omitempty"`
+	User          string `json:",omitempty"`
+	DatabaseName  string `json:",omitempty"`
+	Application   string `json:",omitempty"`
+	Pid           int64  `json:",omitempty"`
+	SessionID     string `json:",omitempty"`
+	LineNumber    int64  `json:",omitempty"`
+	SQLState      string `json:",omitempty"`
+	TransactionID string `json:",omitempty"`
+	CommandTag    string `json:",omitempty"`
+	BackendType   string `json:",omitempty"`
+	Severity      string
+	Message       string
+	Detail        string `json:",omitempty"`
+	Hint          string `json:",omitempty"`
+	Context       string `json:",omitempty"`
+	Statement     string `json:",omitempty"`
+	InternalQuery string `json:",omitempty"`
+	Location      string `json:",omitempty"`
+
+	DurationMs        float64           `json:",omitempty"`
+	StatementType     string            `json:",omitempty"`
+	PreparedStatement string            `json:",omitempty"`
+	Parameters        map[string]string `json:",omitempty"`
+	QueryTime         float64           `json:",omitempty"`
+	ConnectionId      int64             `json:",omitempty"`
+	Timestamp         int64             `json:",omitempty"`
+	Query             string            `json:",omitempty"`
+	Plan              *PlanSummary      `json:",omitempty"`
+	Fingerprint       string            `json:",omitempty"`
+	Digest            string            `json:",omitempty"`
+
+	AuditType       string `json:",omitempty"`
+	StatementID     int64  `json:",omitempty"`
+	SubstatementID  int64  `json:",omitempty"`
+	AuditClass      string `json:",omitempty"`
+	Command         string `json:",omitempty"`
+	ObjectType      string `json:",omitempty"`
+	ObjectName      string `json:",omitempty"`
+	AuditParameters string `json:",omitempty"`
+
+	// auditFields are the fields of a pgaudit message
+	auditFields []string
+}
+
+const pgTimestamp = `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`
+const pgZone = `(?: [A-Za-z]+| ?[+-]\d{2}(?::?\d{2})?)?`
+
+// prefixEscapes maps the log_line_prefix escapes to the regex matching them
+var prefixEscapes = map[byte]string{
+	't': `(?P<time>` + pgTimestamp + pgZone + `)`,
+	'm': `(?P<time>` + pgTimestamp + `\.\d{3}` + pgZone + `)`,
+	'n': `(?P<epoch>\d+\.\d+)`,
+	'r': `(?P<host>[^()\s]*?)(?:\((?P<port>\d+)\))?`,
+	'h': `(?P<host>[^()\s]*?)`,
+	'u': `(?P<user>.*?)`,
+	'd': `(?P<database>.*?)`,
+	'a': `(?P<application>.*?)`,
+	'p': `(?P<pid>\d*)`,
+	'c': `(?P<session>[0-9a-f]*\.?[0-9a-f]*)`,
+	'l': `(?P<line>\d*)`,
+	'e': `(?P<sqlstate>[0-9A-Z]{5}|)`,
+	'x': `(?P<txid>\d*)`,
+	'v': `(?:\d+/\d+|)`,
+	's': `(?:` + pgTimestamp + pgZone + `)`,
+	'i': `(?P<command>.*?)`,
+	'b': `(?P<backend>.*?)`,
+	'Q': `(?:-?\d*)`,
+}
+
+const pgSeverities = `DEBUG[1-5]|INFO|NOTICE|WARNING|ERROR|LOG|FATAL|PANIC|DETAIL|HINT|CONTEXT|STATEMENT|QUERY|LOCATION`
+
+var (
+	prefixCacheMu sync.Mutex
+	prefixCache   = map[string]*regexp.Regexp{}
+)
+
+// CompileLogLinePrefix returns the regex matching the first line of a log
+// entry written with the log_line_prefix prefix
+func CompileLogLinePrefix(prefix string) (*regexp.Regexp, error) {
+	prefixCacheMu.Lock()
+	defer prefixCacheMu.Unlock()
+	if re, ok := prefixCache[prefix]; ok {
+		return re, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	optionalRest := false
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '%' {
+			expr.WriteString(regexp.QuoteMeta(prefix[i : i+1]))
+			continue
+		}
+
+		// skip the padding of escapes like %-10u
+		padded := false
+		for i+1 < len(prefix) && (prefix[i+1] == '-' || (prefix[i+1] >= '0' && prefix[i+1] <= '9')) {
+			i++
+			padded = true
+		}
+		if i+1 >= len(prefix) {
+			return nil, fmt.Errorf("log_line_prefix %q ends in an incomplete escape", prefix)
+		}
+		i++
+
+		switch escape := prefix[i]; escape {
+		case '%':
+			expr.WriteString("%")
+		case 'q':
+			// the rest is only written by session processes
+			expr.WriteString("(?:")
+			optionalRest = true
+		default:
+			field, ok := prefixEscapes[escape]
+			if !ok {
+				return nil, fmt.Errorf("unsupported log_line_prefix escape %%%c in %q", escape, prefix)
+			}
+			if padded {
+				field = ` *` + field + ` *`
+			}
+			expr.WriteString(field)
+		}
+	}
+	if optionalRest {
+		expr.WriteString(")?")
+	}
+	expr.WriteString(`(?P<severity>` + pgSeverities + `):\s+(?P<message>.*)$`)
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid log_line_prefix %q: %s", prefix, err)
+	}
+	prefixCache[prefix] = re
+	return re, nil
+}
+
+// PostgresFormatter parses PostgreSQL logs written with the log_line_prefix
+// Prefix (DefaultLogLinePrefix when empty) into one PostgresData event per
+// entry. DETAIL, HINT, CONTEXT, STATEMENT, QUERY and LOCATION lines and
+// tab-indented continuation lines are attached to their entry, and the plans
+// of auto_explain are summarized. It keeps the entry being read and an
+// unterminated last line between calls of Format, so entries split across
+// the chunks of a log file are formatted once complete. An entry is
+// complete when the next one starts; Flush formats the last one.
+type PostgresFormatter struct {
+	Prefix string
+	// SeqScanRows is the number of rows from which sequential scans of
+	// auto_explain plans are reported, DefaultSeqScanRows when 0
+	SeqScanRows int64
+	// Redactor removes sensitive data, the default rules when nil
+	Redactor *Redactor
+
+	// partial is the unterminated last line of the previous chunk
+	partial string
+	// entry is the entry being read, entrySize the size of its lines in the
+	// log and field the field its continuation lines are appended to
+	entry     *PostgresData
+	entrySize int
+	field     *string
+}
 
 func (f *PostgresFormatter) Format(log string) []string {
-	var str []string
-	str = append(str, removeSensitiveData(fmt.Sprintf("DATA: %s", log)))
+	prefix := f.Prefix
+	if prefix == "" {
+		prefix = DefaultLogLinePrefix
+	}
+	re, err := CompileLogLinePrefix(prefix)
+	if err != nil {
+		return []string{f.Redactor.redact(fmt.Sprintf("DATA: %s", log), dialectPostgres)}
+	}
+
+	var events []string
+	log = f.partial + log
+	f.partial = ""
+	lines := strings.SplitAfter(log, "\n")
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, "\n") {
+		f.partial = last
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, raw := range lines {
+		line := strings.TrimRight(raw, "\r\n")
+		match := re.FindStringSubmatch(line)
+		if match == nil || f.entry != nil && f.entry.attachment(match[re.SubexpIndex("severity")]) != nil {
+			// lines without an entry are the tail of one before the
+			// stream started
+			if f.entry == nil {
+				continue
+			}
+			f.entrySize += len(raw)
+			if match != nil {
+				// a field attached to the entry
+				f.field = f.entry.attachment(match[re.SubexpIndex("severity")])
+				*f.field = match[re.SubexpIndex("message")]
+			} else if strings.TrimSpace(line) != "" && f.field != nil {
+				// continuation of the message or the last attached field
+				*f.field += "\n" + strings.TrimPrefix(line, "\t")
+			}
+			continue
+		}
+
+		events = f.emit(events)
+		f.entry = newPostgresData(re, match)
+		f.entrySize = len(raw)
+		f.field = &f.entry.Message
+	}
+
+	return events
+}
+
+// Flush formats the entry being read, e.g. when the log file rotated or no
+// new data arrived for a while, and resets the state
+func (f *PostgresFormatter) Flush() []string {
+	var events []string
+	if f.partial != "" {
+		events = f.Format("\n")
+	}
+	events = f.emit(events)
+	f.partial = ""
+	return events
+}
+
+// Pending returns the number of bytes of the log kept for the next call
+func (f *PostgresFormatter) Pending() int {
+	return f.entrySize + len(f.partial)
+}
+
+// emit appends the event of the entry being read to events and drops the
+// entry
+func (f *PostgresFormatter) emit(events []string) []string {
+	entry := f.entry
+	f.entry = nil
+	f.entrySize = 0
+	f.field = nil
+	if entry == nil {
+		return events
+	}
+
+	seqScanRows := f.SeqScanRows
+	if seqScanRows <= 0 {
+		seqScanRows = DefaultSeqScanRows
+	}
+	if !entry.parseAudit() {
+		entry.parseStatement(seqScanRows)
+	}
+	entry.Fingerprint, entry.Digest = queryDigest(entry.Query, dialectPostgres)
+	if jsonData, err := json.Marshal(entry.redacted(f.Redactor)); err == nil {
+		events = append(events, string(jsonData))
+	}
+	return events
+}
+
+// newPostgresData returns the entry of the prefix match of its first line
+func newPostgresData(re *regexp.Regexp, match []string) *PostgresData {
+	data := &PostgresData{}
+	for i, name := range re.SubexpNames() {
+		value := strings.TrimSpace(match[i])
+		if value == "" {
+			continue
+		}
+		switch name {
+		case "time":
+			data.Time = postgresTime(value)
+		case "epoch":
+			if epoch, err := strconv.ParseFloat(value, 64); err == nil {
+				data.Time = time.Unix(0, int64(epoch*float64(time.Second))).UTC().Format(time.RFC3339Nano)
+			}
+		case "host":
+			data.Host = value
+		case "port":
+			data.Port, _ = strconv.ParseInt(value, 10, 64)
+		case "user":
+			data.User = value
+		case "database":
+			data.DatabaseName = value
+		case "application":
+			data.Application = value
+		case "pid":
+			data.Pid, _ = strconv.ParseInt(value, 10, 64)
+		case "session":
+			data.SessionID = value
+		case "line":
+			data.LineNumber, _ = strconv.ParseInt(value, 10, 64)
+		case "sqlstate":
+			data.SQLState = value
+		case "txid":
+			data.TransactionID = value
+		case "command":
+			data.CommandTag = value
+		case "backend":
+			data.BackendType = value
+		case "severity":
+			data.Severity = value
+		case "message":
+			data.Message = value
+		}
+	}
+	return data
+}
+
+// attachment returns the field receiving a line of severity which belongs
+// to the previous entry, or nil if the line starts a new entry
+func (p *PostgresData) attachment(severity string) *string {
+	switch severity {
+	case "DETAIL":
+		return &p.Detail
+	case "HINT":
+		return &p.Hint
+	case "CONTEXT":
+		return &p.Context
+	case "STATEMENT":
+		return &p.Statement
+	case "QUERY":
+		return &p.InternalQuery
+	case "LOCATION":
+		return &p.Location
+	}
+	return nil
+}
+
+// redacted returns a copy of p with the sensitive data of its free text
+// fields removed by r. Bound parameters are redacted by the columns their
+// placeholders are compared against in the query.
+func (p *PostgresData) redacted(r *Redactor) PostgresData {
+	data := *p
+	for _, field := range []*string{&data.Message, &data.Detail, &data.Context, &data.Statement, &data.InternalQuery, &data.Query} {
+		if *field != "" {
+			*field = r.redact(*field, dialectPostgres)
+		}
+	}
+	if data.Parameters != nil {
+		data.Parameters = r.redactParameters(p.Query, p.Parameters, dialectPostgres)
+		if strings.HasPrefix(p.Detail, "parameters: ") {
+			data.Detail = parametersDetail(data.Parameters)
+		}
+	}
+	if data.auditFields != nil {
+		data.redactAudit(r, p.Query)
+	}
+	return data
+}
+
+// pgZoneOffsets are the offsets in seconds of the zone abbreviations printed
+// by log_timezone settings. Ambiguous abbreviations such as IST, CST or BST
+// are left out, timestamps in those zones are kept as they are
+var pgZoneOffsets = map[string]int{
+	"UTC": 0, "UCT": 0, "GMT": 0, "Z": 0,
+	"WET": 0, "WEST": 3600, "CET": 3600, "CEST": 7200, "EET": 7200, "EEST": 10800, "MSK": 10800,
+	"HKT": 28800, "AWST": 28800, "JST": 32400, "KST": 32400,
+	"ACST": 34200, "ACDT": 37800, "AEST": 36000, "AEDT": 39600, "NZST": 43200, "NZDT": 46800,
+	"EST": -18000, "EDT": -14400, "MST": -25200, "MDT": -21600, "PST": -28800, "PDT": -25200,
+	"AKST": -32400, "AKDT": -28800, "HST": -36000,
+}
+
+// postgresTime converts a %t or %m timestamp to RFC3339. Timestamps without
+// a zone are taken as UTC, those of unknown or ambiguous zone abbreviations
+// are kept as they are
+func postgresTime(value string) string {
+	n := len("2006-01-02 15:04:05")
+	if len(value) < n {
+		return value
+	}
+	if strings.HasPrefix(value[n:], ".") {
+		n += len(".000")
+		if len(value) < n {
+			return value
+		}
+	}
+	offset, ok := pgZoneOffset(strings.TrimSpace(value[n:]))
+	if !ok {
+		return value
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value[:n], time.FixedZone("", offset))
+	if err != nil {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
 
-	return str
+// pgZoneOffset returns the offset in seconds of a zone abbreviation or a
+// numeric offset such as +05, +0530 or -03:30
+func pgZoneOffset(zone string) (int, bool) {
+	if zone == "" {
+		return 0, true
+	}
+	if zone[0] != '+' && zone[0] != '-' {
+		offset, ok := pgZoneOffsets[zone]
+		return offset, ok
+	}
+	digits := strings.Replace(zone[1:], ":", "", 1)
+	if len(digits) != 2 && len(digits) != 4 {
+		return 0, false
+	}
+	hours, err := strconv.Atoi(digits[:2])
+	if err != nil {
+		return 0, false
+	}
+	minutes := 0
+	if len(digits) == 4 {
+		if minutes, err = strconv.Atoi(digits[2:]); err != nil {
+			return 0, false
+		}
+	}
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
 }
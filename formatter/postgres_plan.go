@@ -0,0 +1,172 @@
+package formatter
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultSeqScanRows is the number of rows from which a sequential scan is
+// reported in PlanSummary.SeqScans
+const DefaultSeqScanRows = 10000
+
+// maxPlanNodeTypes limits PlanSummary.NodeTypes
+const maxPlanNodeTypes = 10
+
+// PlanSummary summarizes an auto_explain plan
+type PlanSummary struct {
+	Format     string
+	TotalCost  float64
+	ActualTime float64 `json:",omitempty"`
+	Rows       int64
+	// NodeTypes are the distinct node types from the top of the plan down
+	NodeTypes []string
+	// SeqScans are the relations read by sequential scans of many rows
+	SeqScans []string `json:",omitempty"`
+}
+
+// planNode is a node of an EXPLAIN plan in JSON format. Nodes parsed from
+// text plans fill the same fields.
+type planNode struct {
+	NodeType            string     `json:"Node Type"`
+	RelationName        string     `json:"Relation Name"`
+	TotalCost           float64    `json:"Total Cost"`
+	PlanRows            float64    `json:"Plan Rows"`
+	ActualTotalTime     float64    `json:"Actual Total Time"`
+	ActualRows          float64    `json:"Actual Rows"`
+	ActualLoops         float64    `json:"Actual Loops"`
+	RowsRemovedByFilter float64    `json:"Rows Removed by Filter"`
+	Plans               []planNode `json:"Plans"`
+}
+
+// textPlanNodeRegex matches a node line of a text plan, e.g.
+// "->  Seq Scan on orders  (cost=0.00..35.50 rows=2550 width=4) (actual time=0.01..0.30 rows=2550 loops=1)"
+var textPlanNodeRegex = regexp.MustCompile(`^\s*(?:->\s+)?([A-Z][^(]*?)\s+\(cost=[\d.]+\.\.([\d.]+) rows=(\d+) width=\d+\)(?:\s+\(actual time=[\d.]+\.\.([\d.]+) rows=(\d+) loops=(\d+)\))?`)
+
+var rowsRemovedRegex = regexp.MustCompile(`^\s*Rows Removed by Filter: (\d+)`)
+
+// parsePlan parses the body of an auto_explain message in JSON or text
+// format. It returns the query text of the plan and its summary, or nil if
+// the body isn't a plan.
+func parsePlan(body string, seqScanRows int64) (string, *PlanSummary) {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "{") {
+		var plan struct {
+			QueryText string   `json:"Query Text"`
+			Plan      planNode `json:"Plan"`
+		}
+		if err := json.Unmarshal([]byte(body), &plan); err != nil || plan.Plan.NodeType == "" {
+			return "", nil
+		}
+		summary := summarizePlan(flattenPlan(plan.Plan, nil), seqScanRows)
+		summary.Format = "json"
+		return plan.QueryText, summary
+	}
+
+	query, nodes := parseTextPlan(body)
+	if len(nodes) == 0 {
+		return "", nil
+	}
+	summary := summarizePlan(nodes, seqScanRows)
+	summary.Format = "text"
+	return query, summary
+}
+
+// flattenPlan appends node and its children to nodes, depth first
+func flattenPlan(node planNode, nodes []planNode) []planNode {
+	nodes = append(nodes, node)
+	for _, child := range node.Plans {
+		nodes = flattenPlan(child, nodes)
+	}
+	return nodes
+}
+
+// parseTextPlan returns the query text and the nodes of a text plan,
+// depth first
+func parseTextPlan(body string) (string, []planNode) {
+	var queryLines []string
+	var nodes []planNode
+	inQuery := false
+
+	for _, line := range strings.Split(body, "\n") {
+		if match := textPlanNodeRegex.FindStringSubmatch(line); match != nil {
+			inQuery = false
+			node := planNode{}
+			name := match[1]
+			if i := strings.Index(name, " on "); i >= 0 {
+				node.RelationName = strings.Fields(name[i+len(" on "):])[0]
+				name = name[:i]
+			}
+			if i := strings.Index(name, " using "); i >= 0 {
+				name = name[:i]
+			}
+			node.NodeType = name
+			node.TotalCost, _ = strconv.ParseFloat(match[2], 64)
+			node.PlanRows, _ = strconv.ParseFloat(match[3], 64)
+			if match[4] != "" {
+				node.ActualTotalTime, _ = strconv.ParseFloat(match[4], 64)
+				node.ActualRows, _ = strconv.ParseFloat(match[5], 64)
+				node.ActualLoops, _ = strconv.ParseFloat(match[6], 64)
+			}
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if match := rowsRemovedRegex.FindStringSubmatch(line); match != nil && len(nodes) > 0 {
+			nodes[len(nodes)-1].RowsRemovedByFilter, _ = strconv.ParseFloat(match[1], 64)
+			continue
+		}
+
+		if strings.HasPrefix(line, "Query Text: ") {
+			inQuery = true
+			queryLines = append(queryLines, strings.TrimPrefix(line, "Query Text: "))
+		} else if inQuery {
+			queryLines = append(queryLines, line)
+		}
+	}
+	return strings.Join(queryLines, "\n"), nodes
+}
+
+// summarizePlan summarizes the nodes of a plan, the root node first
+func summarizePlan(nodes []planNode, seqScanRows int64) *PlanSummary {
+	root := nodes[0]
+	summary := &PlanSummary{
+		TotalCost:  root.TotalCost,
+		ActualTime: root.ActualTotalTime,
+		Rows:       int64(root.PlanRows),
+	}
+	if root.ActualLoops > 0 {
+		summary.Rows = int64(root.ActualRows)
+	}
+
+	seen := map[string]bool{}
+	for _, node := range nodes {
+		if !seen[node.NodeType] && len(summary.NodeTypes) < maxPlanNodeTypes {
+			seen[node.NodeType] = true
+			summary.NodeTypes = append(summary.NodeTypes, node.NodeType)
+		}
+
+		if !strings.HasSuffix(node.NodeType, "Seq Scan") {
+			continue
+		}
+		// rows read by the scan, estimated when the plan wasn't analyzed
+		rows := node.PlanRows
+		if node.ActualLoops > 0 {
+			rows = (node.ActualRows + node.RowsRemovedByFilter) * node.ActualLoops
+		}
+		if int64(rows) >= seqScanRows && !contains(summary.SeqScans, node.RelationName) {
+			summary.SeqScans = append(summary.SeqScans, node.RelationName)
+		}
+	}
+	return summary
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
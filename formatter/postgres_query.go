@@ -0,0 +1,76 @@
+package formatter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statementRegex matches the messages of log_min_duration_statement,
+// log_duration and log_statement, e.g.
+// "duration: 1.5 ms  execute S_1: SELECT ...", and the plans of auto_explain
+var statementRegex = regexp.MustCompile(`(?s)^(?:duration: ([0-9.]+) ms\s*)?(?:(statement|execute|bind|parse|plan)(?: ([^:]+))?:\s*(.*))?$`)
+
+// parametersRegex matches the bound parameters of a DETAIL line, e.g.
+// "parameters: $1 = '42', $2 = NULL"
+var parametersRegex = regexp.MustCompile(`\$(\d+) = ('(?:[^']|'')*'|NULL)`)
+
+// parseStatement fills the query fields of p from a duration, statement or
+// auto_explain message, marking p as a query event. Plans get summarized,
+// reporting sequential scans of at least seqScanRows rows.
+func (p *PostgresData) parseStatement(seqScanRows int64) {
+	p.EventType = EventTypeLog
+	if p.Severity != "LOG" {
+		return
+	}
+	match := statementRegex.FindStringSubmatch(p.Message)
+	if match == nil || (match[1] == "" && match[2] == "") {
+		return
+	}
+
+	p.EventType = EventTypeQuery
+	if match[1] != "" {
+		p.DurationMs, _ = strconv.ParseFloat(match[1], 64)
+		p.QueryTime = p.DurationMs / 1000
+	}
+	p.StatementType = match[2]
+	p.PreparedStatement = strings.TrimSpace(match[3])
+	p.Query = strings.TrimSpace(match[4])
+	if p.StatementType == "plan" {
+		p.Query, p.Plan = parsePlan(match[4], seqScanRows)
+		p.Query = strings.TrimSpace(p.Query)
+	}
+	p.ConnectionId = p.Pid
+	if t, err := time.Parse(time.RFC3339Nano, p.Time); err == nil {
+		p.Timestamp = t.Unix()
+	}
+
+	if strings.HasPrefix(p.Detail, "parameters: ") {
+		p.Parameters = map[string]string{}
+		for _, param := range parametersRegex.FindAllStringSubmatch(p.Detail, -1) {
+			p.Parameters["$"+param[1]] = param[2]
+		}
+	}
+}
+
+// parametersDetail returns the DETAIL line listing the bound parameters
+func parametersDetail(parameters map[string]string) string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(names[i], "$"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(names[j], "$"))
+		return a < b
+	})
+
+	list := make([]string, len(names))
+	for i, name := range names {
+		list[i] = fmt.Sprintf("%s = %s", name, parameters[name])
+	}
+	return "parameters: " + strings.Join(list, ", ")
+}
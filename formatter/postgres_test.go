@@ -0,0 +1,321 @@
+package formatter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parsePostgres formats the chunks of a log one after the other and flushes
+// the last entry
+func parsePostgres(t *testing.T, f *PostgresFormatter, chunks ...string) []PostgresData {
+	t.Helper()
+	var events []string
+	for _, chunk := range chunks {
+		events = append(events, f.Format(chunk)...)
+	}
+	var entries []PostgresData
+	for _, event := range append(events, f.Flush()...) {
+		var data PostgresData
+		if err := json.Unmarshal([]byte(event), &data); err != nil {
+			t.Fatalf("invalid event %s: %s", event, err)
+		}
+		entries = append(entries, data)
+	}
+	return entries
+}
+
+func TestPostgresFormatterDefaultPrefix(t *testing.T) {
+	log := `2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:ERROR:  relation "missing" does not exist at character 15
+2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:STATEMENT:  SELECT *
+	FROM missing
+	WHERE id = 1
+2022-09-01 08:00:02 UTC::@:[812]:LOG:  checkpoint starting: time
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:FATAL:  password authentication failed for user "rdsadmin"
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:DETAIL:  Connection matched pg_hba.conf line 13
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:HINT:  Check the password.
+`
+	entries := parsePostgres(t, &PostgresFormatter{}, log)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %+v", len(entries), entries)
+	}
+
+	first := entries[0]
+	if first.Time != "2022-09-01T08:00:01Z" || first.Host != "10.0.1.7" || first.Port != 53412 ||
+		first.User != "app" || first.DatabaseName != "orders" || first.Pid != 4321 || first.Severity != "ERROR" {
+		t.Errorf("unexpected prefix fields: %+v", first)
+	}
+	if first.Message != `relation "missing" does not exist at character 15` {
+		t.Errorf("unexpected message: %q", first.Message)
+	}
+	if first.Statement != "SELECT *\nFROM missing\nWHERE id = 1" {
+		t.Errorf("unexpected statement: %q", first.Statement)
+	}
+
+	if entries[1].Pid != 812 || entries[1].User != "" || entries[1].Message != "checkpoint starting: time" {
+		t.Errorf("unexpected background entry: %+v", entries[1])
+	}
+
+	last := entries[2]
+	if last.Host != "[local]" || last.Detail != "Connection matched pg_hba.conf line 13" || last.Hint != "Check the password." {
+		t.Errorf("unexpected attached fields: %+v", last)
+	}
+}
+
+func TestPostgresFormatterJoinsEntriesAcrossChunks(t *testing.T) {
+	log := `2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:ERROR:  relation "missing" does not exist at character 15
+2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:STATEMENT:  SELECT *
+	FROM missing
+	WHERE id = 1
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:FATAL:  password authentication failed for user "rdsadmin"
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:DETAIL:  Connection matched pg_hba.conf line 13
+2022-09-01 08:00:03 UTC:[local]:rdsadmin@rdsadmin:[900]:HINT:  Check the password.
+`
+	expected := parsePostgres(t, &PostgresFormatter{}, log)
+	if len(expected) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", expected)
+	}
+
+	// chunks may end anywhere, also before continuation and attached lines
+	for i := 1; i < len(log); i++ {
+		entries := parsePostgres(t, &PostgresFormatter{}, log[:i], log[i:])
+		if !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("split at %d %q: expected %+v, got %+v", i, log[i:], expected, entries)
+		}
+	}
+
+	// the open entry is kept back until the next one starts
+	f := &PostgresFormatter{}
+	firstEntry := strings.Index(log, "2022-09-01 08:00:03")
+	if events := f.Format(log[:firstEntry]); len(events) != 0 {
+		t.Errorf("expected the open entry to be kept, got %v", events)
+	}
+	if f.Pending() != firstEntry {
+		t.Errorf("expected %d pending bytes, got %d", firstEntry, f.Pending())
+	}
+	if events := f.Format(log[firstEntry:]); len(events) != 1 || !strings.Contains(events[0], "WHERE id = 1") {
+		t.Errorf("expected the complete first entry, got %v", events)
+	}
+	if f.Pending() != len(log)-firstEntry {
+		t.Errorf("expected the second entry pending, got %d bytes", f.Pending())
+	}
+}
+
+func TestPostgresFormatterCustomPrefix(t *testing.T) {
+	f := &PostgresFormatter{Prefix: "%m [%p] %q%u@%d/%a "}
+	log := `2022-09-01 08:00:01.250 UTC [77] LOG:  database system is ready to accept connections
+2022-09-01 08:00:02.500 UTC [78] app@orders/psql LOG:  disconnection: session time: 0:00:01.200
+`
+	entries := parsePostgres(t, f, log)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %+v", len(entries), entries)
+	}
+	if entries[0].Time != "2022-09-01T08:00:01.25Z" || entries[0].Pid != 77 || entries[0].User != "" {
+		t.Errorf("unexpected entry without session fields: %+v", entries[0])
+	}
+	if entries[1].User != "app" || entries[1].DatabaseName != "orders" || entries[1].Application != "psql" {
+		t.Errorf("unexpected session entry: %+v", entries[1])
+	}
+}
+
+func TestPostgresTime(t *testing.T) {
+	for _, test := range []struct {
+		value, expected string
+	}{
+		{"2022-09-01 08:00:01 UTC", "2022-09-01T08:00:01Z"},
+		{"2022-09-01 08:00:01", "2022-09-01T08:00:01Z"},
+		{"2022-09-01 10:00:01 CEST", "2022-09-01T08:00:01Z"},
+		{"2022-01-10 09:00:01.250 CET", "2022-01-10T08:00:01.25Z"},
+		{"2022-09-01 01:00:01 PDT", "2022-09-01T08:00:01Z"},
+		{"2022-09-01 13:30:01 +0530", "2022-09-01T08:00:01Z"},
+		{"2022-09-01 13:30:01.500+05:30", "2022-09-01T08:00:01.5Z"},
+		{"2022-09-01 05:00:01 -03", "2022-09-01T08:00:01Z"},
+		// ambiguous and unknown abbreviations are not shifted
+		{"2022-09-01 13:30:01 IST", "2022-09-01 13:30:01 IST"},
+		{"2022-09-01 13:30:01 XYZT", "2022-09-01 13:30:01 XYZT"},
+		{"2022-09-01", "2022-09-01"},
+	} {
+		if actual := postgresTime(test.value); actual != test.expected {
+			t.Errorf("postgresTime(%q) = %q, expected %q", test.value, actual, test.expected)
+		}
+	}
+}
+
+func TestPostgresFormatterKeepsTimesOfUnknownZones(t *testing.T) {
+	f := &PostgresFormatter{Prefix: "%m [%p] "}
+	log := `2022-09-01 10:00:01.250 CET [77] LOG:  database system is ready to accept connections
+2022-09-01 13:30:02.500 IST [78] LOG:  checkpoint starting: time
+`
+	entries := parsePostgres(t, f, log)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %+v", len(entries), entries)
+	}
+	if entries[0].Time != "2022-09-01T09:00:01.25Z" {
+		t.Errorf("expected the CET time in UTC, got %q", entries[0].Time)
+	}
+	if entries[1].Time != "2022-09-01 13:30:02.500 IST" {
+		t.Errorf("expected the IST time as logged, got %q", entries[1].Time)
+	}
+}
+
+func TestCompileLogLinePrefixRejectsUnknownEscapes(t *testing.T) {
+	if _, err := CompileLogLinePrefix("%t %z "); err == nil {
+		t.Error("expected an error for %z")
+	}
+	if _, err := CompileLogLinePrefix("%t:%r:%u@%d:[%p]:%"); err == nil {
+		t.Error("expected an error for a trailing %")
+	}
+}
+
+func TestPostgresFormatterDurations(t *testing.T) {
+	log := `2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  duration: 1234.567 ms  statement: SELECT *
+	FROM orders WHERE phone = '9876543210'
+2022-09-01 08:00:02 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  duration: 2.500 ms  execute S_1: SELECT * FROM orders WHERE id = $1 AND phone = $2
+2022-09-01 08:00:02 UTC:10.0.1.7(53412):app@orders:[4321]:DETAIL:  parameters: $1 = '42', $2 = '9876543210'
+2022-09-01 08:00:03 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  connection authorized: user=app database=orders
+`
+	entries := parsePostgres(t, &PostgresFormatter{}, log)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %+v", len(entries), entries)
+	}
+
+	slow := entries[0]
+	if slow.EventType != EventTypeQuery || slow.DurationMs != 1234.567 || slow.QueryTime != 1.234567 ||
+		slow.StatementType != "statement" || slow.ConnectionId != 4321 || slow.Timestamp != 1662019201 {
+		t.Errorf("unexpected slow statement: %+v", slow)
+	}
+	if slow.Query != "SELECT *\nFROM orders WHERE phone = '?'" {
+		t.Errorf("unexpected query: %q", slow.Query)
+	}
+
+	execute := entries[1]
+	if execute.StatementType != "execute" || execute.PreparedStatement != "S_1" || execute.DurationMs != 2.5 {
+		t.Errorf("unexpected execute: %+v", execute)
+	}
+	if execute.Parameters["$1"] != "'42'" || execute.Parameters["$2"] != "'?'" {
+		t.Errorf("unexpected parameters: %v", execute.Parameters)
+	}
+
+	if entries[2].EventType != EventTypeLog || entries[2].Query != "" {
+		t.Errorf("expected a plain log event: %+v", entries[2])
+	}
+}
+
+// autoExplainLog holds a JSON and a text plan logged by auto_explain
+var autoExplainLog = `2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  duration: 812.250 ms  plan:
+	{
+	  "Query Text": "SELECT * FROM orders o JOIN users u ON u.id = o.user_id WHERE o.state = 'open'",
+	  "Plan": {
+	    "Node Type": "Hash Join",
+	    "Total Cost": 4520.75,
+	    "Plan Rows": 120,
+	    "Actual Total Time": 811.9,
+	    "Actual Rows": 98,
+	    "Actual Loops": 1,
+	    "Plans": [
+	      {"Node Type": "Seq Scan", "Relation Name": "orders", "Total Cost": 3210.0, "Plan Rows": 120,
+	       "Actual Rows": 98, "Actual Loops": 1, "Rows Removed by Filter": 250000},
+	      {"Node Type": "Hash", "Total Cost": 12.5, "Plan Rows": 500, "Actual Rows": 500, "Actual Loops": 1,
+	       "Plans": [{"Node Type": "Seq Scan", "Relation Name": "users", "Total Cost": 12.5, "Plan Rows": 500,
+	                  "Actual Rows": 500, "Actual Loops": 1}]}
+	    ]
+	  }
+	}
+2022-09-01 08:00:02 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  duration: 20.000 ms  plan:
+	Query Text: SELECT count(*)
+	  FROM payments
+	Aggregate  (cost=1693.00..1693.01 rows=1 width=8) (actual time=19.8..19.8 rows=1 loops=1)
+	  ->  Seq Scan on payments  (cost=0.00..1443.00 rows=100000 width=0) (actual time=0.01..11.2 rows=100000 loops=1)
+`
+
+func TestPostgresFormatterAutoExplain(t *testing.T) {
+	entries := parsePostgres(t, &PostgresFormatter{}, autoExplainLog)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %+v", len(entries), entries)
+	}
+
+	jsonPlan := entries[0]
+	if jsonPlan.DurationMs != 812.25 || jsonPlan.StatementType != "plan" || jsonPlan.Plan == nil {
+		t.Fatalf("unexpected json plan event: %+v", jsonPlan)
+	}
+	if jsonPlan.Query != "SELECT * FROM orders o JOIN users u ON u.id = o.user_id WHERE o.state = 'open'" {
+		t.Errorf("unexpected query: %q", jsonPlan.Query)
+	}
+	plan := jsonPlan.Plan
+	if plan.Format != "json" || plan.TotalCost != 4520.75 || plan.ActualTime != 811.9 || plan.Rows != 98 {
+		t.Errorf("unexpected plan summary: %+v", plan)
+	}
+	if len(plan.NodeTypes) != 3 || plan.NodeTypes[0] != "Hash Join" || plan.NodeTypes[1] != "Seq Scan" || plan.NodeTypes[2] != "Hash" {
+		t.Errorf("unexpected node types: %v", plan.NodeTypes)
+	}
+	if len(plan.SeqScans) != 1 || plan.SeqScans[0] != "orders" {
+		t.Errorf("expected only the scan of orders to be reported: %v", plan.SeqScans)
+	}
+
+	textPlan := entries[1]
+	if textPlan.Plan == nil || textPlan.Query != "SELECT count(*)\n  FROM payments" {
+		t.Fatalf("unexpected text plan event: %+v", textPlan)
+	}
+	if textPlan.Plan.Format != "text" || textPlan.Plan.TotalCost != 1693.01 || textPlan.Plan.Rows != 1 ||
+		len(textPlan.Plan.SeqScans) != 1 || textPlan.Plan.SeqScans[0] != "payments" {
+		t.Errorf("unexpected text plan summary: %+v", textPlan.Plan)
+	}
+}
+
+func TestPostgresFormatterJoinsPlansAcrossChunks(t *testing.T) {
+	expected := parsePostgres(t, &PostgresFormatter{}, autoExplainLog)
+	for i := 1; i < len(autoExplainLog); i++ {
+		entries := parsePostgres(t, &PostgresFormatter{}, autoExplainLog[:i], autoExplainLog[i:])
+		if !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("split at %d %q: expected %+v, got %+v", i, autoExplainLog[i:], expected, entries)
+		}
+	}
+
+	// a JSON plan cut in three still parses
+	first := strings.Index(autoExplainLog, `"Plans"`)
+	second := strings.Index(autoExplainLog, "Aggregate")
+	entries := parsePostgres(t, &PostgresFormatter{}, autoExplainLog[:first], autoExplainLog[first:second], autoExplainLog[second:])
+	if len(entries) != 2 || entries[0].Plan == nil || len(entries[0].Plan.NodeTypes) != 3 {
+		t.Fatalf("expected the JSON plan summarized, got %+v", entries)
+	}
+	if entries[1].Plan == nil || entries[1].Plan.TotalCost != 1693.01 {
+		t.Errorf("expected the text plan summarized, got %+v", entries[1])
+	}
+}
+
+func TestPostgresFormatterPgaudit(t *testing.T) {
+	log := `2022-09-01 08:00:01 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  AUDIT: SESSION,3,1,WRITE,UPDATE,TABLE,public.users,"UPDATE users SET phone = '9876543210'
+	WHERE id = 7",<not logged>
+2022-09-01 08:00:02 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  AUDIT: OBJECT,4,1,READ,SELECT,TABLE,public.cards,SELECT id FROM cards WHERE pan = $1,9876543210
+2022-09-01 08:00:03 UTC:10.0.1.7(53412):app@orders:[4321]:LOG:  AUDIT trail rotated
+`
+	entries := parsePostgres(t, &PostgresFormatter{}, log)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %+v", len(entries), entries)
+	}
+
+	session := entries[0]
+	if session.EventType != EventTypeAudit || session.AuditType != "SESSION" || session.StatementID != 3 ||
+		session.SubstatementID != 1 || session.AuditClass != "WRITE" || session.Command != "UPDATE" ||
+		session.ObjectType != "TABLE" || session.ObjectName != "public.users" || session.AuditParameters != "" {
+		t.Errorf("unexpected session audit event: %+v", session)
+	}
+	if session.Query != "UPDATE users SET phone = '?'\nWHERE id = 7" {
+		t.Errorf("unexpected audited statement: %q", session.Query)
+	}
+
+	object := entries[1]
+	if object.AuditType != "OBJECT" || object.ObjectName != "public.cards" || object.AuditParameters != "?" {
+		t.Errorf("unexpected object audit event: %+v", object)
+	}
+	for _, entry := range entries[:2] {
+		if strings.Contains(entry.Message, "9876543210") {
+			t.Errorf("expected the redacted statement and parameters in the message: %q", entry.Message)
+		}
+	}
+
+	if entries[2].EventType != EventTypeLog {
+		t.Errorf("expected a plain log event: %+v", entries[2])
+	}
+}
@@ -0,0 +1,398 @@
+package formatter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matchers of the redaction rules
+const (
+	// MatchRegex rules redact every match of their pattern in the text
+	MatchRegex = "regex"
+	// MatchLiteral rules redact the string and number literals of a query
+	// which match their pattern and, if given, are compared against one of
+	// their columns
+	MatchLiteral = "literal"
+)
+
+// Actions of the redaction rules
+const (
+	// ActionMask replaces the value by the mask, "?" by default
+	ActionMask = "mask"
+	// ActionHash replaces the value by its salted hash, so equal values can
+	// still be correlated
+	ActionHash = "hash"
+	// ActionDrop removes the value, leaving empty quotes for strings
+	ActionDrop = "drop"
+)
+
+// RedactionRule is a named rule of a redaction rules file
+type RedactionRule struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	// Pattern is the regex of the redacted text, or the regex the value of a
+	// literal must match. Literal rules without a pattern match every value.
+	Pattern string `json:"pattern,omitempty"`
+	// Columns limits literal rules to values compared against, assigned or
+	// inserted into these columns (case insensitive)
+	Columns []string `json:"columns,omitempty"`
+	Action  string   `json:"action"`
+	Mask    string   `json:"mask,omitempty"`
+}
+
+// RedactionRules is the content of a redaction rules file. The rules are
+// applied in order; the first literal rule matching a literal redacts it.
+type RedactionRules struct {
+	// Salt is prepended to the values hashed by hash rules
+	Salt  string          `json:"salt,omitempty"`
+	Rules []RedactionRule `json:"rules"`
+}
+
+// DefaultRedactionRules redact e-mail and IP addresses wherever they appear,
+// and the values of the phone, card and name columns
+var DefaultRedactionRules = RedactionRules{
+	Rules: []RedactionRule{
+		{Name: "email", Match: MatchRegex, Pattern: `[a-zA-Z0-9.!#$%&*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+`, Action: ActionMask},
+		{Name: "ip_address", Match: MatchRegex, Pattern: `\b(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(?:\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}\b`, Action: ActionMask},
+		{Name: "phone", Match: MatchLiteral, Columns: []string{"phone", "phone_number", "mobile", "mobile_number", "contact", "contact_number", "msisdn"}, Action: ActionMask},
+		{Name: "card", Match: MatchLiteral, Columns: []string{"card", "card_number", "pan", "cvv"}, Action: ActionMask},
+		{Name: "name", Match: MatchLiteral, Columns: []string{"name"}, Action: ActionMask},
+	},
+}
+
+// defaultRedactor is used by formatters without a Redactor
+var defaultRedactor = mustRedactor(DefaultRedactionRules)
+
+// Redactor removes sensitive data from the queries and messages of events
+// according to its rules, which are compiled once
+type Redactor struct {
+	salt    string
+	regexes []compiledRule
+	// literals are the literal rules
+	literals []compiledRule
+}
+
+type compiledRule struct {
+	RedactionRule
+	pattern *regexp.Regexp
+	columns map[string]bool
+}
+
+// NewRedactor compiles rules
+func NewRedactor(rules RedactionRules) (*Redactor, error) {
+	r := &Redactor{salt: rules.Salt}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		compiled := compiledRule{RedactionRule: rule}
+		switch rule.Action {
+		case ActionMask:
+			if compiled.Mask == "" {
+				compiled.Mask = "?"
+			}
+		case ActionHash, ActionDrop:
+		default:
+			return nil, fmt.Errorf("redaction rule %s: unknown action `%s`", rule.Name, rule.Action)
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule %s: %s", rule.Name, err)
+			}
+			compiled.pattern = pattern
+		}
+
+		switch rule.Match {
+		case MatchRegex:
+			if compiled.pattern == nil || len(rule.Columns) > 0 {
+				return nil, fmt.Errorf("redaction rule %s: regex rules need a pattern and no columns", rule.Name)
+			}
+			r.regexes = append(r.regexes, compiled)
+		case MatchLiteral:
+			if len(rule.Columns) > 0 {
+				compiled.columns = map[string]bool{}
+				for _, column := range rule.Columns {
+					compiled.columns[strings.ToLower(column)] = true
+				}
+			}
+			r.literals = append(r.literals, compiled)
+		default:
+			return nil, fmt.Errorf("redaction rule %s: unknown match `%s`", rule.Name, rule.Match)
+		}
+	}
+	return r, nil
+}
+
+// LoadRedactor reads the JSON redaction rules file at path
+func LoadRedactor(path string) (*Redactor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules RedactionRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("redaction rules %s: %s", path, err)
+	}
+	return NewRedactor(rules)
+}
+
+func mustRedactor(rules RedactionRules) *Redactor {
+	r, err := NewRedactor(rules)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// redact returns text with the literals matched by the literal rules and
+// the matches of the regex rules replaced. A nil Redactor uses the default
+// rules.
+func (r *Redactor) redact(text string, d dialect) string {
+	if r == nil {
+		r = defaultRedactor
+	}
+	if text == "" {
+		return text
+	}
+
+	if len(r.literals) > 0 {
+		tokens := tokenize(text, d)
+		columns := valueColumns(tokens)
+		var b strings.Builder
+		last := 0
+		for i, token := range tokens {
+			if token.kind != tokenString && token.kind != tokenNumber {
+				continue
+			}
+			if replaced, ok := r.redactLiteral(text[token.start:token.end], columns[i]); ok {
+				b.WriteString(text[last:token.start])
+				b.WriteString(replaced)
+				last = token.end
+			}
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return r.redactRegexes(text)
+}
+
+// redactParameters returns the bound parameters of query, named like $1,
+// redacted by the column their placeholder is compared against
+func (r *Redactor) redactParameters(query string, parameters map[string]string, d dialect) map[string]string {
+	if r == nil {
+		r = defaultRedactor
+	}
+	tokens := tokenize(query, d)
+	columns := valueColumns(tokens)
+	parameterColumns := map[string]string{}
+	for i, token := range tokens {
+		if token.kind == tokenParameter && columns[i] != "" {
+			parameterColumns[query[token.start:token.end]] = columns[i]
+		}
+	}
+
+	redacted := make(map[string]string, len(parameters))
+	for name, value := range parameters {
+		redacted[name] = r.redactValue(value, parameterColumns[name])
+	}
+	return redacted
+}
+
+// redactValue redacts a value bound to a parameter compared against column
+func (r *Redactor) redactValue(value, column string) string {
+	if value != "NULL" {
+		if replaced, ok := r.redactLiteral(value, column); ok {
+			value = replaced
+		}
+	}
+	return r.redactRegexes(value)
+}
+
+// redactRegexes replaces the matches of the regex rules in text
+func (r *Redactor) redactRegexes(text string) string {
+	for _, rule := range r.regexes {
+		text = rule.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			return r.replacement(rule, match)
+		})
+	}
+	return text
+}
+
+// redactLiteral applies the first matching literal rule to the literal,
+// keeping the quotes of strings
+func (r *Redactor) redactLiteral(text, column string) (string, bool) {
+	open, value, closing := splitQuotes(text)
+	for _, rule := range r.literals {
+		if rule.columns != nil && !rule.columns[column] {
+			continue
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(value) {
+			continue
+		}
+		return open + r.replacement(rule, value) + closing, true
+	}
+	return "", false
+}
+
+func (r *Redactor) replacement(rule compiledRule, value string) string {
+	switch rule.Action {
+	case ActionHash:
+		sum := sha256.Sum256([]byte(r.salt + value))
+		return hex.EncodeToString(sum[:8])
+	case ActionDrop:
+		return ""
+	}
+	return rule.Mask
+}
+
+// splitQuotes splits a string literal into its opening quote (with any
+// prefix like E or _utf8mb4), its value and its closing quote
+func splitQuotes(literal string) (string, string, string) {
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		if c != '\'' && c != '"' && c != '$' {
+			if isWordByte(c) {
+				continue
+			}
+			break
+		}
+		quote := string(c)
+		if c == '$' {
+			end := strings.IndexByte(literal[i+1:], '$')
+			if end < 0 {
+				break
+			}
+			quote = literal[i : i+end+2]
+		}
+		if len(literal) >= i+2*len(quote) && strings.HasSuffix(literal, quote) {
+			return literal[:i+len(quote)], literal[i+len(quote) : len(literal)-len(quote)], quote
+		}
+		break
+	}
+	return "", literal, ""
+}
+
+// comparisons are the operators comparing a column to a value
+var comparisons = map[string]bool{
+	"=": true, "==": true, "<>": true, "!=": true, "<=>": true, "<": true, ">": true, "<=": true, ">=": true,
+	"like": true, "ilike": true, "regexp": true, "rlike": true,
+}
+
+// valueColumns returns the column each literal or parameter of tokens is
+// compared against (col = 'x', 'x' = col, col IN ('x', 'y'), col LIKE 'x',
+// SET col = 'x') or inserted into (INSERT INTO t (col) VALUES ('x')), by
+// token index. Columns are lowercased and unqualified.
+func valueColumns(tokens []sqlToken) map[int]string {
+	columns := map[int]string{}
+	for i, token := range tokens {
+		if !token.isValue() {
+			continue
+		}
+		if column := comparedColumn(tokens, i); column != "" {
+			columns[i] = column
+		}
+	}
+	for i, token := range tokens {
+		if token.text == "values" {
+			insertedColumns(tokens, i, columns)
+		}
+	}
+	return columns
+}
+
+// comparedColumn returns the column the value at i is compared against
+func comparedColumn(tokens []sqlToken, i int) string {
+	if i+2 < len(tokens) && comparisons[tokens[i+1].text] && tokens[i+2].kind == tokenWord &&
+		(i == 0 || !comparisons[tokens[i-1].text]) {
+		// 'x' = col, or 'x' = t.col
+		k := i + 2
+		for k+2 < len(tokens) && tokens[k+1].text == "." && tokens[k+2].kind == tokenWord {
+			k += 2
+		}
+		return identifier(tokens[k].text)
+	}
+
+	j := i - 1
+	// the earlier values of an IN list
+	for j >= 1 && tokens[j].text == "," && tokens[j-1].isValue() {
+		j -= 2
+	}
+	if j >= 1 && tokens[j].text == "(" && tokens[j-1].text == "in" {
+		j -= 2
+	} else if j >= 0 && comparisons[tokens[j].text] {
+		j--
+	} else {
+		return ""
+	}
+	if j >= 0 && tokens[j].text == "not" {
+		j--
+	}
+	if j >= 0 && tokens[j].kind == tokenWord {
+		return identifier(tokens[j].text)
+	}
+	return ""
+}
+
+// insertedColumns maps the values of the rows following the VALUES keyword
+// at i to the column list before it
+func insertedColumns(tokens []sqlToken, i int, columns map[int]string) {
+	if i == 0 || tokens[i-1].text != ")" {
+		return
+	}
+	var names []string
+	j := i - 2
+	for ; j >= 0 && tokens[j].text != "("; j-- {
+		if tokens[j].kind == tokenWord {
+			names = append([]string{identifier(tokens[j].text)}, names...)
+		} else if tokens[j].text != "," {
+			return
+		}
+	}
+	if j < 0 {
+		return
+	}
+
+	depth, position := 0, 0
+	for k := i + 1; k < len(tokens); k++ {
+		switch tokens[k].text {
+		case "(":
+			depth++
+			if depth == 1 {
+				position = 0
+			}
+			continue
+		case ")":
+			depth--
+			if depth < 0 {
+				return
+			}
+			continue
+		case ",":
+			if depth == 1 {
+				position++
+			}
+			continue
+		}
+		if depth == 0 {
+			return
+		}
+		// only values making up a whole column of the row
+		if depth == 1 && tokens[k].isValue() && position < len(names) &&
+			(tokens[k-1].text == "(" || tokens[k-1].text == ",") &&
+			k+1 < len(tokens) && (tokens[k+1].text == "," || tokens[k+1].text == ")") {
+			columns[k] = names[position]
+		}
+	}
+}
+
+// identifier returns the lowercased name of an identifier token, without the
+// quotes of quoted identifiers
+func identifier(text string) string {
+	return strings.ToLower(strings.Trim(text, `"`))
+}
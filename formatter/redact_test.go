@@ -0,0 +1,110 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRedactorLeavesIdentifiers is the corpus of queries the default rules
+// must leave as they are: identifiers, IDs, timestamps and columns named
+// like sensitive ones which aren't compared against a value.
+func TestRedactorLeavesIdentifiers(t *testing.T) {
+	corpus := []string{
+		"SELECT * FROM orders WHERE id = 9876543210",
+		"SELECT * FROM events WHERE created_at > 1662019201 AND updated_at < '2022-09-01 08:00:01'",
+		"SELECT `phone`, `name` FROM `users` WHERE `user_id` = 1234567890",
+		"SELECT phone_verified, name_hash FROM users WHERE account_9876543210 = 1",
+		"UPDATE users SET phone_verified = 1, display_name_id = 1234567890123 WHERE id = 42",
+		"INSERT INTO users (id, created_at) VALUES (9876543210, 1662019201), (9876543211, 1662019202)",
+		"SELECT * FROM t WHERE version = '8.0.28' AND build = '1.2.3'",
+		"SELECT name FROM users ORDER BY name LIMIT 10",
+		"SELECT * FROM users WHERE name IS NULL",
+		"SELECT * FROM users u JOIN phones p ON p.user_id = u.id WHERE u.id IN (1, 2, 3)",
+		`SELECT "Phone", "Name" FROM "Users" WHERE "UserId" = $1`,
+		"SELECT ?, ? FROM dual WHERE phone = ?",
+		"Buffer pool(s) load completed at 220901  8:00:02",
+	}
+	for _, query := range corpus {
+		d := dialectMySQL
+		if strings.Contains(query, `"`) {
+			d = dialectPostgres
+		}
+		if got := (*Redactor)(nil).redact(query, d); got != query {
+			t.Errorf("expected %q to be left as it is, got %q", query, got)
+		}
+	}
+}
+
+func TestRedactorDefaultRules(t *testing.T) {
+	tests := []struct{ query, expect string }{
+		{"SELECT * FROM users WHERE email = 'jane.doe@example.com'", "SELECT * FROM users WHERE email = '?'"},
+		{"SELECT * FROM users WHERE phone = '9876543210' AND id = 9876543210", "SELECT * FROM users WHERE phone = '?' AND id = 9876543210"},
+		{"SELECT * FROM users WHERE '9876543210' = u.phone", "SELECT * FROM users WHERE '?' = u.phone"},
+		{"SELECT * FROM users WHERE `mobile` IN ('9876543210', '9876543211') OR mobile NOT LIKE '+91%'", "SELECT * FROM users WHERE `mobile` IN ('?', '?') OR mobile NOT LIKE '?'"},
+		{"UPDATE users SET name = 'Jane Doe', phone = 9876543210 WHERE id = 7", "UPDATE users SET name = '?', phone = ? WHERE id = 7"},
+		{"INSERT INTO cards (id, pan, expiry) VALUES (7, '4111111111111111', '12/30'), (8, '5500000000000004', '01/31')", "INSERT INTO cards (id, pan, expiry) VALUES (7, '?', '12/30'), (8, '?', '01/31')"},
+		{"IP address '10.0.1.7' could not be resolved", "IP address '?' could not be resolved"},
+		{"Access denied for user 'app'@'10.0.1.7'", "Access denied for user 'app'@'?'"},
+	}
+	for _, test := range tests {
+		if got := (*Redactor)(nil).redact(test.query, dialectMySQL); got != test.expect {
+			t.Errorf("redact(%q): expected %q, got %q", test.query, test.expect, got)
+		}
+	}
+
+	parameters := (*Redactor)(nil).redactParameters("SELECT * FROM users WHERE id = $1 AND phone = $2",
+		map[string]string{"$1": "'9876543210'", "$2": "'9876543210'"}, dialectPostgres)
+	if parameters["$1"] != "'9876543210'" || parameters["$2"] != "'?'" {
+		t.Errorf("expected only the phone parameter to be redacted, got %v", parameters)
+	}
+	if detail := parametersDetail(parameters); detail != "parameters: $1 = '9876543210', $2 = '?'" {
+		t.Errorf("unexpected parameters detail %q", detail)
+	}
+}
+
+func TestRedactorRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{
+  "salt": "pepper",
+  "rules": [
+    {"name": "email", "match": "literal", "columns": ["email"], "action": "hash"},
+    {"name": "pan", "match": "literal", "pattern": "^[A-Z]{5}[0-9]{4}[A-Z]$", "action": "mask", "mask": "PAN"},
+    {"name": "notes", "match": "literal", "columns": ["notes"], "action": "drop"},
+    {"name": "tokens", "match": "regex", "pattern": "tok_[a-z0-9]+", "action": "mask", "mask": "tok_?"}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadRedactor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := "UPDATE users SET email = 'jane@example.com', notes = 'call back', tax_id = 'ABCDE1234F', phone = '9876543210' WHERE token = 'tok_abc123'"
+	got := r.redact(query, dialectMySQL)
+	hash := r.replacement(r.literals[0], "jane@example.com")
+	expect := "UPDATE users SET email = '" + hash + "', notes = '', tax_id = 'PAN', phone = '9876543210' WHERE token = 'tok_?'"
+	if got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+	if other := r.redact("SELECT * FROM users WHERE email = 'jane@example.com'", dialectMySQL); !strings.Contains(other, hash) {
+		t.Errorf("expected equal values to hash alike, got %q", other)
+	}
+	if unsalted := mustRedactor(RedactionRules{Rules: []RedactionRule{{Match: MatchLiteral, Action: ActionHash}}}); unsalted.replacement(unsalted.literals[0], "jane@example.com") == hash {
+		t.Error("expected the hash to depend on the salt")
+	}
+
+	for _, invalid := range []RedactionRules{
+		{Rules: []RedactionRule{{Name: "a", Match: MatchRegex, Pattern: "(", Action: ActionMask}}},
+		{Rules: []RedactionRule{{Name: "b", Match: MatchRegex, Action: ActionMask}}},
+		{Rules: []RedactionRule{{Name: "c", Match: MatchLiteral, Action: "encrypt"}}},
+		{Rules: []RedactionRule{{Name: "d", Match: "column", Action: ActionDrop}}},
+	} {
+		if _, err := NewRedactor(invalid); err == nil {
+			t.Errorf("expected an error for %+v", invalid.Rules[0])
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package formatter
+
+import "strings"
+
+// dialect selects the SQL syntax of the tokenized queries
+type dialect int
+
+const (
+	dialectMySQL dialect = iota
+	dialectPostgres
+)
+
+// literal is the text of the literal and parameter tokens
+const literal = "?"
+
+type tokenKind int
+
+const (
+	// tokenWord is a keyword or an identifier
+	tokenWord tokenKind = iota
+	tokenString
+	tokenNumber
+	// tokenParameter is a placeholder like ? or $1
+	tokenParameter
+	// tokenOther is an operator or punctuation
+	tokenOther
+)
+
+// sqlToken is a token of a query. Its text is lowercased for words and
+// literal for literals and parameters; start and end are its position in
+// the query.
+type sqlToken struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+// isValue reports whether t is a literal or a parameter
+func (t sqlToken) isValue() bool {
+	return t.kind == tokenString || t.kind == tokenNumber || t.kind == tokenParameter
+}
+
+// tokenize splits query into its words, literals and operators, leaving out
+// whitespace and comments
+func tokenize(query string, d dialect) []sqlToken {
+	var tokens []sqlToken
+	add := func(kind tokenKind, text string, start, end int) {
+		tokens = append(tokens, sqlToken{kind: kind, text: text, start: start, end: end})
+	}
+	for i := 0; i < len(query); {
+		start := i
+		c := query[i]
+		next := byte(0)
+		if i+1 < len(query) {
+			next = query[i+1]
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && next == '-', c == '#' && d == dialectMySQL:
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && next == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			backslash := d == dialectMySQL
+			if prefix := stringPrefix(tokens, query, i); prefix != "" {
+				// E'...', B'...', X'...' and N'...' literals
+				start = tokens[len(tokens)-1].start
+				tokens = tokens[:len(tokens)-1]
+				backslash = backslash || prefix == "e"
+			}
+			i = skipQuoted(query, i, c, backslash)
+			add(tokenString, literal, start, i)
+		case c == '"' && d == dialectMySQL:
+			i = skipQuoted(query, i, c, true)
+			add(tokenString, literal, start, i)
+		case c == '"' || c == '`':
+			// quoted identifiers are kept as they are, except the backticks
+			i = skipQuoted(query, i, c, false)
+			text := query[start:i]
+			if c == '`' {
+				text = strings.Trim(text, "`")
+			}
+			add(tokenWord, text, start, i)
+		case c == '$' && d == dialectPostgres && isDigit(next):
+			i++
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+			add(tokenParameter, literal, start, i)
+		case c == '$' && d == dialectPostgres:
+			if end, ok := skipDollarQuoted(query, i); ok {
+				i = end
+				add(tokenString, literal, start, i)
+			} else {
+				i++
+				add(tokenOther, "$", start, i)
+			}
+		case isDigit(c), c == '.' && isDigit(next):
+			i = skipNumber(query, i)
+			add(tokenNumber, literal, start, i)
+		case (c == '-' || c == '+') && (isDigit(next) || next == '.') && isUnary(tokens):
+			i = skipNumber(query, i+1)
+			add(tokenNumber, literal, start, i)
+		case isWordByte(c):
+			for i < len(query) && (isWordByte(query[i]) || isDigit(query[i]) || query[i] == '$') {
+				i++
+			}
+			add(tokenWord, strings.ToLower(query[start:i]), start, i)
+		case strings.IndexByte("<>=!|:&~^", c) >= 0:
+			for i < len(query) && strings.IndexByte("<>=!|:&~^", query[i]) >= 0 {
+				i++
+			}
+			add(tokenOther, query[start:i], start, i)
+		case c == '?':
+			i++
+			add(tokenParameter, literal, start, i)
+		default:
+			i++
+			add(tokenOther, string(c), start, i)
+		}
+	}
+	return tokens
+}
+
+// skipQuoted returns the index after the quoted string starting at i. The
+// quote is escaped by doubling it, and by a backslash when backslash is set.
+func skipQuoted(query string, i int, quote byte, backslash bool) int {
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipDollarQuoted returns the index after the PostgreSQL dollar quoted
+// string ($$...$$ or $tag$...$tag$) starting at i
+func skipDollarQuoted(query string, i int) (int, bool) {
+	end := strings.IndexByte(query[i+1:], '$')
+	if end < 0 {
+		return 0, false
+	}
+	tag := query[i : i+end+2]
+	for _, c := range []byte(tag[1 : len(tag)-1]) {
+		if !isWordByte(c) && !isDigit(c) {
+			return 0, false
+		}
+	}
+	closing := strings.Index(query[i+len(tag):], tag)
+	if closing < 0 {
+		return len(query), true
+	}
+	return i + len(tag) + closing + len(tag), true
+}
+
+// skipNumber returns the index after the number starting at i, including
+// hex literals and exponents
+func skipNumber(query string, i int) int {
+	if strings.HasPrefix(query[i:], "0x") || strings.HasPrefix(query[i:], "0X") {
+		i += 2
+		for i < len(query) && strings.IndexByte("0123456789abcdefABCDEF", query[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	for i < len(query) {
+		c := query[i]
+		if isDigit(c) || c == '.' {
+			i++
+		} else if (c == 'e' || c == 'E') && i+1 < len(query) {
+			i++
+			if query[i] == '+' || query[i] == '-' {
+				i++
+			}
+		} else {
+			break
+		}
+	}
+	return i
+}
+
+// stringPrefix returns the prefix directly before the quote at i of escape
+// (E'\n'), bit (B'0101'), hex (X'0F'), national (N'...') or character set
+// introduced (_utf8mb4'...') strings, if any
+func stringPrefix(tokens []sqlToken, query string, i int) string {
+	if len(tokens) == 0 || i == 0 || tokens[len(tokens)-1].end != i {
+		return ""
+	}
+	switch last := tokens[len(tokens)-1].text; {
+	case last == "e" || last == "b" || last == "x" || last == "n" || strings.HasPrefix(last, "_"):
+		return last
+	}
+	return ""
+}
+
+// isUnary reports whether a sign following tokens belongs to a number
+// rather than being a subtraction or addition
+func isUnary(tokens []sqlToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenOther && last.text != ")"
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordByte reports whether c starts a keyword or identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -13,10 +14,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/sts"
 	flag "github.com/jessevdk/go-flags"
 	"github.com/razorpay/rdslogs/cli"
 	"github.com/razorpay/rdslogs/config"
 	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/formatter"
+	"github.com/razorpay/rdslogs/publisher"
 	"github.com/razorpay/rdslogs/tracker"
 	log "github.com/sirupsen/logrus"
 )
@@ -32,6 +36,16 @@ func main() {
 	}
 	config.InitilizeLogging()
 
+	if len(os.Args) > 1 && os.Args[1] == "state" {
+		if err := cli.RunState(os.Args[2:], os.Stdout); err != nil {
+			if flagErr, ok := err.(*flag.Error); ok && flagErr.Type == flag.ErrHelp {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
 	options, err := parseFlags()
 	if err != nil {
 		log.Fatal(err)
@@ -59,16 +73,65 @@ func main() {
 		RDS: rds.New(session.New(), &aws.Config{
 			Region: aws.String(options.Region),
 		}),
-		Abort: abort,
+		Abort:    abort,
+		Throttle: cli.NewThrottle(options.RateLimit),
+		Errors:   &cli.ErrorCounters{},
+	}
+
+	if options.RedactionRules != "" {
+		redactor, err := formatter.LoadRedactor(options.RedactionRules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Redactor = redactor
 	}
 
 	// Loading config based on tracker
 	if options.Tracker {
-		if options.TrackerType == constants.TrackerRedis {
+		// markers are keyed by account; guessing it would lose them
+		if options.TrackerAccount == "" {
+			identity, err := sts.New(session.New(), &aws.Config{
+				Region: aws.String(options.Region),
+			}).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+			if err != nil {
+				log.Fatal(fmt.Sprintf("looking up the AWS account of the tracker keys, set --tracker_account to skip it: %s", err))
+			}
+			options.TrackerAccount = aws.StringValue(identity.Account)
+		}
+		switch options.TrackerType {
+		case constants.TrackerRedis:
 			config.InitilizeRedisConfig()
-			c.Tracker = &tracker.RedisTracker{
-				Pool: tracker.NewPool(),
+			redisTracker, err := tracker.NewRedisTracker(config.RedisDBConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer redisTracker.Close()
+			c.Tracker = redisTracker
+		case constants.TrackerFile:
+			c.Tracker = &tracker.FileTracker{
+				Dir: options.TrackerDir,
 			}
+		case constants.TrackerBolt:
+			bolt, err := tracker.OpenBoltTracker(options.TrackerDB, time.Duration(options.TrackerHistory)*time.Hour)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bolt.Close()
+			c.Tracker = bolt
+		default:
+			log.Fatal(fmt.Sprintf("unsupported tracker type: `%s`", options.TrackerType))
+		}
+	}
+	if options.LeaderElection {
+		if _, ok := c.Tracker.(tracker.Leaser); !ok {
+			log.Fatal("--leader_election needs --tracker with the redis or file tracker")
+		}
+		if options.LeaseTTL < 3 {
+			log.Fatal("--lease_ttl must be at least 3 seconds")
+		}
+		if options.LeaseOwner == "" {
+			hostname, _ := os.Hostname()
+			options.LeaseOwner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
 		}
 	}
 
@@ -80,6 +143,94 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Sending output to STDOUT")
 	} else if options.Output == constants.OutputFile {
 		fmt.Fprintln(os.Stderr, "Sending output to FILE")
+	} else if options.Output == constants.OutputKafka {
+		fmt.Fprintln(os.Stderr, "Sending output to KAFKA")
+		if _, err := publisher.KafkaKey(options.KafkaPartitionKey, ""); err != nil {
+			log.Fatal(err)
+		}
+		writer, err := publisher.NewKafkaWriter(publisher.KafkaConfig{
+			Brokers:       options.KafkaBrokers,
+			Topic:         options.KafkaTopic,
+			BatchSize:     options.KafkaBatchSize,
+			Linger:        time.Duration(options.KafkaLinger) * time.Millisecond,
+			Compression:   options.KafkaCompression,
+			Acks:          options.KafkaAcks,
+			TLS:           options.KafkaTLS,
+			TLSCAFile:     options.KafkaTLSCA,
+			TLSCertFile:   options.KafkaTLSCert,
+			TLSKeyFile:    options.KafkaTLSKey,
+			TLSSkipVerify: options.KafkaTLSSkipVerify,
+			SASLMechanism: options.KafkaSASLMechanism,
+			SASLUser:      options.KafkaSASLUser,
+			SASLPassword:  options.KafkaSASLPassword,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Kafka = writer
+	} else if options.Output == constants.OutputHTTP {
+		fmt.Fprintln(os.Stderr, "Sending output to HTTP")
+		if options.HTTPURL == "" {
+			log.Fatal("http output needs --http_url")
+		}
+		if options.HTTPFormat != publisher.HTTPFormatNDJSON && options.HTTPFormat != publisher.HTTPFormatJSON {
+			log.Fatal(fmt.Sprintf("unsupported http format: `%s`", options.HTTPFormat))
+		}
+		headers, err := publisher.ParseHTTPHeaders(options.HTTPHeaders)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.HTTP = publisher.HTTPConfig{
+			URL:            options.HTTPURL,
+			Format:         options.HTTPFormat,
+			Headers:        headers,
+			Gzip:           options.HTTPGzip,
+			BatchSize:      options.HTTPBatchSize,
+			Linger:         time.Duration(options.HTTPLinger) * time.Millisecond,
+			MaxRetries:     options.HTTPMaxRetries,
+			Backoff:        time.Duration(options.HTTPBackoff) * time.Millisecond,
+			DeadLetterFile: options.HTTPDeadLetterFile,
+			Client:         &http.Client{Timeout: time.Duration(options.HTTPTimeout) * time.Second},
+		}
+	} else if options.Output == constants.OutputHoneycomb {
+		fmt.Fprintln(os.Stderr, "Sending output to Honeycomb")
+		if options.WriteKey == "" || options.Dataset == "" {
+			log.Fatal("honeycomb output needs --writekey and --dataset")
+		}
+		// Honeycomb takes structured events only
+		options.Formatter = true
+		c.Honeycomb = publisher.HoneycombConfig{
+			WriteKey:       options.WriteKey,
+			Dataset:        options.Dataset,
+			APIHost:        options.APIHost,
+			SampleRate:     options.SampleRate,
+			BatchSize:      options.HTTPBatchSize,
+			MaxRetries:     options.HTTPMaxRetries,
+			Backoff:        time.Duration(options.HTTPBackoff) * time.Millisecond,
+			DeadLetterFile: options.HTTPDeadLetterFile,
+			Client:         &http.Client{Timeout: time.Duration(options.HTTPTimeout) * time.Second},
+		}
+	} else if options.Output == constants.OutputS3 {
+		fmt.Fprintln(os.Stderr, "Sending output to S3")
+		region := options.S3Region
+		if region == "" {
+			region = options.Region
+		}
+		c.S3Config = publisher.S3Config{
+			Bucket:      options.S3Bucket,
+			KeyTemplate: options.S3KeyTemplate,
+			Gzip:        options.S3Gzip,
+			Region:      region,
+			Endpoint:    options.S3Endpoint,
+			PartSize:    options.S3PartSize * 1024 * 1024,
+			BufferSize:  options.S3BufferSize * 1024 * 1024,
+			BufferAge:   time.Duration(options.S3BufferAge) * time.Second,
+		}
+		uploader, err := publisher.NewS3Uploader(c.S3Config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.S3 = uploader
 	} else {
 		log.Fatal("output target not recognized. use --help for usage info")
 	}
@@ -98,7 +249,13 @@ func main() {
 		err = c.Download()
 	} else {
 		fmt.Fprintln(os.Stderr, "Running in tail mode - streaming logs from RDS")
-		err = c.Stream()
+		err = c.StreamInstances()
+	}
+
+	if c.Kafka != nil {
+		if closeErr := c.Kafka.Close(); closeErr != nil {
+			log.WithError(closeErr).Error("Failed to close the kafka writer")
+		}
 	}
 
 	if err != nil {
@@ -159,19 +316,52 @@ func parseFlags() (*config.Options, error) {
 		}
 	}
 
+	// list options may be repeated and each may hold a comma separated list
+	options.InstanceIdentifiers = splitList(options.InstanceIdentifiers)
+	options.KafkaBrokers = splitList(options.KafkaBrokers)
+
+	logFiles, ok := constants.DefaultLogFiles[options.DBType]
+	if !ok {
+		log.Fatal(fmt.Sprintf("unsupported dbtype: `%s`", options.DBType))
+	}
+	defaultLogFile, ok := logFiles[options.LogType]
+	if !ok {
+		log.Fatal(fmt.Sprintf("unsupported log type `%s` for dbtype `%s`", options.LogType, options.DBType))
+	}
 	if options.LogFile == "" {
-		if options.DBType == constants.DBTypeMySQL {
-			options.LogFile = "slowquery/mysql-slowquery.log"
-		} else if options.DBType == constants.DBTypePostgreSQL {
-			options.LogFile = "error/postgresql.log"
-		} else {
-			log.Fatal(fmt.Sprintf("unsupported dbtype: `%s`", options.DBType))
+		options.LogFile = defaultLogFile
+	}
+
+	if (options.Discover || len(options.Clusters) > 0) && options.DiscoverInterval <= 0 {
+		return nil, fmt.Errorf("--discover_interval must be a positive number of seconds with --discover or --cluster")
+	}
+	// the members of a cluster are told apart by fields of the formatted events
+	if len(options.Clusters) > 0 {
+		options.Formatter = true
+	}
+
+	if options.DBType == constants.DBTypePostgreSQL {
+		if _, err := formatter.CompileLogLinePrefix(options.LogLinePrefix); err != nil {
+			return nil, err
 		}
 	}
 
 	return &options, nil
 }
 
+// splitList splits comma separated values of a repeatable option
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
 func awsCredsFailureMsg() string {
 	// check for AWS binary
 	_, err := exec.LookPath("aws")
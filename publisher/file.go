@@ -1,7 +1,6 @@
 package publisher
 
 import (
-	"log"
 	"os"
 	"path"
 	"strings"
@@ -12,9 +11,13 @@ type FILEPublisher struct {
 	FileName string
 	Path     *string
 	Suffix   *string
+
+	// currently open file and its name
+	file     *os.File
+	openName string
 }
 
-func (s *FILEPublisher) Write(line string) {
+func (s *FILEPublisher) Write(line string) error {
 	suffix := ""
 	if s.Suffix != nil && *s.Suffix != "" {
 		splitMarker := strings.Split(*s.Suffix, ":")
@@ -22,17 +25,42 @@ func (s *FILEPublisher) Write(line string) {
 	}
 
 	filename := *s.Path + suffix
-	if err := os.MkdirAll(path.Dir(filename), os.ModePerm); err != nil {
-		log.Fatal(err)
+	if s.file == nil || s.openName != filename {
+		if err := s.Close(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(filename), os.ModePerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		s.file = f
+		s.openName = filename
 	}
-	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
+
+	_, err := s.file.Write([]byte(line))
+	return err
+}
+
+// Flush syncs the open file to disk
+func (s *FILEPublisher) Flush() error {
+	if s.file == nil {
+		return nil
 	}
-	if _, err := f.Write([]byte(line)); err != nil {
-		log.Fatal(err)
+	return s.file.Sync()
+}
+
+// Close syncs and closes the open file
+func (s *FILEPublisher) Close() error {
+	if s.file == nil {
+		return nil
 	}
-	if err := f.Close(); err != nil {
-		log.Fatal(err)
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
 	}
+	s.file = nil
+	return err
 }
@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HoneycombConfig configures a HoneycombPublisher
+type HoneycombConfig struct {
+	WriteKey string
+	Dataset  string
+	// APIHost is the Honeycomb API server, e.g. https://api.honeycomb.io/
+	APIHost string
+	// SampleRate sends 1 in SampleRate events, recording the rate on each
+	// event so Honeycomb can weigh it. 0 and 1 send every event.
+	SampleRate uint
+	BatchSize  int
+	// MaxRetries, Backoff and Abort control the retries of failing batch
+	// requests as for HTTPConfig
+	MaxRetries int
+	Backoff    time.Duration
+	Abort      chan bool
+	// DeadLetterFile receives the events Honeycomb rejected for good as
+	// NDJSON; without it they are logged and dropped
+	DeadLetterFile string
+	Client         *http.Client
+}
+
+// honeycombEvent is one event of the Honeycomb batch API
+type honeycombEvent struct {
+	Data       json.RawMessage `json:"data"`
+	SampleRate uint            `json:"samplerate,omitempty"`
+	Time       string          `json:"time,omitempty"`
+}
+
+// honeycombStatus is the outcome of one event in a batch API response
+type honeycombStatus struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// HoneycombPublisher implements Publisher and sends every formatted event
+// (a JSON object such as formatter.JsonData) to a Honeycomb dataset through
+// the batch events API. Other lines are sent as {"message": line}.
+type HoneycombPublisher struct {
+	Config HoneycombConfig
+
+	batch *HTTPPublisher
+}
+
+func (h *HoneycombPublisher) Write(line string) error {
+	if h.Config.SampleRate > 1 && rand.Intn(int(h.Config.SampleRate)) != 0 {
+		return nil
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		fields = map[string]interface{}{"message": line}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	event := honeycombEvent{Data: data}
+	if h.Config.SampleRate > 1 {
+		event.SampleRate = h.Config.SampleRate
+	}
+	if t, ok := fields["Time"].(string); ok {
+		if _, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			event.Time = t
+		}
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.batcher().Write(string(encoded))
+}
+
+// Flush sends the buffered events
+func (h *HoneycombPublisher) Flush() error {
+	return h.batcher().Flush()
+}
+
+// Close sends the buffered events
+func (h *HoneycombPublisher) Close() error {
+	return h.batcher().Close()
+}
+
+func (h *HoneycombPublisher) batcher() *HTTPPublisher {
+	if h.batch == nil {
+		endpoint := strings.TrimSuffix(h.Config.APIHost, "/") + "/1/batch/" + url.PathEscape(h.Config.Dataset)
+		h.batch = &HTTPPublisher{Config: HTTPConfig{
+			URL:        endpoint,
+			Format:     HTTPFormatJSON,
+			Headers:    http.Header{"X-Honeycomb-Team": {h.Config.WriteKey}},
+			Gzip:       true,
+			BatchSize:  h.Config.BatchSize,
+			MaxRetries: h.Config.MaxRetries,
+			Backoff:    h.Config.Backoff,
+			Abort:      h.Config.Abort,
+			// the batch API accepts the request and reports rejected events
+			EventStatuses:  honeycombStatuses,
+			DeadLetterFile: h.Config.DeadLetterFile,
+			Client:         h.Config.Client,
+		}}
+	}
+	return h.batch
+}
+
+// honeycombStatuses returns the outcome of every event from a batch API
+// response
+func honeycombStatuses(body []byte) ([]EventStatus, error) {
+	var statuses []honeycombStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		return nil, fmt.Errorf("unexpected honeycomb batch response: %s", err)
+	}
+	outcomes := make([]EventStatus, len(statuses))
+	for i, status := range statuses {
+		outcomes[i] = EventStatus{Code: status.Status, Error: status.Error}
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,196 @@
+package publisher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// acceptEvents is the batch API response accepting every event of body
+func acceptEvents(body string) string {
+	var events []json.RawMessage
+	_ = json.Unmarshal([]byte(body), &events)
+	statuses := make([]string, len(events))
+	for i := range statuses {
+		statuses[i] = `{"status":202}`
+	}
+	return "[" + strings.Join(statuses, ",") + "]"
+}
+
+func TestHoneycombPublisherSendsBatches(t *testing.T) {
+	srv := &batchServer{reply: acceptEvents}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HoneycombPublisher{Config: HoneycombConfig{
+		WriteKey: "abcabc123123",
+		Dataset:  "rds logs",
+		APIHost:  ts.URL + "/",
+	}}
+	_ = h.Write(`{"Time":"2022-08-09T10:00:00.123456Z","Query":"select 1","QueryTime":1.5}` + "\n")
+	_ = h.Write("DATA: not structured\n")
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.requests) != 1 {
+		t.Fatalf("expected one batch request, got %d", len(srv.requests))
+	}
+	r := srv.requests[0]
+	if r.URL.Path != "/1/batch/rds logs" || r.Header.Get("X-Honeycomb-Team") != "abcabc123123" {
+		t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+	}
+
+	var events []struct {
+		Data map[string]interface{} `json:"data"`
+		Time string                 `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(srv.bodies[0]), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if events[0].Data["Query"] != "select 1" || events[0].Data["QueryTime"] != 1.5 || events[0].Time != "2022-08-09T10:00:00.123456Z" {
+		t.Errorf("unexpected first event %+v", events[0])
+	}
+	if events[1].Data["message"] != "DATA: not structured" {
+		t.Errorf("unexpected second event %+v", events[1])
+	}
+}
+
+func TestHoneycombPublisherSamples(t *testing.T) {
+	srv := &batchServer{reply: acceptEvents}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HoneycombPublisher{Config: HoneycombConfig{
+		WriteKey:   "key",
+		Dataset:    "rds",
+		APIHost:    ts.URL,
+		SampleRate: 10,
+	}}
+	for i := 0; i < 1000; i++ {
+		_ = h.Write(`{"Query":"select 1"}`)
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []struct {
+		SampleRate uint `json:"samplerate"`
+	}
+	if len(srv.bodies) != 1 {
+		t.Fatalf("expected one batch, got %d", len(srv.bodies))
+	}
+	if err := json.Unmarshal([]byte(srv.bodies[0]), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 50 || len(events) > 200 {
+		t.Errorf("expected about 100 of 1000 events at sample rate 10, got %d", len(events))
+	}
+	if events[0].SampleRate != 10 {
+		t.Errorf("expected the sample rate on the event, got %d", events[0].SampleRate)
+	}
+}
+
+// honeycombQueries returns the Query fields of the events of a batch body
+func honeycombQueries(t *testing.T, body string) []string {
+	t.Helper()
+	var events []struct {
+		Data struct{ Query string } `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &events); err != nil {
+		t.Fatal(err)
+	}
+	var queries []string
+	for _, event := range events {
+		queries = append(queries, event.Data.Query)
+	}
+	return queries
+}
+
+func TestHoneycombPublisherHandlesRejectedEvents(t *testing.T) {
+	srv := &batchServer{}
+	srv.reply = func(body string) string {
+		if len(srv.bodies) == 1 {
+			return `[{"status":202},{"status":400,"error":"request body is malformed"},{"status":503}]`
+		}
+		return acceptEvents(body)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dlq := path.Join(t.TempDir(), "rejected.ndjson")
+	h := &HoneycombPublisher{Config: HoneycombConfig{WriteKey: "key", Dataset: "rds", APIHost: ts.URL, MaxRetries: 3, DeadLetterFile: dlq}}
+	_ = h.Write(`{"Query":"select 1"}`)
+	_ = h.Write(`{"Query":"select 2"}`)
+	_ = h.Write(`{"Query":"select 3"}`)
+	if err := h.Flush(); err != nil {
+		t.Fatalf("expected the partly accepted batch to succeed, got %v", err)
+	}
+
+	// only the event rejected with 503 is sent again
+	if len(srv.bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(srv.bodies))
+	}
+	if queries := honeycombQueries(t, srv.bodies[1]); len(queries) != 1 || queries[0] != "select 3" {
+		t.Errorf("expected only the unavailable event to be retried, got %v", queries)
+	}
+	data, err := os.ReadFile(dlq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "select 2") {
+		t.Errorf("expected the malformed event in the dead letter file, got %q", data)
+	}
+
+	// nothing is left for the next flush
+	if err := h.Flush(); err != nil || len(srv.bodies) != 2 {
+		t.Errorf("expected nothing to send, got %v after %d requests", err, len(srv.bodies))
+	}
+}
+
+func TestHoneycombPublisherDropsRejectedEventsWithoutDeadLetterFile(t *testing.T) {
+	srv := &batchServer{reply: func(string) string {
+		return `[{"status":202},{"status":400,"error":"request body is malformed"}]`
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HoneycombPublisher{Config: HoneycombConfig{WriteKey: "key", Dataset: "rds", APIHost: ts.URL, MaxRetries: 3}}
+	_ = h.Write(`{"Query":"select 1"}`)
+	_ = h.Write(`{"Query":"select 2"}`)
+	if err := h.Flush(); err != nil {
+		t.Errorf("expected the rejected event to be dropped, got %v", err)
+	}
+	if len(srv.requests) != 1 {
+		t.Errorf("events rejected for good must not be retried, got %d requests", len(srv.requests))
+	}
+}
+
+func TestHoneycombPublisherRetries(t *testing.T) {
+	srv := &batchServer{
+		statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		reply:    acceptEvents,
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HoneycombPublisher{Config: HoneycombConfig{WriteKey: "key", Dataset: "rds", APIHost: ts.URL, MaxRetries: 1}}
+	_ = h.Write(`{"Query":"select 1"}`)
+	if err := h.Flush(); err == nil {
+		t.Fatal("expected the flush to fail after one retry")
+	}
+	if len(srv.requests) != 2 {
+		t.Errorf("expected 2 requests, got %d", len(srv.requests))
+	}
+	// the events stay buffered for the next flush
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,441 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTP batch formats
+const (
+	HTTPFormatNDJSON = "ndjson"
+	HTTPFormatJSON   = "json"
+)
+
+// deadLetterMu serializes appends to dead letter files of all publishers
+var deadLetterMu sync.Mutex
+
+// HTTPConfig configures an HTTPPublisher
+type HTTPConfig struct {
+	URL string
+	// Format is ndjson (one event per line) or json (one array per batch)
+	Format  string
+	Headers http.Header
+	Gzip    bool
+	// BatchSize is the maximum number of events per request
+	BatchSize int
+	// Linger is how long a partial batch waits for more events before it is
+	// sent in the background. 0 waits for Flush.
+	Linger time.Duration
+	// MaxRetries and Backoff control the retries of requests failing with
+	// a network error, 429 or 5xx status.
+	MaxRetries int
+	Backoff    time.Duration
+	// Abort ends the retries of a failing request when it is closed
+	Abort chan bool
+	// EventStatuses, if set, returns the outcome of every event of a batch
+	// from the body of a 2xx response, for APIs accepting batches in part.
+	// Events failing with 429 or 5xx are retried on their own; the others
+	// failed for good and are written to DeadLetterFile, or logged and
+	// dropped, without failing the batch.
+	EventStatuses func(body []byte) ([]EventStatus, error)
+	// DeadLetterFile receives the batches and events which failed for good
+	// as NDJSON. Without it a failed batch is returned by Flush.
+	DeadLetterFile string
+	Client         *http.Client
+}
+
+// EventStatus is the outcome of one event of a batch
+type EventStatus struct {
+	Code  int
+	Error string
+}
+
+// ParseHTTPHeaders parses "Name: value" header options
+func ParseHTTPHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid http header `%s`, expected `Name: value`", header)
+		}
+		parsed.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return parsed, nil
+}
+
+// HTTPPublisher implements Publisher and POSTs batches of events to an HTTP
+// endpoint. Lines which aren't JSON are sent as JSON strings. Full batches are
+// sent right away; with Linger, a partial batch is sent once its oldest event
+// waited for Linger, by Flush or in the background, and Unpublished reports
+// the marker of the oldest event not sent yet. Without Linger Flush sends
+// every buffered event. Events count as sent once accepted by the endpoint or
+// written to the dead letter file.
+type HTTPPublisher struct {
+	Config HTTPConfig
+
+	// sendMu serializes the sends. They don't hold mu, so events can be
+	// buffered while a request is retried.
+	sendMu  sync.Mutex
+	mu      sync.Mutex
+	pending []json.RawMessage
+	// marks are the sources of the pending events in order
+	marks   []httpMark
+	started time.Time
+	timer   *time.Timer
+}
+
+// httpMark records that the pending events from index on were read from
+// the data starting at marker from
+type httpMark struct {
+	index int
+	from  string
+}
+
+// SetSource records the start marker of the events written next
+func (h *HTTPPublisher) SetSource(src Source) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// without events of the last source, its earlier marker covers src too
+	if n := len(h.marks); n > 0 && h.marks[n-1].index == len(h.pending) {
+		return
+	}
+	h.marks = append(h.marks, httpMark{index: len(h.pending), from: src.From})
+}
+
+// Unpublished returns the start marker of the source of the oldest event
+// not sent yet. It is empty for events written without a source.
+func (h *HTTPPublisher) Unpublished() (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.pending) == 0 {
+		return "", false
+	}
+	if len(h.marks) == 0 || h.marks[0].index > 0 {
+		return "", true
+	}
+	return h.marks[0].from, true
+}
+
+func (h *HTTPPublisher) Write(line string) error {
+	line = strings.TrimSuffix(line, "\n")
+	event := json.RawMessage(line)
+	if !json.Valid(event) {
+		event, _ = json.Marshal(line)
+	}
+
+	h.mu.Lock()
+	if len(h.pending) == 0 {
+		h.started = time.Now()
+	}
+	h.pending = append(h.pending, event)
+	full := h.Config.BatchSize > 0 && len(h.pending) >= h.Config.BatchSize
+	if h.Config.Linger > 0 && h.timer == nil {
+		h.timer = time.AfterFunc(h.Config.Linger, h.lingerFlush)
+	}
+	h.mu.Unlock()
+
+	if full {
+		return h.send(false)
+	}
+	return nil
+}
+
+// Flush sends the full batches, and the partial one once its oldest event
+// waited for Linger
+func (h *HTTPPublisher) Flush() error {
+	h.mu.Lock()
+	all := h.Config.Linger <= 0 || time.Since(h.started) >= h.Config.Linger
+	h.mu.Unlock()
+	return h.send(all)
+}
+
+// Close sends every buffered event and stops the linger timer
+func (h *HTTPPublisher) Close() error {
+	return h.send(true)
+}
+
+// lingerFlush sends a partial batch in the background. Failed events stay
+// buffered for the next Flush.
+func (h *HTTPPublisher) lingerFlush() {
+	h.mu.Lock()
+	h.timer = nil
+	h.mu.Unlock()
+	if err := h.send(true); err != nil {
+		log.WithError(err).Warn("Failed to send lingering http batch")
+	}
+}
+
+// send sends the events buffered when it is called in batches of BatchSize,
+// and with all the last partial batch too. A batch stays at the front of the
+// buffer until it is sent, so a failed one goes out first with the next
+// send.
+func (h *HTTPPublisher) send(all bool) error {
+	h.sendMu.Lock()
+	defer h.sendMu.Unlock()
+
+	h.mu.Lock()
+	if all && h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	remaining := len(h.pending)
+	h.mu.Unlock()
+
+	for remaining > 0 {
+		n := remaining
+		if h.Config.BatchSize > 0 && n > h.Config.BatchSize {
+			n = h.Config.BatchSize
+		}
+		if !all && (h.Config.BatchSize <= 0 || n < h.Config.BatchSize) {
+			break
+		}
+		// only send removes events, so the front can be read unlocked
+		h.mu.Lock()
+		batch := h.pending[:n:n]
+		h.mu.Unlock()
+
+		if err := h.post(batch); err != nil {
+			if h.Config.DeadLetterFile == "" {
+				return err
+			}
+			if err := h.drop(batch, err); err != nil {
+				return err
+			}
+		}
+
+		h.mu.Lock()
+		h.trim(n)
+		h.mu.Unlock()
+		remaining -= n
+	}
+	return nil
+}
+
+// trim removes the first n pending events, which have been sent, and their
+// marks. h.mu must be held.
+func (h *HTTPPublisher) trim(n int) {
+	h.pending = h.pending[n:]
+	if len(h.pending) == 0 {
+		h.pending = nil
+		h.marks = nil
+		return
+	}
+	h.started = time.Now()
+	// the last mark at or before the new front covers it
+	first := 0
+	for i, mark := range h.marks {
+		if mark.index <= n {
+			first = i
+		}
+	}
+	h.marks = append([]httpMark(nil), h.marks[first:]...)
+	for i := range h.marks {
+		h.marks[i].index -= n
+		if h.marks[i].index < 0 {
+			h.marks[i].index = 0
+		}
+	}
+}
+
+// post sends one batch, retrying network errors, 429 and 5xx responses, and
+// with EventStatuses the events of an accepted batch which failed that way
+func (h *HTTPPublisher) post(batch []json.RawMessage) error {
+	client := h.Config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		body, err := h.encode(batch)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, h.Config.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range h.Config.Headers {
+			req.Header[name] = values
+		}
+		if h.Config.Format == HTTPFormatJSON {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "application/x-ndjson")
+		}
+		if h.Config.Gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+
+		retryAfter := time.Duration(0)
+		resp, err := client.Do(req)
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode < 300 {
+				if h.Config.EventStatuses == nil {
+					return nil
+				}
+				var retry []json.RawMessage
+				retry, err = h.checkEvents(batch, data, attempt >= h.Config.MaxRetries)
+				if err != nil || len(retry) == 0 {
+					return err
+				}
+				err = fmt.Errorf("%s failed %d of %d events", h.Config.URL, len(retry), len(batch))
+				batch = retry
+			} else if err == nil {
+				err = fmt.Errorf("%s responded with %s", h.Config.URL, resp.Status)
+				if !retryableStatus(resp.StatusCode) {
+					return err
+				}
+				if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+					retryAfter = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+
+		if attempt >= h.Config.MaxRetries {
+			return err
+		}
+		delay := h.Config.Backoff << uint(attempt)
+		if delay > 0 {
+			delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		log.WithError(err).Warnf("http publish failed; retrying in %s", delay)
+		select {
+		case <-h.Config.Abort:
+			return fmt.Errorf("%s, retries aborted", err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// checkEvents returns the events of an accepted batch to retry. The events
+// which failed for good, and with last the ones to retry too, are dropped.
+func (h *HTTPPublisher) checkEvents(batch []json.RawMessage, body []byte, last bool) ([]json.RawMessage, error) {
+	statuses, err := h.Config.EventStatuses(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) != len(batch) {
+		return nil, fmt.Errorf("%s returned %d statuses for %d events", h.Config.URL, len(statuses), len(batch))
+	}
+
+	var retry, failed []json.RawMessage
+	var failure EventStatus
+	for i, status := range statuses {
+		switch {
+		case status.Code >= 200 && status.Code < 300:
+			continue
+		case retryableStatus(status.Code) && !last:
+			retry = append(retry, batch[i])
+			continue
+		}
+		if len(failed) == 0 {
+			failure = status
+		}
+		failed = append(failed, batch[i])
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("%s failed %d of %d events: %d %s", h.Config.URL, len(failed), len(batch), failure.Code, failure.Error)
+		if err := h.drop(failed, err); err != nil {
+			return nil, err
+		}
+	}
+	return retry, nil
+}
+
+// drop writes events which failed for good with err to the dead letter
+// file, or logs and drops them without one
+func (h *HTTPPublisher) drop(events []json.RawMessage, err error) error {
+	if h.Config.DeadLetterFile == "" {
+		log.WithError(err).Errorf("Dropping %d events", len(events))
+		return nil
+	}
+	log.WithError(err).Errorf("Failed to send %d events; writing them to %s", len(events), h.Config.DeadLetterFile)
+	if dlqErr := h.deadLetter(events); dlqErr != nil {
+		return fmt.Errorf("%s (dead letter file: %s)", err, dlqErr)
+	}
+	return nil
+}
+
+// retryableStatus reports whether a request or event failing with the HTTP
+// status code may succeed when retried
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// encode returns the request body of batch
+func (h *HTTPPublisher) encode(batch []json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if h.Config.Gzip {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	if h.Config.Format == HTTPFormatJSON {
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, event := range batch {
+			if _, err := fmt.Fprintf(w, "%s\n", event); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// deadLetter appends batch to the dead letter file as NDJSON
+func (h *HTTPPublisher) deadLetter(batch []json.RawMessage) error {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	if err := os.MkdirAll(path.Dir(h.Config.DeadLetterFile), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.Config.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, event := range batch {
+		if _, err := fmt.Fprintf(f, "%s\n", event); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,254 @@
+package publisher
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchServer records the request bodies it accepts and answers the first
+// requests with the queued status codes. reply, if set, returns the body of
+// the responses accepting a request.
+type batchServer struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	requests []*http.Request
+	reply    func(body string) string
+}
+
+func (b *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, r)
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	data, _ := io.ReadAll(body)
+
+	if len(b.statuses) > 0 {
+		status := b.statuses[0]
+		b.statuses = b.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	b.bodies = append(b.bodies, string(data))
+	if b.reply != nil {
+		io.WriteString(w, b.reply(string(data)))
+	}
+}
+
+// requestCount returns the number of requests received so far
+func (b *batchServer) requestCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.requests)
+}
+
+func TestHTTPPublisherRetriesAndBatches(t *testing.T) {
+	srv := &batchServer{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HTTPPublisher{Config: HTTPConfig{
+		URL:        ts.URL,
+		Format:     HTTPFormatNDJSON,
+		Headers:    http.Header{"X-Api-Key": {"secret"}},
+		Gzip:       true,
+		BatchSize:  2,
+		MaxRetries: 3,
+	}}
+	for _, line := range []string{`{"Query":"select 1"}` + "\n", "raw line\n", `{"Query":"select 2"}` + "\n"} {
+		if err := h.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"{\"Query\":\"select 1\"}\n\"raw line\"\n",
+		"{\"Query\":\"select 2\"}\n",
+	}
+	if len(srv.bodies) != len(want) {
+		t.Fatalf("expected %d accepted batches, got %q", len(want), srv.bodies)
+	}
+	for i := range want {
+		if srv.bodies[i] != want[i] {
+			t.Errorf("batch %d: expected %q, got %q", i, want[i], srv.bodies[i])
+		}
+	}
+	if len(srv.requests) != 4 {
+		t.Errorf("expected 2 retries, got %d requests", len(srv.requests))
+	}
+	for _, r := range srv.requests {
+		if r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+	}
+}
+
+func TestHTTPPublisherWritesFailedBatchesToDeadLetterFile(t *testing.T) {
+	srv := &batchServer{statuses: []int{http.StatusBadRequest}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dlq := path.Join(t.TempDir(), "failed", "batches.ndjson")
+	h := &HTTPPublisher{Config: HTTPConfig{
+		URL:            ts.URL,
+		Format:         HTTPFormatJSON,
+		MaxRetries:     3,
+		DeadLetterFile: dlq,
+	}}
+	_ = h.Write(`{"Query":"select 1"}` + "\n")
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 1 {
+		t.Errorf("4xx responses must not be retried, got %d requests", len(srv.requests))
+	}
+	data, err := os.ReadFile(dlq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\"Query\":\"select 1\"}\n" {
+		t.Errorf("unexpected dead letter file %q", data)
+	}
+
+	// accepted JSON batches are arrays
+	_ = h.Write(`{"Query":"select 2"}` + "\n")
+	_ = h.Write(`{"Query":"select 3"}` + "\n")
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.bodies) != 1 || srv.bodies[0] != `[{"Query":"select 2"},{"Query":"select 3"}]` {
+		t.Errorf("unexpected bodies %q", srv.bodies)
+	}
+}
+
+func TestHTTPPublisherReportsFailureWithoutDeadLetterFile(t *testing.T) {
+	srv := &batchServer{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HTTPPublisher{Config: HTTPConfig{URL: ts.URL, MaxRetries: 1}}
+	_ = h.Write("line\n")
+	if err := h.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	// the events stay buffered and go out with the next flush
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.bodies) != 1 || srv.bodies[0] != "\"line\"\n" {
+		t.Errorf("unexpected bodies %q", srv.bodies)
+	}
+}
+
+func TestHTTPPublisherBacksOffWithoutBlockingWriters(t *testing.T) {
+	srv := &batchServer{statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	abort := make(chan bool)
+	h := &HTTPPublisher{Config: HTTPConfig{URL: ts.URL, MaxRetries: 5, Backoff: time.Hour, Abort: abort}}
+	_ = h.Write("first\n")
+	flushed := make(chan error, 1)
+	go func() { flushed <- h.Flush() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.requestCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// events are buffered while the failed request waits for its retry
+	written := make(chan error, 1)
+	go func() { written <- h.Write("second\n") }()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the write was blocked by the backoff")
+	}
+
+	close(abort)
+	select {
+	case err := <-flushed:
+		if err == nil || !strings.Contains(err.Error(), "retries aborted") {
+			t.Errorf("expected the aborted retries, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("abort didn't end the backoff")
+	}
+
+	// both events go out with the next flush, in order
+	h.Config.Abort = nil
+	h.Config.Backoff = 0
+	if err := h.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.bodies) != 1 || srv.bodies[0] != "\"first\"\n\"second\"\n" {
+		t.Errorf("unexpected bodies %q", srv.bodies)
+	}
+}
+
+func TestHTTPPublisherLingersPartialBatches(t *testing.T) {
+	srv := &batchServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	h := &HTTPPublisher{Config: HTTPConfig{URL: ts.URL, BatchSize: 3, Linger: 100 * time.Millisecond}}
+	for i, from := range []string{"10:0", "10:10", "10:20", "10:30"} {
+		h.SetSource(Source{From: from})
+		_ = h.Write(`{"Query":"select ` + string(rune('1'+i)) + `"}`)
+		if i == 1 {
+			if err := h.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if n := srv.requestCount(); n != 0 {
+				t.Fatalf("expected the partial batch to linger, got %d requests", n)
+			}
+			if from, ok := h.Unpublished(); !ok || from != "10:0" {
+				t.Errorf("expected the first source unpublished, got %q %v", from, ok)
+			}
+		}
+	}
+
+	// the full batch goes out right away, the fourth event lingers
+	if n := srv.requestCount(); n != 1 {
+		t.Fatalf("expected the full batch sent, got %d requests", n)
+	}
+	if from, ok := h.Unpublished(); !ok || from != "10:30" {
+		t.Errorf("expected the source of the fourth event unpublished, got %q %v", from, ok)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.requestCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := srv.requestCount(); n != 2 {
+		t.Fatalf("expected the lingering event sent in the background, got %d requests", n)
+	}
+	if _, ok := h.Unpublished(); ok {
+		t.Error("expected every event to be sent")
+	}
+	if srv.bodies[1] != "{\"Query\":\"select 4\"}\n" {
+		t.Errorf("unexpected lingering batch %q", srv.bodies[1])
+	}
+}
@@ -0,0 +1,219 @@
+package publisher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Kafka partition key choices
+const (
+	KafkaKeyInstance = "instance"
+	KafkaKeyDatabase = "database"
+	KafkaKeyUser     = "user"
+)
+
+// KafkaWriter is the part of *kafka.Writer used by KafkaPublisher
+type KafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaConfig configures the writer created by NewKafkaWriter
+type KafkaConfig struct {
+	Brokers     []string
+	Topic       string
+	BatchSize   int
+	Linger      time.Duration
+	Compression string
+	// Acks is all, one or none. With none, markers are committed without
+	// waiting for the brokers.
+	Acks string
+
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSkipVerify bool
+
+	// SASLMechanism is plain, scram-sha-256 or scram-sha-512; empty disables
+	// SASL.
+	SASLMechanism string
+	SASLUser      string
+	SASLPassword  string
+}
+
+// NewKafkaWriter returns a synchronous writer producing to cfg.Topic.
+// Messages are spread over the partitions by the hash of their key.
+func NewKafkaWriter(cfg KafkaConfig) (*kafka.Writer, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka output needs --kafka_brokers and --kafka_topic")
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: cfg.Linger,
+	}
+
+	switch cfg.Acks {
+	case "all", "":
+		w.RequiredAcks = kafka.RequireAll
+	case "one":
+		w.RequiredAcks = kafka.RequireOne
+	case "none":
+		w.RequiredAcks = kafka.RequireNone
+	default:
+		return nil, fmt.Errorf("unsupported kafka acks: `%s`", cfg.Acks)
+	}
+
+	switch cfg.Compression {
+	case "none", "":
+	case "gzip":
+		w.Compression = kafka.Gzip
+	case "snappy":
+		w.Compression = kafka.Snappy
+	case "lz4":
+		w.Compression = kafka.Lz4
+	case "zstd":
+		w.Compression = kafka.Zstd
+	default:
+		return nil, fmt.Errorf("unsupported kafka compression: `%s`", cfg.Compression)
+	}
+
+	transport := &kafka.Transport{}
+	if cfg.TLS {
+		tlsConfig, err := kafkaTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsConfig
+	}
+	if cfg.SASLMechanism != "" {
+		mechanism, err := kafkaSASLMechanism(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	w.Transport = transport
+
+	return w, nil
+}
+
+func kafkaTLSConfig(cfg KafkaConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}
+	if cfg.TLSCAFile != "" {
+		ca, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+	}
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func kafkaSASLMechanism(cfg KafkaConfig) (sasl.Mechanism, error) {
+	switch cfg.SASLMechanism {
+	case "plain":
+		return plain.Mechanism{Username: cfg.SASLUser, Password: cfg.SASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.SASLUser, cfg.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.SASLUser, cfg.SASLPassword)
+	}
+	return nil, fmt.Errorf("unsupported kafka sasl mechanism: `%s`", cfg.SASLMechanism)
+}
+
+// KafkaPublisher implements Publisher and produces every written line as a
+// Kafka message. Lines are buffered and sent in batches of BatchSize, and
+// Flush only returns once the brokers acknowledged every buffered message.
+// The Writer may be shared by several publishers and is not closed by Close.
+type KafkaPublisher struct {
+	Writer    KafkaWriter
+	BatchSize int
+	// Key picks the partition key of a line, see KafkaKey
+	Key func(line string) []byte
+
+	pending []kafka.Message
+}
+
+func (k *KafkaPublisher) Write(line string) error {
+	line = strings.TrimSuffix(line, "\n")
+	msg := kafka.Message{Value: []byte(line)}
+	if k.Key != nil {
+		msg.Key = k.Key(line)
+	}
+	k.pending = append(k.pending, msg)
+
+	if k.BatchSize > 0 && len(k.pending) >= k.BatchSize {
+		return k.Flush()
+	}
+	return nil
+}
+
+// Flush sends the buffered messages and waits for their acknowledgement
+func (k *KafkaPublisher) Flush() error {
+	if len(k.pending) == 0 {
+		return nil
+	}
+	if err := k.Writer.WriteMessages(context.Background(), k.pending...); err != nil {
+		return err
+	}
+	k.pending = k.pending[:0]
+	return nil
+}
+
+// Close sends the buffered messages
+func (k *KafkaPublisher) Close() error {
+	return k.Flush()
+}
+
+// KafkaKey returns a Key func for the partition key choice: the instance,
+// or the DatabaseName or User field of formatted events. Lines without the
+// field are keyed by instance.
+func KafkaKey(choice string, instance string) (func(line string) []byte, error) {
+	field := ""
+	switch choice {
+	case KafkaKeyInstance, "":
+		return func(string) []byte { return []byte(instance) }, nil
+	case KafkaKeyDatabase:
+		field = "DatabaseName"
+	case KafkaKeyUser:
+		field = "User"
+	default:
+		return nil, fmt.Errorf("unsupported kafka partition key: `%s`", choice)
+	}
+
+	return func(line string) []byte {
+		var event map[string]interface{}
+		if json.Unmarshal([]byte(line), &event) == nil {
+			if value, ok := event[field].(string); ok && value != "" {
+				return []byte(value)
+			}
+		}
+		return []byte(instance)
+	}, nil
+}
@@ -0,0 +1,115 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafka records the acknowledged messages, or fails while err is set
+type fakeKafka struct {
+	batches [][]kafka.Message
+	err     error
+}
+
+func (f *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, append([]kafka.Message(nil), msgs...))
+	return nil
+}
+
+func (f *fakeKafka) Close() error {
+	return nil
+}
+
+func TestKafkaPublisherBatchesUntilFlush(t *testing.T) {
+	writer := &fakeKafka{}
+	key, err := KafkaKey(KafkaKeyDatabase, "db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &KafkaPublisher{Writer: writer, BatchSize: 2, Key: key}
+
+	for _, line := range []string{`{"DatabaseName":"shop"}` + "\n", `{"User":"app"}` + "\n", "raw\n"} {
+		if err := k.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(writer.batches) != 1 || len(writer.batches[0]) != 2 {
+		t.Fatalf("expected one full batch before the flush, got %v", writer.batches)
+	}
+	if err := k.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.batches) != 2 {
+		t.Fatalf("expected the flush to send the rest, got %v", writer.batches)
+	}
+
+	for i, want := range []struct{ key, value string }{
+		{"shop", `{"DatabaseName":"shop"}`},
+		{"db1", `{"User":"app"}`},
+		{"db1", "raw"},
+	} {
+		msg := append(writer.batches[0], writer.batches[1]...)[i]
+		if string(msg.Key) != want.key || string(msg.Value) != want.value {
+			t.Errorf("message %d: expected %s=%s, got %s=%s", i, want.key, want.value, msg.Key, msg.Value)
+		}
+	}
+}
+
+func TestKafkaPublisherReportsUnacknowledgedMessages(t *testing.T) {
+	writer := &fakeKafka{err: errors.New("not enough replicas")}
+	k := &KafkaPublisher{Writer: writer}
+	if err := k.Write("line\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+
+	// the messages stay buffered until the brokers take them
+	writer.err = nil
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.batches) != 1 || string(writer.batches[0][0].Value) != "line" {
+		t.Errorf("expected the buffered message to be sent, got %v", writer.batches)
+	}
+}
+
+func TestNewKafkaWriterValidatesOptions(t *testing.T) {
+	for _, cfg := range []KafkaConfig{
+		{Topic: "rds"},
+		{Brokers: []string{"localhost:9092"}, Topic: "rds", Acks: "some"},
+		{Brokers: []string{"localhost:9092"}, Topic: "rds", Compression: "brotli"},
+		{Brokers: []string{"localhost:9092"}, Topic: "rds", SASLMechanism: "kerberos"},
+	} {
+		if _, err := NewKafkaWriter(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+
+	w, err := NewKafkaWriter(KafkaConfig{
+		Brokers:       []string{"localhost:9092"},
+		Topic:         "rds",
+		Compression:   "zstd",
+		TLS:           true,
+		SASLMechanism: "scram-sha-512",
+		SASLUser:      "rdslogs",
+		SASLPassword:  "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.RequiredAcks != kafka.RequireAll {
+		t.Errorf("expected acks to default to all, got %v", w.RequiredAcks)
+	}
+	transport := w.Transport.(*kafka.Transport)
+	if transport.TLS == nil || transport.SASL == nil || transport.SASL.Name() != "SCRAM-SHA-512" {
+		t.Errorf("unexpected transport %+v", transport)
+	}
+}
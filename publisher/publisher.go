@@ -4,5 +4,10 @@ package publisher
 // Current implementations are STDOUT and file
 type Publisher interface {
 	// Write accepts a long blob of text and writes it to the target
-	Write(blob string)
+	Write(blob string) error
+	// Flush returns once everything written so far is durably published.
+	// Markers are only committed after a successful Flush.
+	Flush() error
+	// Close flushes and releases the resources held by the publisher
+	Close() error
 }
@@ -0,0 +1,344 @@
+package publisher
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// DefaultS3KeyTemplate is the object key template used without
+// --s3_key_template
+const DefaultS3KeyTemplate = "{instance}/{logtype}/{date}/{hour}/{file}.{from}-{to}"
+
+// S3Uploader is the part of *s3manager.Uploader used by S3Publisher
+type S3Uploader interface {
+	Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+// S3Config configures the uploader created by NewS3Uploader and the
+// S3Publishers sharing it
+type S3Config struct {
+	Bucket string
+	// KeyTemplate is the object key with the placeholders {instance},
+	// {logtype} (e.g. slowquery), {file} (e.g. mysql-slowquery.log.3),
+	// {date} (YYYY-MM-DD), {hour} (HH), {from} and {to} (the RDS markers).
+	KeyTemplate string
+	Gzip        bool
+	Region      string
+	// Endpoint overrides the S3 endpoint, e.g. http://localhost:9000 for a
+	// MinIO server. Such endpoints are addressed path style.
+	Endpoint string
+	// PartSize is the size of the parts of multipart uploads, at least 5MB
+	PartSize int64
+	// BufferSize and BufferAge make a streaming S3Publisher collect the data
+	// of several Flushes in one object, uploaded once that many bytes are
+	// buffered or the oldest of them is that old. Zero uploads every Flush.
+	BufferSize int64
+	BufferAge  time.Duration
+}
+
+// NewS3Uploader returns an uploader for cfg. Objects larger than PartSize
+// are sent as multipart uploads.
+func NewS3Uploader(cfg S3Config) (*s3manager.Uploader, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 output needs --s3_bucket")
+	}
+
+	awsConfig := &aws.Config{Region: aws.String(cfg.Region)}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		if cfg.PartSize > u.PartSize {
+			u.PartSize = cfg.PartSize
+		}
+	}), nil
+}
+
+// Source describes where the data given to a publisher comes from
+type Source struct {
+	Instance string
+	LogFile  string
+	// LastWritten of the log file in ms since the epoch, as last described
+	// by RDS. 0 when unknown.
+	LastWritten int64
+	// From and To are the RDS markers of the start and end of the data
+	From string
+	To   string
+}
+
+// SourcePublisher is implemented by publishers that record where their data
+// comes from. SetSource is called before every portion of a log file is
+// written.
+type SourcePublisher interface {
+	SetSource(src Source)
+}
+
+// BufferingPublisher is implemented by publishers whose Flush may keep data
+// back to publish it in bigger pieces. Unpublished returns the Source.From
+// marker of the oldest data kept back, and false when everything written is
+// published; markers are only committed up to that data.
+type BufferingPublisher interface {
+	Unpublished() (string, bool)
+}
+
+// S3Publisher implements Publisher and archives the written data as S3
+// objects. A Flush uploads the data written since the last upload once
+// Config.BufferSize or Config.BufferAge is reached, and data which doesn't
+// continue the buffered data starts a new object. With PerFile, Flush does
+// nothing and Close uploads everything written as one object, which is how
+// whole log files are archived in download mode. The data is buffered in a
+// temporary file and the object metadata carries the instance, log file,
+// marker range and LastWritten of the data.
+type S3Publisher struct {
+	Uploader S3Uploader
+	Config   S3Config
+	PerFile  bool
+
+	src Source
+	// next is the source of the data written after the buffered data is
+	// uploaded, when it doesn't continue it
+	next     *Source
+	buffer   *os.File
+	gz       *gzip.Writer
+	buffered int64
+	started  time.Time
+	// now allows fixing the upload time for tests
+	now func() time.Time
+}
+
+// SetSource records src, keeping the start marker of data buffered already
+// when src continues it. src may start before the end of the buffered data
+// when it completes an entry the formatter kept back.
+func (s *S3Publisher) SetSource(src Source) {
+	if s.next != nil {
+		src.From = earlierMarker(s.next.From, src.From)
+		s.next = &src
+		return
+	}
+	if s.buffer == nil {
+		s.src = src
+		return
+	}
+	if s.PerFile {
+		src.From = s.src.From
+		s.src = src
+		return
+	}
+	// a rotated log file starts a new object, so the committed marker never
+	// points in to a file before the current one
+	hour := markerHour(s.src.To)
+	from, fromOK := markerOffset(src.From)
+	to, toOK := markerOffset(s.src.To)
+	if src.LogFile == s.src.LogFile && markerHour(src.From) == hour && markerHour(src.To) == hour && fromOK && toOK && from <= to {
+		src.From = earlierMarker(s.src.From, src.From)
+		s.src = src
+		return
+	}
+	s.next = &src
+}
+
+// Unpublished returns the start marker of the buffered data
+func (s *S3Publisher) Unpublished() (string, bool) {
+	if s.buffer == nil {
+		return "", false
+	}
+	return s.src.From, true
+}
+
+func (s *S3Publisher) Write(line string) error {
+	if err := s.startNext(); err != nil {
+		return err
+	}
+	if s.buffer == nil {
+		f, err := os.CreateTemp("", "rdslogs-s3-")
+		if err != nil {
+			return err
+		}
+		s.buffer = f
+		s.started = s.clock()
+		if s.Config.Gzip {
+			s.gz = gzip.NewWriter(f)
+		}
+	}
+
+	var w io.Writer = s.buffer
+	if s.gz != nil {
+		w = s.gz
+	}
+	n, err := io.WriteString(w, line)
+	s.buffered += int64(n)
+	return err
+}
+
+// Flush uploads the buffered data once it is big or old enough, unless
+// PerFile
+func (s *S3Publisher) Flush() error {
+	if s.PerFile {
+		return nil
+	}
+	if err := s.startNext(); err != nil {
+		return err
+	}
+	if s.buffer == nil || s.buffered < s.Config.BufferSize && s.clock().Sub(s.started) < s.Config.BufferAge {
+		return nil
+	}
+	return s.upload()
+}
+
+// startNext uploads the buffered data before data of another source is
+// written
+func (s *S3Publisher) startNext() error {
+	if s.next == nil {
+		return nil
+	}
+	if err := s.upload(); err != nil {
+		return err
+	}
+	s.src = *s.next
+	s.next = nil
+	return nil
+}
+
+// Close uploads the buffered data
+func (s *S3Publisher) Close() error {
+	if err := s.startNext(); err != nil {
+		return err
+	}
+	return s.upload()
+}
+
+// upload sends the buffer as one object and removes it
+func (s *S3Publisher) upload() error {
+	if s.buffer == nil {
+		return nil
+	}
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.buffer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.Config.Bucket),
+		Key:         aws.String(s.key()),
+		Body:        s.buffer,
+		ContentType: aws.String("text/plain"),
+		Metadata: map[string]*string{
+			"Rds-Instance":    aws.String(s.src.Instance),
+			"Rds-Log-File":    aws.String(s.src.LogFile),
+			"Rds-Marker-From": aws.String(s.src.From),
+			"Rds-Marker-To":   aws.String(s.src.To),
+		},
+	}
+	if s.src.LastWritten > 0 {
+		input.Metadata["Rds-Last-Written"] = aws.String(strconv.FormatInt(s.src.LastWritten, 10))
+	}
+	if s.Config.Gzip {
+		input.ContentEncoding = aws.String("gzip")
+	}
+	if _, err := s.Uploader.Upload(input); err != nil {
+		// keep the buffer, the data is uploaded again on the next Flush.
+		// Further data is appended as another gzip member.
+		if _, seekErr := s.buffer.Seek(0, io.SeekEnd); seekErr != nil {
+			return seekErr
+		}
+		if s.gz != nil {
+			s.gz.Reset(s.buffer)
+		}
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", s.Config.Bucket, aws.StringValue(input.Key), err)
+	}
+
+	name := s.buffer.Name()
+	err := s.buffer.Close()
+	os.Remove(name)
+	s.buffer = nil
+	s.gz = nil
+	s.buffered = 0
+	return err
+}
+
+// markerHour returns the hour part of an RDS marker
+func markerHour(marker string) string {
+	hour, _, _ := strings.Cut(marker, ":")
+	return hour
+}
+
+// markerOffset returns the offset part of an RDS marker
+func markerOffset(marker string) (int, bool) {
+	_, offset, ok := strings.Cut(marker, ":")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(offset)
+	return n, err == nil
+}
+
+// earlierMarker returns b when it is before a in the same hour, otherwise a
+func earlierMarker(a string, b string) string {
+	aOffset, aOK := markerOffset(a)
+	bOffset, bOK := markerOffset(b)
+	if aOK && bOK && markerHour(a) == markerHour(b) && bOffset < aOffset {
+		return b
+	}
+	return a
+}
+
+// clock returns the current time
+func (s *S3Publisher) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// key expands the key template for the buffered data. {date} and {hour}
+// are taken from LastWritten, or the upload time when it is unknown.
+func (s *S3Publisher) key() string {
+	t := s.clock()
+	if s.src.LastWritten > 0 {
+		t = time.Unix(0, s.src.LastWritten*int64(time.Millisecond))
+	}
+	t = t.UTC()
+
+	file := path.Base(s.src.LogFile)
+	logType := path.Dir(s.src.LogFile)
+	if logType == "." {
+		logType = strings.SplitN(file, ".", 2)[0]
+	}
+	template := s.Config.KeyTemplate
+	if template == "" {
+		template = DefaultS3KeyTemplate
+	}
+
+	key := strings.NewReplacer(
+		"{instance}", s.src.Instance,
+		"{logtype}", logType,
+		"{file}", file,
+		"{date}", t.Format("2006-01-02"),
+		"{hour}", t.Format("15"),
+		"{from}", s.src.From,
+		"{to}", s.src.To,
+	).Replace(template)
+	if s.Config.Gzip && !strings.HasSuffix(key, ".gz") {
+		key += ".gz"
+	}
+	return key
+}
@@ -0,0 +1,255 @@
+package publisher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Object is an object stored by s3Server
+type s3Object struct {
+	body     []byte
+	metadata http.Header
+	parts    int
+}
+
+// s3Server is a minimal MinIO-style S3 server, addressed path style, which
+// supports PutObject and multipart uploads
+type s3Server struct {
+	mu      sync.Mutex
+	objects map[string]*s3Object
+	uploads map[string]*s3Object
+	parts   map[string]map[string][]byte
+}
+
+func newS3Server() *s3Server {
+	return &s3Server{
+		objects: map[string]*s3Object{},
+		uploads: map[string]*s3Object{},
+		parts:   map[string]map[string][]byte{},
+	}
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	key := r.URL.Path
+	query := r.URL.Query()
+
+	metadata := http.Header{}
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			metadata[strings.TrimPrefix(name, "X-Amz-Meta-")] = values
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+		s.uploads[id] = &s3Object{metadata: metadata}
+		s.parts[id] = map[string][]byte{}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		s.parts[query.Get("uploadId")][query.Get("partNumber")] = body
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		id := query.Get("uploadId")
+		obj := s.uploads[id]
+		var numbers []string
+		for number := range s.parts[id] {
+			numbers = append(numbers, number)
+		}
+		sort.Strings(numbers)
+		for _, number := range numbers {
+			obj.body = append(obj.body, s.parts[id][number]...)
+		}
+		obj.parts = len(numbers)
+		s.objects[key] = obj
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>`, key)
+	case r.Method == http.MethodPut:
+		s.objects[key] = &s3Object{body: body, metadata: metadata}
+		w.Header().Set("ETag", `"etag"`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newTestS3(t *testing.T, cfg S3Config) (*s3Server, S3Config, S3Uploader) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+	srv := newS3Server()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	cfg.Bucket = "archive"
+	cfg.Region = "us-east-1"
+	cfg.Endpoint = ts.URL
+	uploader, err := NewS3Uploader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, cfg, uploader
+}
+
+func TestS3PublisherArchivesWholeFile(t *testing.T) {
+	srv, cfg, uploader := newTestS3(t, S3Config{})
+	s := &S3Publisher{Uploader: uploader, Config: cfg, PerFile: true}
+
+	// 6MB is sent as a multipart upload of two 5MB parts
+	line := strings.Repeat("x", 1023) + "\n"
+	lastWritten := time.Date(2022, 9, 1, 13, 59, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	for i := 0; i < 6; i++ {
+		s.SetSource(Source{
+			Instance:    "db-1",
+			LogFile:     "slowquery/mysql-slowquery.log.13",
+			LastWritten: lastWritten,
+			From:        fmt.Sprintf("13:%d", i*1024*1024),
+			To:          fmt.Sprintf("13:%d", (i+1)*1024*1024),
+		})
+		for j := 0; j < 1024; j++ {
+			if err := s.Write(line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(srv.objects) != 0 {
+		t.Fatalf("expected no upload before Close, got %d objects", len(srv.objects))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := srv.objects["/archive/db-1/slowquery/2022-09-01/13/mysql-slowquery.log.13.13:0-13:6291456"]
+	if obj == nil {
+		t.Fatalf("object missing, got %v", srv.objects)
+	}
+	if obj.parts != 2 || len(obj.body) != 6*1024*1024 {
+		t.Errorf("expected 6MB in 2 parts, got %d bytes in %d parts", len(obj.body), obj.parts)
+	}
+	expected := map[string]string{
+		"Rds-Instance":     "db-1",
+		"Rds-Log-File":     "slowquery/mysql-slowquery.log.13",
+		"Rds-Marker-From":  "13:0",
+		"Rds-Marker-To":    "13:6291456",
+		"Rds-Last-Written": fmt.Sprint(lastWritten),
+	}
+	for name, value := range expected {
+		if got := obj.metadata.Get(name); got != value {
+			t.Errorf("metadata %s: expected %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestS3PublisherUploadsEveryFlush(t *testing.T) {
+	srv, cfg, uploader := newTestS3(t, S3Config{
+		KeyTemplate: "{logtype}/{instance}/{date}T{hour}/{from}",
+		Gzip:        true,
+	})
+	s := &S3Publisher{
+		Uploader: uploader,
+		Config:   cfg,
+		now:      func() time.Time { return time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC) },
+	}
+
+	for i, marker := range []string{"8:0", "8:6"} {
+		s.SetSource(Source{Instance: "db-1", LogFile: "error/postgresql.log.2022-09-01-08", From: marker, To: "next"})
+		if err := s.Write(fmt.Sprintf("line%d\n", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range []string{"/archive/error/db-1/2022-09-01T08/8:0.gz", "/archive/error/db-1/2022-09-01T08/8:6.gz"} {
+		obj := srv.objects[key]
+		if obj == nil {
+			t.Fatalf("object %s missing, got %v", key, srv.objects)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(obj.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(gz)
+		if expected := fmt.Sprintf("line%d\n", i); string(data) != expected {
+			t.Errorf("expected %q in %s, got %q", expected, key, data)
+		}
+		if obj.metadata.Get("Rds-Last-Written") != "" {
+			t.Errorf("expected no Rds-Last-Written for a live log file")
+		}
+	}
+}
+
+func TestS3PublisherBuffersStreamedData(t *testing.T) {
+	srv, cfg, uploader := newTestS3(t, S3Config{
+		KeyTemplate: "{instance}/{date}T{hour}/{from}-{to}",
+		BufferSize:  64,
+		BufferAge:   time.Minute,
+	})
+	now := time.Date(2022, 9, 1, 8, 30, 0, 0, time.UTC)
+	s := &S3Publisher{Uploader: uploader, Config: cfg, now: func() time.Time { return now }}
+	lastWritten := time.Date(2022, 9, 1, 8, 15, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	write := func(from, to, data string) {
+		t.Helper()
+		s.SetSource(Source{Instance: "db-1", LogFile: "slowquery/mysql-slowquery.log", LastWritten: lastWritten, From: from, To: to})
+		if err := s.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("8:0", "8:6", "line1\n")
+	write("8:6", "8:12", "line2\n")
+	if len(srv.objects) != 0 {
+		t.Fatalf("expected the data to be buffered, got %d objects", len(srv.objects))
+	}
+	if from, ok := s.Unpublished(); !ok || from != "8:0" {
+		t.Errorf("expected data from 8:0 to be unpublished, got %q %t", from, ok)
+	}
+
+	// an empty flush uploads the data once it is old enough
+	now = now.Add(time.Minute)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := srv.objects["/archive/db-1/2022-09-01T08/8:0-8:12"]
+	if obj == nil {
+		t.Fatalf("object missing, got %v", srv.objects)
+	}
+	if string(obj.body) != "line1\nline2\n" || obj.metadata.Get("Rds-Last-Written") != fmt.Sprint(lastWritten) {
+		t.Errorf("unexpected object %q %v", obj.body, obj.metadata)
+	}
+	if _, ok := s.Unpublished(); ok {
+		t.Error("expected everything to be published")
+	}
+
+	// the rotated log file starts a new object
+	write("8:12", "8:18", "line3\n")
+	write("8:18", "9:6", "line4\n")
+	if obj := srv.objects["/archive/db-1/2022-09-01T08/8:12-8:18"]; obj == nil || string(obj.body) != "line3\n" {
+		t.Fatalf("expected the data before the rotation in its own object, got %v", srv.objects)
+	}
+	if from, _ := s.Unpublished(); from != "8:18" {
+		t.Errorf("expected data from 8:18 to be unpublished, got %q", from)
+	}
+
+	// as does a full buffer
+	write("9:6", "9:70", strings.Repeat("x", 63)+"\n")
+	if obj := srv.objects["/archive/db-1/2022-09-01T08/8:18-9:70"]; obj == nil || len(obj.body) != 70 {
+		t.Fatalf("expected the full buffer to be uploaded, got %v", srv.objects)
+	}
+}
@@ -3,12 +3,29 @@ package publisher
 import (
 	"io"
 	"os"
+	"sync"
 )
 
+// stdoutMu keeps lines of concurrent instance streams from interleaving
+var stdoutMu sync.Mutex
+
 // STDOUTPublisher implements Publisher and sends the data to stdout
 type STDOUTPublisher struct {
 }
 
-func (s *STDOUTPublisher) Write(line string) {
-	_, _ = io.WriteString(os.Stdout, line)
+func (s *STDOUTPublisher) Write(line string) error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, err := io.WriteString(os.Stdout, line)
+	return err
+}
+
+// Flush is a no-op, as writes to stdout are unbuffered
+func (s *STDOUTPublisher) Flush() error {
+	return nil
+}
+
+// Close is a no-op, stdout stays open
+func (s *STDOUTPublisher) Close() error {
+	return nil
 }
@@ -0,0 +1,426 @@
+// Package rdstest provides an in memory stand-in for the RDS API used by
+// rdslogs, so streaming and downloading can be tested without AWS.
+package rdstest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// Operation names accepted by QueueError and Calls
+const (
+	OpDescribeDBInstances      = "DescribeDBInstances"
+	OpDescribeDBClusters       = "DescribeDBClusters"
+	OpDescribeDBLogFiles       = "DescribeDBLogFiles"
+	OpDownloadDBLogFilePortion = "DownloadDBLogFilePortion"
+)
+
+// maxPortion is the most data RDS returns from one DownloadDBLogFilePortion
+const maxPortion = 1 << 20
+
+// ThrottlingError is the error RDS returns when the API rate limit is hit
+func ThrottlingError() error {
+	return awserr.New("Throttling", "Rate exceeded", nil)
+}
+
+// BinaryDataError is the error RDS returns for portions holding binary data
+func BinaryDataError() error {
+	return awserr.New("InvalidParameterValue",
+		"This file contains binary data and should be downloaded instead of viewed.", nil)
+}
+
+// LogFileNotFoundError is the error RDS returns for unknown log files
+func LogFileNotFoundError(name string) error {
+	return awserr.New(rds.ErrCodeDBLogFileNotFoundFault,
+		fmt.Sprintf("DBLog File: %s, is not found on the DB instance", name), nil)
+}
+
+type logFile struct {
+	// hour is the first part of the markers pointing in to this file
+	hour        int
+	data        string
+	lastWritten int64
+	// offset ranges [from, to) refused as binary data
+	binary [][2]int
+}
+
+// FakeRDS simulates the RDS API for a set of instances and clusters. Log
+// files grow with AppendLog and rotate hourly with Rotate; markers have the
+// RDS "hour:offset" form. Errors such as throttling can be injected with
+// QueueError. A FakeRDS is safe for concurrent use.
+type FakeRDS struct {
+	// PageSize limits the number of items returned per page by the Describe
+	// calls. 0 returns everything in one page.
+	PageSize int
+
+	mu        sync.Mutex
+	instances []*rds.DBInstance
+	clusters  []*rds.DBCluster
+	logs      map[string]map[string]*logFile
+	hour      int
+	clock     int64
+	errs      map[string][]error
+	calls     map[string]int
+}
+
+// New returns an empty FakeRDS whose clock starts at hour 10.
+func New() *FakeRDS {
+	return &FakeRDS{
+		logs:  make(map[string]map[string]*logFile),
+		hour:  10,
+		clock: 1660000000000,
+		errs:  make(map[string][]error),
+		calls: make(map[string]int),
+	}
+}
+
+// AddInstance adds an available instance with the given engine and tags.
+func (f *FakeRDS) AddInstance(id string, engine string, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instance := &rds.DBInstance{
+		DBInstanceIdentifier: aws.String(id),
+		DBInstanceStatus:     aws.String("available"),
+		Engine:               aws.String(engine),
+	}
+	for k, v := range tags {
+		instance.TagList = append(instance.TagList, &rds.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	f.instances = append(f.instances, instance)
+	f.logs[id] = make(map[string]*logFile)
+}
+
+// RemoveInstance deletes an instance and its log files.
+func (f *FakeRDS) RemoveInstance(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, instance := range f.instances {
+		if aws.StringValue(instance.DBInstanceIdentifier) == id {
+			f.instances = append(f.instances[:i], f.instances[i+1:]...)
+			break
+		}
+	}
+	delete(f.logs, id)
+}
+
+// SetCluster creates or replaces the cluster id with writer and readers as
+// members. The member instances have to be added separately.
+func (f *FakeRDS) SetCluster(id string, writer string, readers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cluster := &rds.DBCluster{DBClusterIdentifier: aws.String(id)}
+	cluster.DBClusterMembers = append(cluster.DBClusterMembers, &rds.DBClusterMember{
+		DBInstanceIdentifier: aws.String(writer),
+		IsClusterWriter:      aws.Bool(true),
+	})
+	for _, reader := range readers {
+		cluster.DBClusterMembers = append(cluster.DBClusterMembers, &rds.DBClusterMember{
+			DBInstanceIdentifier: aws.String(reader),
+			IsClusterWriter:      aws.Bool(false),
+		})
+	}
+
+	for i, c := range f.clusters {
+		if aws.StringValue(c.DBClusterIdentifier) == id {
+			f.clusters[i] = cluster
+			return
+		}
+	}
+	f.clusters = append(f.clusters, cluster)
+}
+
+// AppendLog appends data to the log file name of instance, creating the file
+// if needed, and advances the clock by a second.
+func (f *FakeRDS) AppendLog(instance string, name string, data string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clock += 1000
+	lf := f.file(instance, name)
+	lf.data += data
+	lf.lastWritten = f.clock
+}
+
+// Rotate renames the log file name of instance to name.<hour>, as RDS does
+// with the MySQL logs every hour, and starts an empty name for the next hour.
+func (f *FakeRDS) Rotate(instance string, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clock += 1000
+	lf := f.file(instance, name)
+	f.logs[instance][fmt.Sprintf("%s.%d", name, lf.hour)] = lf
+	f.hour = (lf.hour + 1) % 24
+	f.logs[instance][name] = &logFile{hour: f.hour, lastWritten: f.clock}
+}
+
+// SetBinary makes downloads of the log file name starting in [from, to)
+// fail with BinaryDataError.
+func (f *FakeRDS) SetBinary(instance string, name string, from int, to int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lf := f.file(instance, name)
+	lf.binary = append(lf.binary, [2]int{from, to})
+}
+
+// QueueError makes the next call of op fail with err. Several errors queue
+// up and are returned by consecutive calls.
+func (f *FakeRDS) QueueError(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errs[op] = append(f.errs[op], err)
+}
+
+// Calls returns how often op has been called.
+func (f *FakeRDS) Calls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[op]
+}
+
+// file returns the log file name of instance, creating it if needed. f.mu
+// must be held.
+func (f *FakeRDS) file(instance string, name string) *logFile {
+	files, ok := f.logs[instance]
+	if !ok {
+		files = make(map[string]*logFile)
+		f.logs[instance] = files
+	}
+	lf, ok := files[name]
+	if !ok {
+		lf = &logFile{hour: f.hour, lastWritten: f.clock}
+		files[name] = lf
+	}
+	return lf
+}
+
+// call counts a call of op and returns the queued error, if any. f.mu must
+// be held.
+func (f *FakeRDS) call(op string) error {
+	f.calls[op]++
+	if errs := f.errs[op]; len(errs) > 0 {
+		f.errs[op] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// page returns the start and end index of the page at marker of n items and
+// the marker of the next page, if any.
+func (f *FakeRDS) page(marker *string, n int) (int, int, *string) {
+	start, _ := strconv.Atoi(aws.StringValue(marker))
+	if start > n {
+		start = n
+	}
+	end := n
+	if f.PageSize > 0 && start+f.PageSize < n {
+		end = start + f.PageSize
+	}
+	if end < n {
+		return start, end, aws.String(strconv.Itoa(end))
+	}
+	return start, end, nil
+}
+
+// DescribeDBInstances implements the RDS API call.
+func (f *FakeRDS) DescribeDBInstances(in *rds.DescribeDBInstancesInput) (*rds.DescribeDBInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpDescribeDBInstances); err != nil {
+		return nil, err
+	}
+	if in == nil {
+		in = &rds.DescribeDBInstancesInput{}
+	}
+
+	instances := f.instances
+	if in.DBInstanceIdentifier != nil {
+		instances = nil
+		for _, instance := range f.instances {
+			if aws.StringValue(instance.DBInstanceIdentifier) == *in.DBInstanceIdentifier {
+				instances = append(instances, instance)
+			}
+		}
+		if len(instances) == 0 {
+			return nil, awserr.New(rds.ErrCodeDBInstanceNotFoundFault,
+				fmt.Sprintf("DBInstance %s not found.", *in.DBInstanceIdentifier), nil)
+		}
+	}
+
+	start, end, next := f.page(in.Marker, len(instances))
+	return &rds.DescribeDBInstancesOutput{
+		DBInstances: instances[start:end],
+		Marker:      next,
+	}, nil
+}
+
+// DescribeDBClusters implements the RDS API call.
+func (f *FakeRDS) DescribeDBClusters(in *rds.DescribeDBClustersInput) (*rds.DescribeDBClustersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpDescribeDBClusters); err != nil {
+		return nil, err
+	}
+	if in == nil {
+		in = &rds.DescribeDBClustersInput{}
+	}
+
+	clusters := f.clusters
+	if in.DBClusterIdentifier != nil {
+		clusters = nil
+		for _, cluster := range f.clusters {
+			if aws.StringValue(cluster.DBClusterIdentifier) == *in.DBClusterIdentifier {
+				clusters = append(clusters, cluster)
+			}
+		}
+		if len(clusters) == 0 {
+			return nil, awserr.New(rds.ErrCodeDBClusterNotFoundFault,
+				fmt.Sprintf("DBCluster %s not found.", *in.DBClusterIdentifier), nil)
+		}
+	}
+
+	start, end, next := f.page(in.Marker, len(clusters))
+	return &rds.DescribeDBClustersOutput{
+		DBClusters: clusters[start:end],
+		Marker:     next,
+	}, nil
+}
+
+// DescribeDBLogFiles implements the RDS API call, honouring FileLastWritten.
+func (f *FakeRDS) DescribeDBLogFiles(in *rds.DescribeDBLogFilesInput) (*rds.DescribeDBLogFilesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpDescribeDBLogFiles); err != nil {
+		return nil, err
+	}
+	id := aws.StringValue(in.DBInstanceIdentifier)
+	files, ok := f.logs[id]
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceNotFoundFault,
+			fmt.Sprintf("DBInstance %s not found.", id), nil)
+	}
+
+	names := make([]string, 0, len(files))
+	for name, lf := range files {
+		if lf.lastWritten >= aws.Int64Value(in.FileLastWritten) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, end, next := f.page(in.Marker, len(names))
+	out := &rds.DescribeDBLogFilesOutput{Marker: next}
+	for _, name := range names[start:end] {
+		lf := files[name]
+		out.DescribeDBLogFiles = append(out.DescribeDBLogFiles, &rds.DescribeDBLogFilesDetails{
+			LogFileName: aws.String(name),
+			LastWritten: aws.Int64(lf.lastWritten),
+			Size:        aws.Int64(int64(len(lf.data))),
+		})
+	}
+	return out, nil
+}
+
+// DownloadDBLogFilePortion implements the RDS API call. Without a marker it
+// returns the last NumberOfLines lines, with marker "0" it starts at the
+// beginning of the file and with a marker of an earlier hour (the file has
+// been rotated since) it returns no data and marker "0".
+func (f *FakeRDS) DownloadDBLogFilePortion(in *rds.DownloadDBLogFilePortionInput) (*rds.DownloadDBLogFilePortionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(OpDownloadDBLogFilePortion); err != nil {
+		return nil, err
+	}
+	id := aws.StringValue(in.DBInstanceIdentifier)
+	files, ok := f.logs[id]
+	if !ok {
+		return nil, awserr.New(rds.ErrCodeDBInstanceNotFoundFault,
+			fmt.Sprintf("DBInstance %s not found.", id), nil)
+	}
+	name := aws.StringValue(in.LogFileName)
+	lf, ok := files[name]
+	if !ok {
+		return nil, LogFileNotFoundError(name)
+	}
+
+	numLines := int(aws.Int64Value(in.NumberOfLines))
+	if numLines <= 0 {
+		numLines = 10000
+	}
+
+	if in.Marker == nil {
+		start := len(lf.data)
+		for i := 0; i < numLines && start > 0; i++ {
+			start = strings.LastIndex(lf.data[:start-1], "\n") + 1
+			if start == 0 {
+				break
+			}
+		}
+		return f.portion(lf, start, len(lf.data)), nil
+	}
+
+	start := 0
+	if *in.Marker != "0" {
+		parts := strings.Split(*in.Marker, ":")
+		if len(parts) != 2 {
+			return nil, awserr.New("InvalidParameterValue", "Invalid marker "+*in.Marker, nil)
+		}
+		hour, _ := strconv.Atoi(parts[0])
+		if hour != lf.hour {
+			return &rds.DownloadDBLogFilePortionOutput{
+				AdditionalDataPending: aws.Bool(false),
+				LogFileData:           aws.String(""),
+				Marker:                aws.String("0"),
+			}, nil
+		}
+		start, _ = strconv.Atoi(parts[1])
+		if start > len(lf.data) {
+			start = len(lf.data)
+		}
+	}
+
+	for _, r := range lf.binary {
+		if start >= r[0] && start < r[1] {
+			return nil, BinaryDataError()
+		}
+	}
+
+	end := start
+	for i := 0; i < numLines && end < len(lf.data) && end-start < maxPortion; i++ {
+		next := strings.Index(lf.data[end:], "\n")
+		if next < 0 {
+			end = len(lf.data)
+			break
+		}
+		end += next + 1
+	}
+	if end-start > maxPortion {
+		end = start + maxPortion
+	}
+	return f.portion(lf, start, end), nil
+}
+
+func (f *FakeRDS) portion(lf *logFile, start int, end int) *rds.DownloadDBLogFilePortionOutput {
+	return &rds.DownloadDBLogFilePortionOutput{
+		AdditionalDataPending: aws.Bool(end < len(lf.data)),
+		LogFileData:           aws.String(lf.data[start:end]),
+		Marker:                aws.String(fmt.Sprintf("%d:%d", lf.hour, end)),
+	}
+}
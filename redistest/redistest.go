@@ -0,0 +1,708 @@
+// Package redistest provides an in process stand-in for Redis, so the redis
+// tracker can be tested without a Redis server. It speaks enough RESP for
+// the commands rdslogs sends, including those of Sentinel and Cluster and
+// transactions with WATCH.
+package redistest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles reported by ROLE
+const (
+	RoleMaster = "master"
+	RoleSlave  = "slave"
+)
+
+// numSlots is the number of hash slots of a Redis Cluster
+const numSlots = 16384
+
+// SlotRange assigns the hash slots Start to End, inclusive, to the node at
+// Addr
+type SlotRange struct {
+	Start, End int
+	Addr       string
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Server is a Redis stand-in listening on a local port. Keys expire on the
+// clock of the server, which FastForward moves on. By default it is a
+// master without password; RequireAuth, SetRole, SetMaster and SetSlots turn
+// on authentication, replica, Sentinel and Cluster behaviour. A Server is
+// safe for concurrent use.
+type Server struct {
+	// CertPEM is the self-signed certificate of a TLS server
+	CertPEM []byte
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	password string
+	role     string
+	masters  map[string]string
+	slots    []SlotRange
+	dbs      map[int]map[string]*entry
+	// versions counts the modifications of every key, for WATCH
+	versions map[int]map[string]uint64
+	offset   time.Duration
+	conns    map[net.Conn]bool
+	calls    map[string]int
+}
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return serve(l), nil
+}
+
+// NewTLSServer starts a server accepting TLS connections on a random local
+// port, with a certificate for 127.0.0.1 in CertPEM
+func NewTLSServer() (*Server, error) {
+	cert, certPEM, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	s := serve(l)
+	s.CertPEM = certPEM
+	return s, nil
+}
+
+func serve(l net.Listener) *Server {
+	s := &Server{
+		listener: l,
+		role:     RoleMaster,
+		masters:  make(map[string]string),
+		dbs:      make(map[int]map[string]*entry),
+		versions: make(map[int]map[string]uint64),
+		conns:    make(map[net.Conn]bool),
+		calls:    make(map[string]int),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.conns[conn] = true
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.listener.Close()
+	s.CloseClients()
+	s.wg.Wait()
+}
+
+// CloseClients closes the client connections, as a restarted Redis would
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// RequireAuth makes the server refuse commands until AUTH password is sent
+func (s *Server) RequireAuth(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetRole sets the role reported by ROLE, RoleMaster or RoleSlave. Replicas
+// refuse writes.
+func (s *Server) SetRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+}
+
+// SetMaster makes the server answer SENTINEL get-master-addr-by-name name
+// with addr, as a Sentinel monitoring the master name
+func (s *Server) SetMaster(name, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.masters[name] = addr
+}
+
+// SetSlots makes the server a node of a Redis Cluster with the slot layout
+// slots, answered by CLUSTER SLOTS. Commands on keys of slots assigned to
+// other nodes are redirected with MOVED.
+func (s *Server) SetSlots(slots ...SlotRange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slots = slots
+}
+
+// FastForward moves the clock of the server on by d
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Get returns the value of key in database 0
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(0, key)
+	if e == nil {
+		return "", false
+	}
+	return e.value, true
+}
+
+// Set sets key to value in database 0
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db(0)[key] = &entry{value: value}
+	s.modified(0, key)
+}
+
+// TTL returns the time to live of key in database 0, 0 when it doesn't
+// expire or doesn't exist
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(0, key)
+	if e == nil || e.expires.IsZero() {
+		return 0
+	}
+	return e.expires.Sub(s.now())
+}
+
+// Calls returns how many times the command cmd was received
+func (s *Server) Calls(cmd string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[strings.ToUpper(cmd)]
+}
+
+// Slot returns the Redis Cluster hash slot of key
+func Slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % numSlots
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) db(n int) map[string]*entry {
+	if s.dbs[n] == nil {
+		s.dbs[n] = make(map[string]*entry)
+	}
+	return s.dbs[n]
+}
+
+// lookup returns the entry of key, dropping it once expired
+func (s *Server) lookup(db int, key string) *entry {
+	e := s.db(db)[key]
+	if e != nil && !e.expires.IsZero() && !s.now().Before(e.expires) {
+		delete(s.db(db), key)
+		s.modified(db, key)
+		return nil
+	}
+	return e
+}
+
+// modified records a modification of key, failing the transactions
+// watching it
+func (s *Server) modified(db int, key string) {
+	if s.versions[db] == nil {
+		s.versions[db] = make(map[string]uint64)
+	}
+	s.versions[db][key]++
+}
+
+// session is the state of a client connection
+type session struct {
+	db            int
+	authenticated bool
+	// multi is set between MULTI and EXEC, queueing the commands
+	multi  bool
+	queued [][]string
+	// watched are the versions of the watched keys when watched
+	watched map[string]uint64
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	sess := &session{}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		writeReply(w, s.exec(sess, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// status is a simple string reply
+type status string
+
+// replyError is an error reply
+type replyError string
+
+// nilArray is the null array reply of a failed transaction
+type nilArray struct{}
+
+func (s *Server) exec(sess *session, args []string) interface{} {
+	if len(args) == 0 {
+		return replyError("ERR empty command")
+	}
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[cmd]++
+
+	if cmd == "AUTH" {
+		if len(args) == 0 || len(args) > 2 {
+			return replyError("ERR wrong number of arguments for 'auth' command")
+		}
+		if s.password == "" {
+			return replyError("ERR AUTH called without any password configured")
+		}
+		if args[len(args)-1] != s.password {
+			return replyError("WRONGPASS invalid username-password pair")
+		}
+		sess.authenticated = true
+		return status("OK")
+	}
+	if s.password != "" && !sess.authenticated {
+		return replyError("NOAUTH Authentication required.")
+	}
+
+	switch cmd {
+	case "MULTI":
+		if sess.multi {
+			return replyError("ERR MULTI calls can not be nested")
+		}
+		sess.multi = true
+		return status("OK")
+	case "EXEC":
+		if !sess.multi {
+			return replyError("ERR EXEC without MULTI")
+		}
+		return s.execTransaction(sess)
+	case "DISCARD":
+		if !sess.multi {
+			return replyError("ERR DISCARD without MULTI")
+		}
+		sess.multi, sess.queued, sess.watched = false, nil, nil
+		return status("OK")
+	case "WATCH":
+		if sess.multi {
+			return replyError("ERR WATCH inside MULTI is not allowed")
+		}
+		if len(args) == 0 {
+			return replyError("ERR wrong number of arguments for 'watch' command")
+		}
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range args {
+			if moved := s.moved(key); moved != "" {
+				return replyError(moved)
+			}
+			s.lookup(sess.db, key)
+			sess.watched[key] = s.versions[sess.db][key]
+		}
+		return status("OK")
+	case "UNWATCH":
+		sess.watched = nil
+		return status("OK")
+	}
+	if sess.multi {
+		sess.queued = append(sess.queued, append([]string{cmd}, args...))
+		return status("QUEUED")
+	}
+	return s.run(sess, cmd, args)
+}
+
+// execTransaction runs the queued commands unless a watched key was
+// modified, in which case it replies with a null array
+func (s *Server) execTransaction(sess *session) interface{} {
+	queued, watched := sess.queued, sess.watched
+	sess.multi, sess.queued, sess.watched = false, nil, nil
+	for key, version := range watched {
+		s.lookup(sess.db, key)
+		if s.versions[sess.db][key] != version {
+			return nilArray{}
+		}
+	}
+	replies := make([]interface{}, 0, len(queued))
+	for _, command := range queued {
+		replies = append(replies, s.run(sess, command[0], command[1:]))
+	}
+	return replies
+}
+
+// run runs the command cmd, with the server locked
+func (s *Server) run(sess *session, cmd string, args []string) interface{} {
+	switch cmd {
+	case "PING":
+		if len(args) > 0 {
+			return args[0]
+		}
+		return status("PONG")
+	case "SELECT":
+		if len(args) != 1 {
+			return replyError("ERR wrong number of arguments for 'select' command")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || n > 15 {
+			return replyError("ERR DB index is out of range")
+		}
+		if len(s.slots) > 0 && n != 0 {
+			return replyError("ERR SELECT is not allowed in cluster mode")
+		}
+		sess.db = n
+		return status("OK")
+	case "ROLE":
+		if s.role == RoleSlave {
+			return []interface{}{RoleSlave, "127.0.0.1", int64(6379), "connected", int64(0)}
+		}
+		return []interface{}{RoleMaster, int64(0), []interface{}{}}
+	case "SENTINEL":
+		if len(args) != 2 || strings.ToLower(args[0]) != "get-master-addr-by-name" {
+			return replyError("ERR unknown sentinel subcommand")
+		}
+		addr, ok := s.masters[args[1]]
+		if !ok {
+			return nil
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		return []interface{}{host, port}
+	case "CLUSTER":
+		if len(args) != 1 || strings.ToUpper(args[0]) != "SLOTS" {
+			return replyError("ERR unknown cluster subcommand")
+		}
+		if len(s.slots) == 0 {
+			return replyError("ERR This instance has cluster support disabled")
+		}
+		var reply []interface{}
+		for _, slot := range s.slots {
+			host, port, _ := net.SplitHostPort(slot.Addr)
+			p, _ := strconv.Atoi(port)
+			reply = append(reply, []interface{}{int64(slot.Start), int64(slot.End),
+				[]interface{}{host, int64(p), "node-" + port}})
+		}
+		return reply
+	case "ASKING":
+		return status("OK")
+	case "GET", "SET", "DEL", "INCR", "PTTL", "PEXPIRE":
+		if len(args) == 0 {
+			return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+		}
+		if moved := s.moved(args[0]); moved != "" {
+			return replyError(moved)
+		}
+		if s.role == RoleSlave && cmd != "GET" && cmd != "PTTL" {
+			return replyError("READONLY You can't write against a read only replica.")
+		}
+		return s.execKey(sess, cmd, args)
+	}
+	return replyError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+}
+
+// moved returns the MOVED error of key in cluster mode when another node
+// serves its slot
+func (s *Server) moved(key string) string {
+	if len(s.slots) == 0 {
+		return ""
+	}
+	slot := Slot(key)
+	for _, r := range s.slots {
+		if slot >= r.Start && slot <= r.End {
+			if r.Addr == s.Addr() {
+				return ""
+			}
+			return fmt.Sprintf("MOVED %d %s", slot, r.Addr)
+		}
+	}
+	return fmt.Sprintf("CLUSTERDOWN Hash slot %d not served", slot)
+}
+
+// execKey runs the commands on a key
+func (s *Server) execKey(sess *session, cmd string, args []string) interface{} {
+	db := s.db(sess.db)
+	key := args[0]
+	e := s.lookup(sess.db, key)
+
+	switch cmd {
+	case "GET":
+		if e == nil {
+			return nil
+		}
+		return e.value
+	case "SET":
+		return s.set(sess, args)
+	case "DEL":
+		deleted := int64(0)
+		for _, k := range args {
+			if s.lookup(sess.db, k) != nil {
+				delete(db, k)
+				s.modified(sess.db, k)
+				deleted++
+			}
+		}
+		return deleted
+	case "INCR":
+		n := int64(0)
+		if e != nil {
+			var err error
+			if n, err = strconv.ParseInt(e.value, 10, 64); err != nil {
+				return replyError("ERR value is not an integer or out of range")
+			}
+		} else {
+			e = &entry{}
+			db[key] = e
+		}
+		n++
+		e.value = strconv.FormatInt(n, 10)
+		s.modified(sess.db, key)
+		return n
+	case "PTTL":
+		if e == nil {
+			return int64(-2)
+		}
+		if e.expires.IsZero() {
+			return int64(-1)
+		}
+		return int64(e.expires.Sub(s.now()) / time.Millisecond)
+	case "PEXPIRE":
+		if len(args) != 2 {
+			return replyError("ERR wrong number of arguments for 'pexpire' command")
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		if e == nil {
+			return int64(0)
+		}
+		e.expires = s.now().Add(time.Duration(ms) * time.Millisecond)
+		s.modified(sess.db, key)
+		return int64(1)
+	}
+	return replyError("ERR unknown command")
+}
+
+// set runs SET key value [EX seconds | PX milliseconds] [NX | XX]
+func (s *Server) set(sess *session, args []string) interface{} {
+	if len(args) < 2 {
+		return replyError("ERR wrong number of arguments for 'set' command")
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			if i+1 == len(args) {
+				return replyError("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return replyError("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+
+	exists := s.lookup(sess.db, key) != nil
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	e := &entry{value: value}
+	if ttl > 0 {
+		e.expires = s.now().Add(ttl)
+	}
+	s.db(sess.db)[key] = e
+	s.modified(sess.db, key)
+	return status("OK")
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errors.New("expected a bulk string")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nilArray:
+		w.WriteString("*-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case replyError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+// selfSigned returns a certificate for 127.0.0.1 and its PEM encoding
+func selfSigned() (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redistest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
@@ -15,8 +15,10 @@ resource "aws_iam_policy" "honeycomb-rdslogs-policy" {
         "Effect": "Allow",
         "Action": [
             "rds:DescribeDBInstances",
+            "rds:DescribeDBClusters",
             "rds:DescribeDBLogFiles",
-            "rds:DownloadDBLogFilePortion"
+            "rds:DownloadDBLogFilePortion",
+            "sts:GetCallerIdentity"
         ],
         "Resource": "*"
     }
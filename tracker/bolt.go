@@ -0,0 +1,237 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	latestBucket  = []byte("latest")
+	historyBucket = []byte("history")
+)
+
+// ErrNoMarker is returned for keys or history entries without marker
+var ErrNoMarker = errors.New("no such marker")
+
+// MarkerRecord is an entry of the marker history of a key
+type MarkerRecord struct {
+	// ID orders the records of a key
+	ID   uint64
+	Time time.Time
+	// LogFile and Marker are read from the marker JSON stored by rdslogs
+	LogFile string
+	Marker  string
+	// Value is the stored marker JSON
+	Value string
+	// Rewind is set on records written by Rewind
+	Rewind bool `json:",omitempty"`
+}
+
+// BoltTracker keeps the markers in an embedded bbolt database, along with
+// a history of the markers committed for every key. The database can
+// be opened by one process at a time.
+type BoltTracker struct {
+	DB *bolt.DB
+	// Retention is how long history records are kept, forever when 0
+	Retention time.Duration
+
+	// allow changing the time for tests
+	now func() time.Time
+}
+
+// OpenBoltTracker opens or creates the database at path. It fails after
+// a second when another process holds the database.
+func OpenBoltTracker(path string, retention time.Duration) (*BoltTracker, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil, fmt.Errorf("tracker database %s is in use by another process", path)
+		}
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(latestBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltTracker{DB: db, Retention: retention}, nil
+}
+
+// Close closes the database
+func (b *BoltTracker) Close() error {
+	return b.DB.Close()
+}
+
+// ReadMarker reads the marker of key, or returns "" when there is none yet
+func (b *BoltTracker) ReadMarker(ctx context.Context, key Key) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	var marker string
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		marker = string(tx.Bucket(latestBucket).Get([]byte(key.String())))
+		return nil
+	})
+	return marker, err
+}
+
+// WriteMarker stores the marker of key and adds it to the history unless it
+// didn't change
+func (b *BoltTracker) WriteMarker(ctx context.Context, key Key, marker string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.write(key, marker, false)
+}
+
+// Keys lists the keys with a marker
+func (b *BoltTracker) Keys() ([]Key, error) {
+	var keys []Key
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(latestBucket).ForEach(func(k, _ []byte) error {
+			key, err := ParseKey(string(k))
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// History returns the history records of key, oldest first, only those of
+// logFile unless it is empty
+func (b *BoltTracker) History(key Key, logFile string) ([]MarkerRecord, error) {
+	var records []MarkerRecord
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(key.String()))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(_, v []byte) error {
+			var record MarkerRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if logFile == "" || record.LogFile == logFile {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Rewind makes the marker of the history record id the latest marker of
+// key, so the next stream of key starts over from it. The rewind is recorded
+// in the history.
+func (b *BoltTracker) Rewind(key Key, id uint64) (MarkerRecord, error) {
+	var record MarkerRecord
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(key.String()))
+		if history == nil {
+			return ErrNoMarker
+		}
+		v := history.Get(itob(id))
+		if v == nil {
+			return ErrNoMarker
+		}
+		return json.Unmarshal(v, &record)
+	})
+	if err != nil {
+		return MarkerRecord{}, err
+	}
+	return record, b.write(key, record.Value, true)
+}
+
+func (b *BoltTracker) write(key Key, marker string, rewind bool) error {
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+
+	name := []byte(key.String())
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		latest := tx.Bucket(latestBucket)
+		unchanged := string(latest.Get(name)) == marker
+		if err := latest.Put(name, []byte(marker)); err != nil {
+			return err
+		}
+		if unchanged && !rewind {
+			return nil
+		}
+
+		history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+		id, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		record := MarkerRecord{ID: id, Time: now().UTC(), Value: marker, Rewind: rewind}
+		var fields struct {
+			LogFile struct{ LogFileName string }
+			Marker  string
+		}
+		if json.Unmarshal([]byte(marker), &fields) == nil {
+			record.LogFile, record.Marker = fields.LogFile.LogFileName, fields.Marker
+		}
+		v, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := history.Put(itob(id), v); err != nil {
+			return err
+		}
+		return b.prune(history, record.Time)
+	})
+}
+
+// prune deletes the history records older than the retention, but never the
+// last one
+func (b *BoltTracker) prune(history *bolt.Bucket, now time.Time) error {
+	if b.Retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-b.Retention)
+	c := history.Cursor()
+	lastKey, _ := c.Last()
+	var expired [][]byte
+	for k, v := c.First(); k != nil && string(k) != string(lastKey); k, v = c.Next() {
+		var record MarkerRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		if !record.Time.Before(cutoff) {
+			break
+		}
+		expired = append(expired, append([]byte(nil), k...))
+	}
+	for _, k := range expired {
+		if err := history.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itob encodes a history record ID as a key sorting in ID order
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
@@ -0,0 +1,110 @@
+package tracker
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestBolt(t *testing.T, retention time.Duration) *BoltTracker {
+	t.Helper()
+	b, err := OpenBoltTracker(filepath.Join(t.TempDir(), "state.db"), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+var (
+	db1 = Key{Account: "123456789012", Region: "us-east-1", Instance: "db1", LogType: "query"}
+	db2 = Key{Account: "123456789012", Region: "us-east-1", Instance: "db2", LogType: "query"}
+)
+
+// read returns the marker of key, failing t on errors
+func read(t *testing.T, tracker Tracker, key Key) string {
+	t.Helper()
+	marker, err := tracker.ReadMarker(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return marker
+}
+
+// write stores the marker of key, failing t on errors
+func write(t *testing.T, tracker Tracker, key Key, marker string) {
+	t.Helper()
+	if err := tracker.WriteMarker(context.Background(), key, marker); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func marker(logFile, marker string) string {
+	return `{"LogFile":{"LogFileName":"` + logFile + `"},"Marker":"` + marker + `"}`
+}
+
+func TestBoltTrackerHistory(t *testing.T) {
+	b := openTestBolt(t, 0)
+	now := time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	if m := read(t, b, db1); m != "" {
+		t.Errorf("expected no marker before the first write, got %q", m)
+	}
+
+	write(t, b, db1, marker("slowquery/mysql-slowquery.log", "8:100"))
+	now = now.Add(time.Minute)
+	// unchanged markers aren't added to the history
+	write(t, b, db1, marker("slowquery/mysql-slowquery.log", "8:100"))
+	write(t, b, db1, marker("slowquery/mysql-slowquery.log", "8:200"))
+	write(t, b, db1, marker("slowquery/mysql-slowquery.log.9", "9:0"))
+	write(t, b, db2, marker("slowquery/mysql-slowquery.log", "8:5"))
+
+	if m := read(t, b, db1); m != marker("slowquery/mysql-slowquery.log.9", "9:0") {
+		t.Errorf("unexpected latest marker %q", m)
+	}
+	records, err := b.History(db1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].ID != 1 || records[0].Marker != "8:100" || !records[0].Time.Equal(now.Add(-time.Minute)) ||
+		records[2].LogFile != "slowquery/mysql-slowquery.log.9" || records[2].Marker != "9:0" {
+		t.Errorf("unexpected history %+v", records)
+	}
+	if records, _ := b.History(db1, "slowquery/mysql-slowquery.log"); len(records) != 2 {
+		t.Errorf("expected the 2 records of the log file, got %+v", records)
+	}
+	if keys, _ := b.Keys(); len(keys) != 2 || keys[0] != db1 || keys[1] != db2 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	record, err := b.Rewind(db1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Marker != "8:200" || read(t, b, db1) != marker("slowquery/mysql-slowquery.log", "8:200") {
+		t.Errorf("expected the marker of record 2 to be the latest, got %+v", record)
+	}
+	records, _ = b.History(db1, "")
+	if last := records[len(records)-1]; len(records) != 4 || !last.Rewind || last.Marker != "8:200" {
+		t.Errorf("expected the rewind to be recorded, got %+v", records)
+	}
+	if _, err := b.Rewind(db1, 42); err != ErrNoMarker {
+		t.Errorf("expected ErrNoMarker, got %v", err)
+	}
+}
+
+func TestBoltTrackerRetention(t *testing.T) {
+	b := openTestBolt(t, time.Hour)
+	now := time.Date(2022, 9, 1, 8, 0, 0, 0, time.UTC)
+
+	for i, m := range []string{"8:1", "8:2", "9:1", "10:1"} {
+		b.now = func() time.Time { return now.Add(time.Duration(i) * 40 * time.Minute) }
+		write(t, b, db1, marker("slowquery/mysql-slowquery.log", m))
+	}
+	records, _ := b.History(db1, "")
+	if len(records) != 2 || records[0].Marker != "9:1" || records[1].Marker != "10:1" {
+		t.Errorf("expected the records of the last hour, got %+v", records)
+	}
+}
@@ -0,0 +1,93 @@
+package tracker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// markerFile is the name of the marker file in the directory of a key
+const markerFile = "marker.json"
+
+// FileTracker keeps every marker in a file under
+// Dir/<account>/<region>/<instance>/<log type>/, for single host deployments
+// without Redis. Markers are replaced atomically, so a crash leaves either
+// the old or the new marker. Leases lock a file next to the marker.
+type FileTracker struct {
+	Dir string
+
+	// leases held by this process
+	mu     sync.Mutex
+	leases map[Key]*fileLease
+}
+
+// ReadMarker reads the marker of key, or returns "" when there is none yet
+func (f *FileTracker) ReadMarker(ctx context.Context, key Key) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+// WriteMarker writes the marker of key to a temporary file which is synced
+// and renamed over the marker file
+func (f *FileTracker) WriteMarker(ctx context.Context, key Key, marker string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dir := filepath.Dir(f.path(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, markerFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(marker); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path(key)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// path returns the marker file of key. Path separators in the parts of key
+// are replaced so every key stays in a directory of its own under Dir.
+func (f *FileTracker) path(key Key) string {
+	parts := []string{f.Dir}
+	for _, part := range []string{key.Account, key.Region, key.Instance, key.LogType} {
+		name := strings.NewReplacer("/", "_", `\`, "_").Replace(part)
+		if name == "" || name == "." || name == ".." {
+			name = "_" + name
+		}
+		parts = append(parts, name)
+	}
+	return filepath.Join(append(parts, markerFile)...)
+}
+
+// syncDir persists the rename of a file in dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
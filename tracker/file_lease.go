@@ -0,0 +1,125 @@
+//go:build unix
+
+package tracker
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// leaseFile is the name of the lease file in the directory of a key
+const leaseFile = "lease"
+
+// fileLease is a lease held through the lock of its lease file
+type fileLease struct {
+	file  *os.File
+	lease Lease
+}
+
+// Acquire takes the lease of key with an exclusive lock of its lease file,
+// which also counts the leases of key. The system releases the lock when
+// the process ends, so ttl isn't used.
+func (f *FileTracker) Acquire(ctx context.Context, key Key, owner string, ttl time.Duration) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return Lease{}, err
+	}
+	dir := filepath.Dir(f.path(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Lease{}, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, leaseFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return Lease{}, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return Lease{}, ErrLeaseHeld
+		}
+		return Lease{}, err
+	}
+
+	token, err := nextToken(file)
+	if err != nil {
+		file.Close()
+		return Lease{}, err
+	}
+	lease := Lease{Key: key, Owner: owner, Token: token}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.leases == nil {
+		f.leases = make(map[Key]*fileLease)
+	}
+	f.leases[key] = &fileLease{file: file, lease: lease}
+	return lease, nil
+}
+
+// Renew checks that lease is still held
+func (f *FileTracker) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.held(lease) == nil {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release unlocks the lease file of lease
+func (f *FileTracker) Release(ctx context.Context, lease Lease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	held := f.held(lease)
+	if held == nil {
+		return ErrLeaseLost
+	}
+	delete(f.leases, lease.Key)
+	return held.file.Close()
+}
+
+// WriteFencedMarker writes the marker of the key of lease while lease is
+// held
+func (f *FileTracker) WriteFencedMarker(ctx context.Context, lease Lease, marker string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.held(lease) == nil {
+		return ErrLeaseLost
+	}
+	return f.WriteMarker(ctx, lease.Key, marker)
+}
+
+// held returns the held lease matching lease, if any
+func (f *FileTracker) held(lease Lease) *fileLease {
+	held := f.leases[lease.Key]
+	if held == nil || held.lease != lease {
+		return nil
+	}
+	return held
+}
+
+// nextToken increments the count of leases kept in the locked file
+func nextToken(file *os.File) (int64, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	var token int64
+	if s := strings.TrimSpace(string(data)); s != "" {
+		if token, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	token++
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.FormatInt(token, 10)), 0); err != nil {
+		return 0, err
+	}
+	return token, file.Sync()
+}
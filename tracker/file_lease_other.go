@@ -0,0 +1,35 @@
+//go:build !unix
+
+package tracker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// fileLease is a lease held through the lock of its lease file
+type fileLease struct{}
+
+// errFileLeases is returned where files can't be locked
+var errFileLeases = errors.New("file tracker leases are not supported on this system")
+
+// Acquire fails, file locks are not supported
+func (f *FileTracker) Acquire(ctx context.Context, key Key, owner string, ttl time.Duration) (Lease, error) {
+	return Lease{}, errFileLeases
+}
+
+// Renew fails, file locks are not supported
+func (f *FileTracker) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	return ErrLeaseLost
+}
+
+// Release fails, file locks are not supported
+func (f *FileTracker) Release(ctx context.Context, lease Lease) error {
+	return ErrLeaseLost
+}
+
+// WriteFencedMarker fails, file locks are not supported
+func (f *FileTracker) WriteFencedMarker(ctx context.Context, lease Lease, marker string) error {
+	return ErrLeaseLost
+}
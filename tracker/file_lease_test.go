@@ -0,0 +1,40 @@
+//go:build unix
+
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFileTrackerLeases(t *testing.T) {
+	dir := t.TempDir()
+	a, b := &FileTracker{Dir: dir}, &FileTracker{Dir: dir}
+	testLeaser(t, a, b, func(lease Lease) {
+		// the lock is released with the file, as when the process ends
+		a.leases[lease.Key].file.Close()
+		delete(a.leases, lease.Key)
+	})
+}
+
+func TestFileTrackerLeaseTokensSurviveRestarts(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	f := &FileTracker{Dir: dir}
+	first, err := f.Acquire(ctx, db1, "a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new tracker, as after a restart
+	second, err := (&FileTracker{Dir: dir}).Acquire(ctx, db1, "a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Token != 1 || second.Token != 2 {
+		t.Errorf("expected tokens 1 and 2, got %d and %d", first.Token, second.Token)
+	}
+}
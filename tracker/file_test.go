@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTracker(t *testing.T) {
+	dir := t.TempDir()
+	tracker := &FileTracker{Dir: dir}
+
+	if marker := read(t, tracker, db1); marker != "" {
+		t.Errorf("expected no marker before the first write, got %q", marker)
+	}
+
+	write(t, tracker, db1, `{"Marker":"10:100"}`)
+	write(t, tracker, db1, `{"Marker":"10:200"}`)
+	write(t, tracker, db2, `{"Marker":"11:5"}`)
+	db1Audit := db1
+	db1Audit.LogType = "audit"
+	write(t, tracker, db1Audit, `{"Marker":"10:7"}`)
+
+	// a new tracker, as after a restart
+	tracker = &FileTracker{Dir: dir}
+	if marker := read(t, tracker, db1); marker != `{"Marker":"10:200"}` {
+		t.Errorf("expected the last marker of db1, got %q", marker)
+	}
+	if marker := read(t, tracker, db2); marker != `{"Marker":"11:5"}` {
+		t.Errorf("expected the marker of db2, got %q", marker)
+	}
+	if marker := read(t, tracker, db1Audit); marker != `{"Marker":"10:7"}` {
+		t.Errorf("expected the marker of the audit log of db1, got %q", marker)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "123456789012", "us-east-1", "db1", "query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != markerFile {
+		t.Errorf("expected only the marker file to be left, got %v", entries)
+	}
+}
+
+func TestFileTrackerKeepsKeysInDir(t *testing.T) {
+	dir := t.TempDir()
+	tracker := &FileTracker{Dir: filepath.Join(dir, "state")}
+
+	key := Key{Account: "..", Region: "", Instance: "../escape", LogType: "query"}
+	write(t, tracker, key, "1:1")
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Errorf("expected the marker to stay in the state directory, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state", "_..", "_", ".._escape", "query", markerFile)); err != nil {
+		t.Errorf("expected the marker in the directory of its key, got %v", err)
+	}
+	if marker := read(t, tracker, key); marker != "1:1" {
+		t.Errorf("expected the marker to be read back, got %q", marker)
+	}
+}
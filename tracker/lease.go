@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrLeaseHeld is returned by Acquire while another owner holds the lease
+var ErrLeaseHeld = errors.New("lease is held by another owner")
+
+// ErrLeaseLost is returned for leases which expired or were taken over
+var ErrLeaseLost = errors.New("lease was lost")
+
+// Lease is the right of Owner to stream the log of Key. Token grows with
+// every acquisition of the lease of Key, so the markers of earlier holders
+// can be refused.
+type Lease struct {
+	Key   Key
+	Owner string
+	Token int64
+}
+
+// String returns the lease as owner/token
+func (l Lease) String() string {
+	return l.Owner + "/" + strconv.FormatInt(l.Token, 10)
+}
+
+// Leaser is implemented by trackers which can elect the one of several
+// rdslogs processes streaming a key. A lease is held until it isn't renewed
+// within its ttl or is released.
+type Leaser interface {
+	// Acquire takes the lease of key for owner, or returns ErrLeaseHeld
+	Acquire(ctx context.Context, key Key, owner string, ttl time.Duration) (Lease, error)
+	// Renew extends lease by ttl, or returns ErrLeaseLost
+	Renew(ctx context.Context, lease Lease, ttl time.Duration) error
+	// Release gives lease up, or returns ErrLeaseLost
+	Release(ctx context.Context, lease Lease) error
+	// WriteFencedMarker stores the marker of the key of lease while lease is
+	// held, and returns ErrLeaseLost otherwise
+	WriteFencedMarker(ctx context.Context, lease Lease, marker string) error
+}
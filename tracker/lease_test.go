@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testLeaser checks the leases of two replicas a and b sharing their
+// markers. expire makes the lease of a expire, as if its process died.
+func testLeaser(t *testing.T, a, b Leaser, expire func(lease Lease)) {
+	t.Helper()
+	ctx := context.Background()
+	ttl := time.Minute
+
+	first, err := a.Acquire(ctx, db1, "replica-a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire(ctx, db1, "replica-b", ttl); err != ErrLeaseHeld {
+		t.Fatalf("expected the lease to be held, got %v", err)
+	}
+	if lease, err := b.Acquire(ctx, db2, "replica-b", ttl); err != nil || lease.Owner != "replica-b" {
+		t.Fatalf("expected the lease of another key, got %+v %v", lease, err)
+	}
+	if err := a.Renew(ctx, first, ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteFencedMarker(ctx, first, "10:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expire(first)
+	second, err := b.Acquire(ctx, db1, "replica-b", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token <= first.Token {
+		t.Errorf("expected the token to grow, got %d after %d", second.Token, first.Token)
+	}
+	if marker := read(t, b.(Tracker), db1); marker != "10:1" {
+		t.Errorf("expected the new leader to read the last marker, got %q", marker)
+	}
+	if err := a.Renew(ctx, first, ttl); err != ErrLeaseLost {
+		t.Errorf("expected the old lease to be lost, got %v", err)
+	}
+	if err := a.WriteFencedMarker(ctx, first, "10:2"); err != ErrLeaseLost {
+		t.Errorf("expected the marker of the old leader to be refused, got %v", err)
+	}
+	if err := b.WriteFencedMarker(ctx, second, "10:3"); err != nil {
+		t.Fatal(err)
+	}
+	if marker := read(t, a.(Tracker), db1); marker != "10:3" {
+		t.Errorf("expected the marker of the new leader, got %q", marker)
+	}
+
+	if err := b.Release(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Release(ctx, second); err != ErrLeaseLost {
+		t.Errorf("expected a released lease to be lost, got %v", err)
+	}
+	if _, err := a.Acquire(ctx, db1, "replica-a", ttl); err != nil {
+		t.Errorf("expected the released lease to be free, got %v", err)
+	}
+}
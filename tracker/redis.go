@@ -1,81 +1,238 @@
 package tracker
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
 	"github.com/gomodule/redigo/redis"
 	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/constants"
 	log "github.com/sirupsen/logrus"
 )
 
 // RedisTracker it supports redis backend
 type RedisTracker struct {
-	Key   string
-	Value string
-	Pool  *redis.Pool
+	// Pool hands out the connections in standalone and sentinel mode
+	Pool *redis.Pool
+	// Cluster runs the commands in cluster mode, instead of Pool
+	Cluster *RedisCluster
+	// KeyPrefix is prepended to the keys of the markers
+	KeyPrefix string
+	// TTL expires markers which weren't written for that long, never when 0
+	TTL time.Duration
 }
 
-//RedisConn ....
-type RedisConn struct {
-	c redis.Conn
+// NewRedisTracker returns a tracker for the standalone redis, the master
+// monitored by sentinels or the redis cluster of cfg. Connections are only
+// made by the first tracker call.
+func NewRedisTracker(cfg config.RedisConfig) (*RedisTracker, error) {
+	log.Debug("Creating Connection")
+	options, err := dialOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RedisTracker{KeyPrefix: cfg.KeyPrefix, TTL: cfg.TTL}
+	switch cfg.Mode {
+	case constants.RedisModeStandalone, "":
+		options = withOptions(options, redis.DialPassword(cfg.Password), redis.DialDatabase(cfg.Database))
+		addr := net.JoinHostPort(cfg.Host, cfg.Port)
+		r.Pool = newPool(cfg, func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", addr, options...)
+		}, nil)
+	case constants.RedisModeSentinel:
+		if cfg.SentinelMaster == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel mode needs the master name and the sentinel addresses")
+		}
+		r.Pool = newPool(cfg, func(ctx context.Context) (redis.Conn, error) {
+			return dialSentinelMaster(ctx, cfg, options)
+		}, checkMaster)
+	case constants.RedisModeCluster:
+		seeds := cfg.Addrs
+		if len(seeds) == 0 {
+			seeds = []string{net.JoinHostPort(cfg.Host, cfg.Port)}
+		}
+		options = withOptions(options, redis.DialPassword(cfg.Password))
+		r.Cluster = NewRedisCluster(seeds, func(addr string) *redis.Pool {
+			return newPool(cfg, func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", addr, options...)
+			}, nil)
+		})
+	default:
+		return nil, fmt.Errorf("unsupported redis mode: `%s`", cfg.Mode)
+	}
+	return r, nil
 }
 
-//ReadLatestMarker read the marker
-func (r *RedisTracker) ReadLatestMarker(dbname string) string {
-	conn := RedisConn{c: r.Pool.Get()}
-	data, _ := conn.get(dbname)
-	defer conn.c.Close()
-	return data
+// Close closes the connections of the tracker
+func (r *RedisTracker) Close() error {
+	if r.Cluster != nil {
+		return r.Cluster.Close()
+	}
+	return r.Pool.Close()
 }
 
-//WriteLatestMarker read the marker
-func (r *RedisTracker) WriteLatestMarker(dbname string, marker string) {
-	conn := RedisConn{c: r.Pool.Get()}
-	conn.set(dbname, marker)
-	defer conn.c.Close()
+// ReadMarker reads the marker of key. Markers of the query log stored by
+// older versions under <instance>.marker are read when key has none yet.
+func (r *RedisTracker) ReadMarker(ctx context.Context, key Key) (string, error) {
+	marker, err := redis.String(r.do(ctx, "GET", r.redisKey(key)))
+	if err == redis.ErrNil && key.LogType == constants.LogTypeQuery {
+		marker, err = redis.String(r.do(ctx, "GET", key.Instance+".marker"))
+	}
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return marker, err
 }
 
-// NewPool ....
-func NewPool() *redis.Pool {
-	log.Debug("Creating Connection")
-	dbconfig := config.RedisDBConfig
+// WriteMarker stores the marker of key, expiring after TTL
+func (r *RedisTracker) WriteMarker(ctx context.Context, key Key, marker string) error {
+	args := []interface{}{marker}
+	if r.TTL > 0 {
+		args = append(args, "PX", r.TTL.Milliseconds())
+	}
+	_, err := r.do(ctx, "SET", r.redisKey(key), args...)
+	return err
+}
+
+// redisKey returns the redis key of the marker of key. The braces keep the
+// keys of key in one hash slot of a cluster, for the transactions of leases.
+func (r *RedisTracker) redisKey(key Key) string {
+	return r.KeyPrefix + "{" + key.String() + "}.marker"
+}
 
+// do runs the command cmd on key with args
+func (r *RedisTracker) do(ctx context.Context, cmd string, key string, args ...interface{}) (interface{}, error) {
+	if r.Cluster != nil {
+		return r.Cluster.Do(ctx, cmd, key, args...)
+	}
+	conn, err := r.Pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redis.DoContext(conn, ctx, cmd, append([]interface{}{key}, args...)...)
+}
+
+// newPool returns a pool of the connections made by dial. Connections idle
+// for longer than cfg.HealthCheck are checked with PING when borrowed, or
+// every time with check when it is set.
+func newPool(cfg config.RedisConfig, dial func(ctx context.Context) (redis.Conn, error), check func(redis.Conn) error) *redis.Pool {
 	return &redis.Pool{
 		// Maximum number of idle connections in the pool.
-		MaxIdle: dbconfig.MaxIdle,
+		MaxIdle: cfg.MaxIdle,
 		// max number of connections
-		MaxActive: dbconfig.MaxActive,
-		// Dial is an application supplied function for creating and
-		// configuring a connection.
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial(
-				"tcp",
-				dbconfig.Host+":"+dbconfig.Port,
-				redis.DialPassword(dbconfig.Password),
-				redis.DialDatabase(dbconfig.Database))
-			if err != nil {
-				panic(err.Error())
+		MaxActive:   cfg.MaxActive,
+		IdleTimeout: cfg.IdleTimeout,
+		DialContext: dial,
+		TestOnBorrow: func(c redis.Conn, lastUsed time.Time) error {
+			if check != nil {
+				return check(c)
+			}
+			if time.Since(lastUsed) < cfg.HealthCheck {
+				return nil
 			}
-			return c, err
+			_, err := c.Do("PING")
+			return err
 		},
 	}
 }
 
-// set executes the redis SET command
-func (rc RedisConn) set(dbname string, marker string) error {
-	_, err := rc.c.Do("SET", dbname+".marker", marker)
-	if err != nil {
-		log.Error(err)
-		return err
+// dialOptions returns the TLS options of cfg
+func dialOptions(cfg config.RedisConfig) ([]redis.DialOption, error) {
+	if !cfg.TLS {
+		return nil, nil
 	}
-	return nil
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}
+	if cfg.TLSCAFile != "" {
+		ca, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+	}
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return []redis.DialOption{redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig)}, nil
 }
 
-func (rc RedisConn) get(dbname string) (string, error) {
-	// Simple GET example with String helper
-	key := dbname + ".marker"
-	s, err := redis.String(rc.c.Do("GET", key))
+// withOptions returns a copy of options with more added
+func withOptions(options []redis.DialOption, more ...redis.DialOption) []redis.DialOption {
+	return append(append([]redis.DialOption(nil), options...), more...)
+}
+
+// dialSentinelMaster asks the sentinels in turn for the address of the
+// master and connects to it
+func dialSentinelMaster(ctx context.Context, cfg config.RedisConfig, options []redis.DialOption) (redis.Conn, error) {
+	var lastErr error
+	for _, sentinel := range cfg.Addrs {
+		addr, err := sentinelMasterAddr(ctx, sentinel, cfg, options)
+		if err != nil {
+			lastErr = fmt.Errorf("sentinel %s: %w", sentinel, err)
+			continue
+		}
+		conn, err := redis.DialContext(ctx, "tcp", addr,
+			withOptions(options, redis.DialPassword(cfg.Password), redis.DialDatabase(cfg.Database))...)
+		if err != nil {
+			lastErr = fmt.Errorf("master %s: %w", addr, err)
+			continue
+		}
+		// the sentinel may not have noticed a failover yet
+		if err := checkMaster(conn); err != nil {
+			conn.Close()
+			lastErr = fmt.Errorf("master %s: %w", addr, err)
+			continue
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("no redis master %s found: %w", cfg.SentinelMaster, lastErr)
+}
+
+// sentinelMasterAddr asks sentinel for the address of the master
+func sentinelMasterAddr(ctx context.Context, sentinel string, cfg config.RedisConfig, options []redis.DialOption) (string, error) {
+	conn, err := redis.DialContext(ctx, "tcp", sentinel, withOptions(options, redis.DialPassword(cfg.SentinelPassword))...)
 	if err != nil {
-		log.Error(err)
 		return "", err
 	}
-	return s, nil
+	defer conn.Close()
+	addr, err := redis.Strings(redis.DoContext(conn, ctx, "SENTINEL", "get-master-addr-by-name", cfg.SentinelMaster))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("unknown master %s", cfg.SentinelMaster)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(addr) != 2 {
+		return "", fmt.Errorf("unexpected master address %v", addr)
+	}
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+// checkMaster fails unless conn is connected to a master. Pooled connections
+// to a master demoted by a failover are dropped by it.
+func checkMaster(conn redis.Conn) error {
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(role) == 0 {
+		return fmt.Errorf("empty ROLE reply")
+	}
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("redis server is a %s, not the master", name)
+	}
+	return nil
 }
@@ -0,0 +1,257 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// clusterSlots is the number of hash slots of a redis cluster
+const clusterSlots = 16384
+
+// maxRedirects is how many MOVED and ASK redirections a command follows
+const maxRedirects = 5
+
+type slotRange struct {
+	start, end int
+	addr       string
+}
+
+// RedisCluster runs commands on the node of a redis cluster serving the
+// hash slot of their key. The slot layout is read with CLUSTER SLOTS from
+// the seed nodes and refreshed when a node answers MOVED or can't be
+// reached.
+type RedisCluster struct {
+	seeds   []string
+	newPool func(addr string) *redis.Pool
+
+	mu    sync.Mutex
+	pools map[string]*redis.Pool
+	slots []slotRange
+}
+
+// NewRedisCluster returns a cluster discovered from seeds, connecting to its
+// nodes with the pools made by newPool
+func NewRedisCluster(seeds []string, newPool func(addr string) *redis.Pool) *RedisCluster {
+	return &RedisCluster{
+		seeds:   seeds,
+		newPool: newPool,
+		pools:   make(map[string]*redis.Pool),
+	}
+}
+
+// Do runs the command cmd on key with args
+func (c *RedisCluster) Do(ctx context.Context, cmd string, key string, args ...interface{}) (interface{}, error) {
+	addr, err := c.node(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	cmdArgs := append([]interface{}{key}, args...)
+	asking := false
+	for i := 0; i <= maxRedirects; i++ {
+		reply, err := c.doOn(ctx, addr, asking, cmd, cmdArgs)
+		if err == nil {
+			return reply, nil
+		}
+
+		var redisErr redis.Error
+		if !errors.As(err, &redisErr) {
+			// the node may be gone after a failover
+			if i > 0 || c.refresh(ctx) != nil {
+				return nil, err
+			}
+			next, nerr := c.node(ctx, key)
+			if nerr != nil || next == addr {
+				return nil, err
+			}
+			addr, asking = next, false
+			continue
+		}
+		kind, target := redirection(redisErr)
+		switch kind {
+		case "MOVED":
+			// the layout changed, the next commands of the slot go there too
+			c.refresh(ctx)
+			addr, asking = target, false
+		case "ASK":
+			addr, asking = target, true
+		default:
+			return reply, err
+		}
+	}
+	return nil, fmt.Errorf("too many redirections for key %s", key)
+}
+
+// Conn returns a connection to the node serving key
+func (c *RedisCluster) Conn(ctx context.Context, key string) (redis.Conn, error) {
+	addr, err := c.node(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.pool(addr).GetContext(ctx)
+}
+
+// Close closes the connections to the nodes
+func (c *RedisCluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for addr, pool := range c.pools {
+		if cerr := pool.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(c.pools, addr)
+	}
+	return err
+}
+
+// doOn runs cmd on the node at addr, after ASKING when following an ASK
+// redirection
+func (c *RedisCluster) doOn(ctx context.Context, addr string, asking bool, cmd string, args []interface{}) (interface{}, error) {
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if asking {
+		if _, err := redis.DoContext(conn, ctx, "ASKING"); err != nil {
+			return nil, err
+		}
+	}
+	return redis.DoContext(conn, ctx, cmd, args...)
+}
+
+// node returns the address of the node serving the slot of key
+func (c *RedisCluster) node(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	known := len(c.slots) > 0
+	c.mu.Unlock()
+	if !known {
+		if err := c.refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	slot := clusterSlot(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.slots {
+		if slot >= r.start && slot <= r.end {
+			return r.addr, nil
+		}
+	}
+	return "", fmt.Errorf("no redis cluster node serves slot %d", slot)
+}
+
+// pool returns the pool of the node at addr
+func (c *RedisCluster) pool(addr string) *redis.Pool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pool, ok := c.pools[addr]
+	if !ok {
+		pool = c.newPool(addr)
+		c.pools[addr] = pool
+	}
+	return pool
+}
+
+// refresh reads the slot layout from the first seed or known node that
+// answers CLUSTER SLOTS
+func (c *RedisCluster) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	addrs := append([]string(nil), c.seeds...)
+	for _, r := range c.slots {
+		addrs = append(addrs, r.addr)
+	}
+	c.mu.Unlock()
+
+	var lastErr error
+	for _, addr := range addrs {
+		slots, err := c.readSlots(ctx, addr)
+		if err != nil {
+			lastErr = fmt.Errorf("redis cluster node %s: %w", addr, err)
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return lastErr
+}
+
+// readSlots reads the slot layout known by the node at addr
+func (c *RedisCluster) readSlots(ctx context.Context, addr string) ([]slotRange, error) {
+	conn, err := c.pool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ranges, err := redis.Values(redis.DoContext(conn, ctx, "CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []slotRange
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply %v", r)
+		}
+		start, _ := redis.Int(fields[0], nil)
+		end, _ := redis.Int(fields[1], nil)
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply %v", r)
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if host == "" {
+			// the node answering doesn't know its own address
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		slots = append(slots, slotRange{start: start, end: end, addr: net.JoinHostPort(host, strconv.Itoa(port))})
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("no slots assigned")
+	}
+	return slots, nil
+}
+
+// redirection returns the kind, MOVED or ASK, and target address of a
+// redirection error
+func redirection(err redis.Error) (string, string) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", ""
+	}
+	return fields[0], fields[2]
+}
+
+// clusterSlot returns the hash slot of key: the CRC16 of key, or of its
+// hash tag between braces, modulo the number of slots
+func clusterSlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlots
+}
@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/redistest"
+)
+
+func TestClusterSlot(t *testing.T) {
+	if slot := clusterSlot("123456789"); slot != 12739 {
+		t.Errorf("expected slot 12739, got %d", slot)
+	}
+	if clusterSlot("{user1000}.following") != clusterSlot("{user1000}.followers") {
+		t.Error("expected keys with the same hash tag in the same slot")
+	}
+	if clusterSlot("{}.following") == clusterSlot("{}.followers") {
+		t.Error("expected empty hash tags to be ignored")
+	}
+	for _, key := range []string{"", "db1.marker", redisKey(db1), redisKey(db2)} {
+		if clusterSlot(key) != redistest.Slot(key) {
+			t.Errorf("slot of %q differs from the redis stand-in", key)
+		}
+	}
+}
+
+func TestRedisTrackerCluster(t *testing.T) {
+	node1, node2 := newTestRedis(t, false), newTestRedis(t, false)
+	layout := []redistest.SlotRange{
+		{Start: 0, End: 8191, Addr: node1.Addr()},
+		{Start: 8192, End: 16383, Addr: node2.Addr()},
+	}
+	node1.SetSlots(layout...)
+	node2.SetSlots(layout...)
+
+	r := newTestRedisTracker(t, config.RedisConfig{
+		Mode:    constants.RedisModeCluster,
+		Addrs:   []string{node2.Addr()},
+		MaxIdle: 2,
+	})
+
+	// find keys served by either node
+	keys := map[*redistest.Server]Key{}
+	for i := 0; len(keys) < 2; i++ {
+		key := Key{Account: "123456789012", Region: "us-east-1", Instance: "db" + string(rune('a'+i)), LogType: "query"}
+		node := node1
+		if redistest.Slot(redisKey(key)) > 8191 {
+			node = node2
+		}
+		keys[node] = key
+	}
+	for node, key := range keys {
+		write(t, r, key, key.Instance)
+		if value, ok := node.Get(redisKey(key)); !ok || value != key.Instance {
+			t.Errorf("expected the marker of %s on the node of its slot", key)
+		}
+		if marker := read(t, r, key); marker != key.Instance {
+			t.Errorf("expected the marker of %s, got %q", key, marker)
+		}
+	}
+
+	// resharding: node2 takes over the slots of node1
+	layout = []redistest.SlotRange{{Start: 0, End: 16383, Addr: node2.Addr()}}
+	node1.SetSlots(layout...)
+	node2.SetSlots(layout...)
+	moved := keys[node1]
+	write(t, r, moved, "after resharding")
+	if value, _ := node2.Get(redisKey(moved)); value != "after resharding" {
+		t.Errorf("expected the marker to follow the MOVED redirection, got %q", value)
+	}
+	calls := node1.Calls("SET")
+	write(t, r, moved, "again")
+	if node1.Calls("SET") != calls {
+		t.Error("expected the refreshed slot layout to be used")
+	}
+
+	// the lease and marker of a key share a slot for the transactions
+	lease, err := r.Acquire(context.Background(), moved, "replica-a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteFencedMarker(context.Background(), lease, "fenced"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := node2.Get(redisKey(moved)); value != "fenced" {
+		t.Errorf("expected the fenced marker, got %q", value)
+	}
+}
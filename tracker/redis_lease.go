@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Acquire takes the lease of key with SET NX, with a token counted by the
+// fence key of key
+func (r *RedisTracker) Acquire(ctx context.Context, key Key, owner string, ttl time.Duration) (Lease, error) {
+	token, err := redis.Int64(r.do(ctx, "INCR", r.fenceKey(key)))
+	if err != nil {
+		return Lease{}, err
+	}
+	lease := Lease{Key: key, Owner: owner, Token: token}
+	_, err = redis.String(r.do(ctx, "SET", r.leaseKey(key), lease.String(), "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return Lease{}, ErrLeaseHeld
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	return lease, nil
+}
+
+// Renew extends lease by ttl
+func (r *RedisTracker) Renew(ctx context.Context, lease Lease, ttl time.Duration) error {
+	return r.whileHeld(ctx, lease, func(conn redis.Conn) error {
+		return conn.Send("PEXPIRE", r.leaseKey(lease.Key), ttl.Milliseconds())
+	})
+}
+
+// Release deletes lease
+func (r *RedisTracker) Release(ctx context.Context, lease Lease) error {
+	return r.whileHeld(ctx, lease, func(conn redis.Conn) error {
+		return conn.Send("DEL", r.leaseKey(lease.Key))
+	})
+}
+
+// WriteFencedMarker stores the marker of the key of lease in a transaction
+// which fails once lease expired or was taken over
+func (r *RedisTracker) WriteFencedMarker(ctx context.Context, lease Lease, marker string) error {
+	return r.whileHeld(ctx, lease, func(conn redis.Conn) error {
+		args := []interface{}{r.redisKey(lease.Key), marker}
+		if r.TTL > 0 {
+			args = append(args, "PX", r.TTL.Milliseconds())
+		}
+		return conn.Send("SET", args...)
+	})
+}
+
+// whileHeld runs the commands sent by queue in a transaction watching the
+// lease key, so they only run while lease is held
+func (r *RedisTracker) whileHeld(ctx context.Context, lease Lease, queue func(conn redis.Conn) error) error {
+	leaseKey := r.leaseKey(lease.Key)
+	conn, err := r.conn(ctx, leaseKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := redis.DoContext(conn, ctx, "WATCH", leaseKey); err != nil {
+		return r.clusterError(ctx, err)
+	}
+	value, err := redis.String(redis.DoContext(conn, ctx, "GET", leaseKey))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if value != lease.String() {
+		redis.DoContext(conn, ctx, "UNWATCH")
+		return ErrLeaseLost
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	if err := queue(conn); err != nil {
+		return err
+	}
+	_, err = redis.Values(redis.DoContext(conn, ctx, "EXEC"))
+	if err == redis.ErrNil {
+		// the lease key changed since WATCH
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// conn returns a connection to the node serving key
+func (r *RedisTracker) conn(ctx context.Context, key string) (redis.Conn, error) {
+	if r.Cluster != nil {
+		return r.Cluster.Conn(ctx, key)
+	}
+	return r.Pool.GetContext(ctx)
+}
+
+// clusterError refreshes the slot layout of the cluster when err is a
+// redirection, which transactions don't follow
+func (r *RedisTracker) clusterError(ctx context.Context, err error) error {
+	var redisErr redis.Error
+	if r.Cluster != nil && errors.As(err, &redisErr) {
+		if kind, _ := redirection(redisErr); kind != "" {
+			r.Cluster.refresh(ctx)
+		}
+	}
+	return err
+}
+
+// leaseKey returns the redis key of the lease of key, in the hash slot of
+// its marker
+func (r *RedisTracker) leaseKey(key Key) string {
+	return r.KeyPrefix + "{" + key.String() + "}.lease"
+}
+
+// fenceKey returns the redis key counting the leases of key
+func (r *RedisTracker) fenceKey(key Key) string {
+	return r.KeyPrefix + "{" + key.String() + "}.fence"
+}
@@ -0,0 +1,213 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/razorpay/rdslogs/config"
+	"github.com/razorpay/rdslogs/constants"
+	"github.com/razorpay/rdslogs/redistest"
+)
+
+func newTestRedis(t *testing.T, tls bool) *redistest.Server {
+	t.Helper()
+	newServer := redistest.NewServer
+	if tls {
+		newServer = redistest.NewTLSServer
+	}
+	s, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// redisConfig returns the config of a standalone redis at addr
+func redisConfig(addr string) config.RedisConfig {
+	host, port, _ := net.SplitHostPort(addr)
+	return config.RedisConfig{Host: host, Port: port, MaxIdle: 2, HealthCheck: time.Minute}
+}
+
+func newTestRedisTracker(t *testing.T, cfg config.RedisConfig) *RedisTracker {
+	t.Helper()
+	r, err := NewRedisTracker(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRedisTracker(t *testing.T) {
+	s := newTestRedis(t, false)
+	s.RequireAuth("s3cret")
+	cfg := redisConfig(s.Addr())
+	cfg.Password = "s3cret"
+	cfg.KeyPrefix = "rdslogs:"
+	cfg.TTL = time.Hour
+	r := newTestRedisTracker(t, cfg)
+
+	if marker := read(t, r, db1); marker != "" {
+		t.Errorf("expected no marker before the first write, got %q", marker)
+	}
+	write(t, r, db1, "10:100")
+	db1Audit := db1
+	db1Audit.LogType = "audit"
+	write(t, r, db1Audit, "10:7")
+
+	if marker := read(t, r, db1); marker != "10:100" {
+		t.Errorf("expected the marker of db1, got %q", marker)
+	}
+	if marker := read(t, r, db1Audit); marker != "10:7" {
+		t.Errorf("expected the marker of the audit log of db1, got %q", marker)
+	}
+	key := "rdslogs:{123456789012/us-east-1/db1/query}.marker"
+	if value, ok := s.Get(key); !ok || value != "10:100" {
+		t.Errorf("expected the marker under %s, got %q", key, value)
+	}
+	if ttl := s.TTL(key); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("expected the marker to expire in an hour, got %s", ttl)
+	}
+	s.FastForward(2 * time.Hour)
+	if marker := read(t, r, db1); marker != "" {
+		t.Errorf("expected the marker to expire, got %q", marker)
+	}
+}
+
+func TestRedisTrackerReadsLegacyMarkers(t *testing.T) {
+	s := newTestRedis(t, false)
+	s.Set("db1.marker", "9:5")
+	r := newTestRedisTracker(t, redisConfig(s.Addr()))
+
+	if marker := read(t, r, db1); marker != "9:5" {
+		t.Errorf("expected the marker of older versions, got %q", marker)
+	}
+	db1Audit := db1
+	db1Audit.LogType = constants.LogTypeAudit
+	if marker := read(t, r, db1Audit); marker != "" {
+		t.Errorf("expected no marker for the audit log, got %q", marker)
+	}
+}
+
+func TestRedisTrackerReturnsErrors(t *testing.T) {
+	s := newTestRedis(t, false)
+	s.RequireAuth("s3cret")
+	r := newTestRedisTracker(t, redisConfig(s.Addr()))
+
+	if _, err := r.ReadMarker(context.Background(), db1); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("expected the authentication error, got %v", err)
+	}
+	s.Close()
+	if err := r.WriteMarker(context.Background(), db1, "10:1"); err == nil {
+		t.Error("expected an error without redis")
+	}
+}
+
+func TestRedisTrackerChecksIdleConnections(t *testing.T) {
+	s := newTestRedis(t, false)
+	r := newTestRedisTracker(t, redisConfig(s.Addr()))
+	write(t, r, db1, "10:1")
+	// the pooled connection is broken by a restart of redis and only
+	// checked after a minute
+	s.CloseClients()
+	if _, err := r.ReadMarker(context.Background(), db1); err == nil {
+		t.Error("expected the broken connection to fail")
+	}
+
+	cfg := redisConfig(s.Addr())
+	cfg.HealthCheck = 0
+	r = newTestRedisTracker(t, cfg)
+	write(t, r, db1, "10:2")
+	s.CloseClients()
+	if marker := read(t, r, db1); marker != "10:2" {
+		t.Errorf("expected the marker through a new connection, got %q", marker)
+	}
+}
+
+func TestRedisTrackerTLS(t *testing.T) {
+	s := newTestRedis(t, true)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, s.CertPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := redisConfig(s.Addr())
+	cfg.TLS = true
+	if err := newTestRedisTracker(t, cfg).WriteMarker(context.Background(), db1, "10:1"); err == nil {
+		t.Error("expected the certificate of an unknown authority to be refused")
+	}
+
+	cfg.TLSCAFile = ca
+	r := newTestRedisTracker(t, cfg)
+	write(t, r, db1, "10:1")
+	if marker := read(t, r, db1); marker != "10:1" {
+		t.Errorf("expected the marker over TLS, got %q", marker)
+	}
+}
+
+func TestRedisTrackerSentinel(t *testing.T) {
+	master, replica := newTestRedis(t, false), newTestRedis(t, false)
+	replica.SetRole(redistest.RoleSlave)
+	sentinel := newTestRedis(t, false)
+	sentinel.RequireAuth("sentinel")
+	sentinel.SetMaster("markers", master.Addr())
+
+	cfg := config.RedisConfig{
+		Mode:             constants.RedisModeSentinel,
+		Addrs:            []string{"127.0.0.1:1", sentinel.Addr()},
+		SentinelMaster:   "markers",
+		SentinelPassword: "sentinel",
+		MaxIdle:          2,
+	}
+	r := newTestRedisTracker(t, cfg)
+	write(t, r, db1, "10:1")
+	if _, ok := master.Get(redisKey(db1)); !ok {
+		t.Error("expected the marker on the master")
+	}
+
+	// failover: the pooled connection to the demoted master is dropped
+	master.SetRole(redistest.RoleSlave)
+	replica.SetRole(redistest.RoleMaster)
+	sentinel.SetMaster("markers", replica.Addr())
+	write(t, r, db1, "10:2")
+	if value, _ := replica.Get(redisKey(db1)); value != "10:2" {
+		t.Errorf("expected the marker on the new master, got %q", value)
+	}
+
+	// sentinels which didn't notice the failover yet point to a replica
+	sentinel.SetMaster("markers", master.Addr())
+	r = newTestRedisTracker(t, cfg)
+	if _, err := r.ReadMarker(context.Background(), db1); err == nil || !strings.Contains(err.Error(), "not the master") {
+		t.Errorf("expected the replica to be refused, got %v", err)
+	}
+}
+
+func TestRedisTrackerLeases(t *testing.T) {
+	s := newTestRedis(t, false)
+	a := newTestRedisTracker(t, redisConfig(s.Addr()))
+	b := newTestRedisTracker(t, redisConfig(s.Addr()))
+	testLeaser(t, a, b, func(Lease) { s.FastForward(2 * time.Minute) })
+}
+
+func TestNewRedisTrackerChecksConfig(t *testing.T) {
+	for _, cfg := range []config.RedisConfig{
+		{Mode: "replicated"},
+		{Mode: constants.RedisModeSentinel, Addrs: []string{"127.0.0.1:26379"}},
+		{TLS: true, TLSCAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if _, err := NewRedisTracker(cfg); err == nil {
+			t.Errorf("expected %+v to be refused", cfg)
+		}
+	}
+}
+
+// redisKey returns the redis key of the marker of key without prefix
+func redisKey(key Key) string {
+	return (&RedisTracker{}).redisKey(key)
+}
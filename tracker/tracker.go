@@ -1,8 +1,40 @@
 package tracker
 
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Key identifies a marker: the log of one type of an instance, in an AWS
+// account and region
+type Key struct {
+	Account  string
+	Region   string
+	Instance string
+	LogType  string
+}
+
+// String returns the key as account/region/instance/logtype
+func (k Key) String() string {
+	return strings.Join([]string{k.Account, k.Region, k.Instance, k.LogType}, "/")
+}
+
+// ParseKey parses a key returned by Key.String
+func ParseKey(s string) (Key, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 4 {
+		return Key{}, fmt.Errorf("invalid tracker key %q", s)
+	}
+	return Key{Account: parts[0], Region: parts[1], Instance: parts[2], LogType: parts[3]}, nil
+}
+
 // Tracker is an interface to store the marker and other logFile related information
 type Tracker interface {
-	// Read and Write latest marker
-	ReadLatestMarker(dbname string) string
-	WriteLatestMarker(dbname string, marker string)
+	// ReadMarker reads the marker of key, or returns "" when there is none
+	// yet. An error means the marker is unknown, not that there is none.
+	ReadMarker(ctx context.Context, key Key) (string, error)
+	// WriteMarker stores the marker of key. The marker is only committed
+	// when it returns nil.
+	WriteMarker(ctx context.Context, key Key, marker string) error
 }